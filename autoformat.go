@@ -0,0 +1,68 @@
+package imagor
+
+import (
+	"github.com/cshum/imagor/imagorpath"
+	"strconv"
+	"strings"
+)
+
+// autoFormats modern formats by order of preference for Accept header negotiation
+var autoFormats = []struct {
+	mimeType string
+	format   string
+}{
+	{"image/avif", "avif"},
+	{"image/webp", "webp"},
+}
+
+// hasFormatFilter whether output format specified by filters
+func hasFormatFilter(filters imagorpath.Filters) bool {
+	for _, f := range filters {
+		if f.Name == "format" || f.Name == "autojpg" {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptFormat best supported modern format advertised by Accept header, empty if none
+func acceptFormat(accept string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mimeType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			}
+		}
+		if q > 0 {
+			accepted[mimeType] = true
+		}
+	}
+	for _, f := range autoFormats {
+		if accepted[f.mimeType] {
+			return f.format
+		}
+	}
+	return ""
+}
+
+// negotiateFormat injects format filter by Accept header if format not specified,
+// path regenerated such that result stored per format variant
+func negotiateFormat(p imagorpath.Params, accept string) imagorpath.Params {
+	if hasFormatFilter(p.Filters) {
+		return p
+	}
+	format := acceptFormat(accept)
+	if format == "" {
+		return p
+	}
+	p.Filters = append(append(imagorpath.Filters{}, p.Filters...), imagorpath.Filter{
+		Name: "format", Args: format,
+	})
+	p.Path = strings.TrimPrefix(imagorpath.GenerateUnsafe(p), "unsafe/")
+	return p
+}
@@ -0,0 +1,93 @@
+package imagor
+
+import (
+	"encoding/json"
+	"github.com/cshum/imagor/imagorpath"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+const (
+	batchPath        = "/batch"
+	maxBatchBodySize = 1 << 20
+)
+
+// serveBatch processes request paths of POST body separated by whitespace,
+// streaming results as multipart/mixed parts in order once each completed.
+// Paths are signed or unsafe as of request URL, each part with X-Imagor-Path and X-Imagor-Status headers.
+// Batch of paths exceeding BatchMaxPaths is rejected with 413
+func (app *Imagor) serveBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBodySize))
+	if err != nil {
+		e := WrapError(err).(Error)
+		w.WriteHeader(e.Code)
+		app.resJSON(w, r, e)
+		return
+	}
+	paths := strings.Fields(string(body))
+	if app.BatchMaxPaths > 0 && len(paths) > app.BatchMaxPaths {
+		w.WriteHeader(ErrMaxPathsExceeded.Code)
+		app.resJSON(w, r, ErrMaxPathsExceeded)
+		return
+	}
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for _, path := range paths {
+		if r.Context().Err() != nil {
+			return
+		}
+		path = "/" + strings.TrimPrefix(path, "/")
+		status := http.StatusOK
+		var contentType string
+		var buf []byte
+		blob, p, err := app.doPath(r, path)
+		if err != nil {
+			e := WrapError(err).(Error)
+			e = app.responseError(e)
+			status = e.Code
+			contentType = "application/json"
+			buf, _ = json.Marshal(e)
+		} else if buf, _ = blob.ReadAll(); p.Meta && blob.Meta != nil {
+			contentType = "application/json"
+			buf, _ = json.Marshal(blob.Meta)
+		} else if blob.Meta != nil {
+			contentType = blob.Meta.ContentType
+		} else {
+			contentType = http.DetectContentType(buf)
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":    {contentType},
+			"Content-Length":  {strconv.Itoa(len(buf))},
+			"X-Imagor-Path":   {path},
+			"X-Imagor-Status": {strconv.Itoa(status)},
+		})
+		if err != nil {
+			return
+		}
+		if _, err = part.Write(buf); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_ = mw.Close()
+}
+
+// doPath executes Imagor operations of request path
+func (app *Imagor) doPath(r *http.Request, path string) (blob *Blob, p imagorpath.Params, err error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, path, nil)
+	if err != nil {
+		return
+	}
+	req.Header = r.Header.Clone()
+	p = imagorpath.Parse(req.URL.EscapedPath())
+	blob, err = app.Do(req, p)
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"sync"
+	"time"
 )
 
 // Blob abstraction for file path, bytes data and meta attributes
@@ -14,17 +15,27 @@ type Blob struct {
 	err  error
 
 	supportsAnimation bool
+	stats             *blobStats
 
 	Meta *Meta
 }
 
+// blobStats processing diagnostics of result blob
+type blobStats struct {
+	processTime time.Duration
+	sourceBytes int
+}
+
 // Meta image attributes
 type Meta struct {
-	Format      string `json:"format"`
-	ContentType string `json:"content_type"`
-	Width       int    `json:"width"`
-	Height      int    `json:"height"`
-	Orientation int    `json:"orientation"`
+	Format        string `json:"format"`
+	ContentType   string `json:"content_type"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Orientation   int    `json:"orientation"`
+	SourceQuality int    `json:"source_quality,omitempty"`
+	DominantColor string `json:"dominant_color,omitempty"`
+	BlurHash      string `json:"blurhash,omitempty"`
 }
 
 func NewBlobFilePath(filepath string) *Blob {
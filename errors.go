@@ -18,6 +18,12 @@ var (
 	ErrTimeout           = NewError("timeout", http.StatusRequestTimeout)
 	ErrUnsupportedFormat = NewError("unsupported format", http.StatusNotAcceptable)
 	ErrMaxSizeExceeded   = NewError("maximum size exceeded", http.StatusBadRequest)
+	ErrMaxFramesExceeded = NewError("maximum animation frames exceeded", http.StatusBadRequest)
+	ErrMaxArgLenExceeded = NewError("maximum filter argument length exceeded", http.StatusBadRequest)
+	ErrMaxPathsExceeded  = NewError("maximum batch paths exceeded", http.StatusRequestEntityTooLarge)
+	ErrTruncatedImage    = NewError("truncated image", http.StatusUnprocessableEntity)
+	ErrExpired           = NewError("expired", http.StatusGone)
+	ErrProcessingTimeout = NewError("timeout waiting for processing", http.StatusGatewayTimeout)
 	ErrInternal          = NewError("internal error", http.StatusInternalServerError)
 )
 
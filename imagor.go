@@ -2,32 +2,67 @@ package imagor
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/cshum/imagor/imagorpath"
 	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
 	"golang.org/x/sync/singleflight"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const Version = "0.8.5"
 
+// PriorityHeader request header for process queue priority, higher value processed first.
+// Honoured only if TrustPriorityHeader enabled, as any client may set it
+const PriorityHeader = "X-Imagor-Priority"
+
+// maxFilenameLength maximum length of attachment filename
+const maxFilenameLength = 255
+
+const (
+	// ProcessorsFallback tries processors in order until one succeeds,
+	// such that the later processors are fallbacks of the former
+	ProcessorsFallback = "fallback"
+	// ProcessorsPipeline chains processors in order,
+	// such that each processor transforms the output of the previous
+	ProcessorsPipeline = "pipeline"
+)
+
 // Loader load image from source
 type Loader interface {
 	Load(r *http.Request, image string) (*Blob, error)
 }
 
+// TimeoutLoader optionally implemented by Loader
+// to shorten the load timeout for itself, within the overall load timeout.
+// Zero timeout falls back to the overall load timeout
+type TimeoutLoader interface {
+	LoadTimeout() time.Duration
+}
+
 // Saver saves image
 type Saver interface {
 	Save(ctx context.Context, image string, blob *Blob) error
 }
 
+// Deleter deletes image, optionally implemented by Saver for purging
+type Deleter interface {
+	Delete(ctx context.Context, image string) error
+}
+
 // Storage implements Loader and Saver
 type Storage interface {
 	Loader
@@ -44,6 +79,10 @@ type Processor interface {
 	Shutdown(ctx context.Context) error
 }
 
+// FallbackResolver resolves fallback image key for source image not found.
+// No fallback if empty
+type FallbackResolver func(image string) string
+
 // Imagor image resize HTTP handler
 type Imagor struct {
 	Unsafe         bool
@@ -60,8 +99,100 @@ type Imagor struct {
 	CacheHeaderTTL time.Duration
 	Logger         *zap.Logger
 	Debug          bool
+	PrettyJSON     bool
+
+	// ProcessorsMode how multiple Processors are combined, ProcessorsFallback or ProcessorsPipeline.
+	// ProcessorsFallback if not specified
+	ProcessorsMode string
+
+	// ProcessConcurrency maximum number of image processing running concurrently,
+	// queued by priority of PriorityHeader if trusted. No limit if 0
+	ProcessConcurrency int
+
+	// TrustPriorityHeader honours PriorityHeader for process queue priority.
+	// Enable only behind a trusted proxy that sets or strips the header from clients
+	TrustPriorityHeader bool
+
+	// MaxConcurrentProcessing maximum number of requests in-flight of source load, processing and save,
+	// such that memory is bounded under load. Waits until a slot frees or request timeout. No limit if 0
+	MaxConcurrentProcessing int
+
+	// FallbackResolver fallback source image to be processed if source image not found
+	FallbackResolver FallbackResolver
+
+	// DiagnosticHeaders response headers of process time, source bytes and result cache status
+	DiagnosticHeaders bool
+
+	// WarmPaths request paths to be processed and stored in background on startup
+	WarmPaths []string
+
+	// UnsafePrefixes restricts unsafe requests to images of the prefixes, e.g. image path prefixes or source hosts,
+	// matched on path segment boundary.
+	// Images of other prefixes require signature. Allows all if empty
+	UnsafePrefixes []string
+
+	// Signer signs path for URL signature, SignerFactory of Secret if not specified
+	Signer imagorpath.Signer
+
+	// SignerFactory creates signer of secret for Signer and ScopedSecrets,
+	// HMAC-SHA1 if not specified
+	SignerFactory func(secret string) imagorpath.Signer
+
+	// ScopedSecrets secret keys signing only the whitelisted transformations
+	ScopedSecrets []ScopedSecret
+
+	// PurgeEndpoint enables DELETE request of signed path purging the stored result,
+	// and source image if source query param is true. Refused if Secret is empty
+	PurgeEndpoint bool
+
+	// DisableParamsEndpoint disables GET /params of the parsed endpoint attributes,
+	// which include the computed signature in debug mode
+	DisableParamsEndpoint bool
+
+	// BatchEndpoint enables POST /batch of request paths separated by whitespace,
+	// streaming results as multipart/mixed response
+	BatchEndpoint bool
 
-	g singleflight.Group
+	// BatchMaxPaths max number of request paths per batch, rejected with 413 if exceeded
+	BatchMaxPaths int
+
+	// Metrics collects latencies of load, process and save, and error counts. Not collected if nil
+	Metrics Metrics
+
+	// AutoFormat picks WebP or AVIF output by Accept request header if format not specified,
+	// responded with Vary: Accept
+	AutoFormat bool
+
+	// SpeculativeLoad starts loading source image in parallel to result lookup, canceled on result hit.
+	// Lower latency on result miss at the cost of extra origin load on hit.
+	// Speculated only if a slot of MaxConcurrentProcessing is available without waiting
+	SpeculativeLoad bool
+
+	// ErrorLogSampling maximum number of error logs per second of each message and error status code,
+	// such that error logs are not flooded. No sampling if 0
+	ErrorLogSampling int
+
+	// ErrorStatusCodes overrides response status code of errors,
+	// e.g. ErrPass for source image not handled by any loader, otherwise responded as ErrNotFound
+	ErrorStatusCodes map[Error]int
+
+	// ErrorImage placeholder image responded on error other than signature mismatch in place of JSON,
+	// such that broken image is not shown. Not cached
+	ErrorImage *Blob
+
+	// ErrorImageStatusCode response status code of ErrorImage, status code of the error if 0
+	ErrorImageStatusCode int
+
+	// Tracer starts spans of the request lifecycle, e.g. adapter of OpenTelemetry tracer. No-op if not specified
+	Tracer Tracer
+
+	g       singleflight.Group
+	queue   *processQueue
+	sema    *semaphore.Weighted
+	sampler *logSampler
+
+	// customSigner Signer specified instead of signer of Secret
+	customSigner bool
 }
 
 // New create new Imagor
@@ -73,10 +204,36 @@ func New(options ...Option) *Imagor {
 		SaveTimeout:    time.Second * 20,
 		ProcessTimeout: time.Second * 20,
 		CacheHeaderTTL: time.Hour * 24,
+		ProcessorsMode: ProcessorsFallback,
+		BatchMaxPaths:  100,
 	}
 	for _, option := range options {
 		option(app)
 	}
+	if app.SignerFactory == nil {
+		app.SignerFactory = imagorpath.NewDefaultSigner
+	}
+	if app.Signer == nil {
+		app.Signer = app.SignerFactory(app.Secret)
+	} else {
+		app.customSigner = true
+	}
+	for i, s := range app.ScopedSecrets {
+		// scoped secrets signed the same way as secret
+		app.ScopedSecrets[i].signer = app.SignerFactory(s.Secret)
+	}
+	if app.Tracer == nil {
+		app.Tracer = noopTracer{}
+	}
+	if app.ProcessConcurrency > 0 {
+		app.queue = newProcessQueue(app.ProcessConcurrency)
+	}
+	if app.MaxConcurrentProcessing > 0 {
+		app.sema = semaphore.NewWeighted(int64(app.MaxConcurrentProcessing))
+	}
+	if app.ErrorLogSampling > 0 {
+		app.sampler = newLogSampler(app.ErrorLogSampling)
+	}
 	if app.Debug {
 		app.debugLog()
 	}
@@ -90,6 +247,10 @@ func (app *Imagor) Startup(ctx context.Context) (err error) {
 			return
 		}
 	}
+	if len(app.WarmPaths) > 0 {
+		// not bounded by startup timeout
+		go app.Warm(context.Background(), app.WarmPaths...)
+	}
 	return
 }
 
@@ -112,12 +273,26 @@ func (app *Imagor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		)))
 		return
 	}
+	if app.BatchEndpoint && r.Method == http.MethodPost && path == batchPath {
+		app.serveBatch(w, r)
+		return
+	}
 	p := imagorpath.Parse(path)
 	if p.Params {
-		resJSONIndent(w, p)
+		app.serveParams(w, p)
+		return
+	}
+	if app.PurgeEndpoint && r.Method == http.MethodDelete {
+		app.servePurge(w, r, p)
 		return
 	}
+	if app.AutoFormat && !hasFormatFilter(p.Filters) {
+		w.Header().Add("Vary", "Accept")
+	}
 	file, err := app.Do(r, p)
+	if app.DiagnosticHeaders && err == nil && !IsBlobEmpty(file) {
+		setDiagnosticHeaders(w, file)
+	}
 	var buf []byte
 	var ln int
 	if !IsBlobEmpty(file) {
@@ -125,7 +300,7 @@ func (app *Imagor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ln = len(buf)
 		if file.Meta != nil {
 			if p.Meta {
-				resJSON(w, file.Meta)
+				app.resJSON(w, r, file.Meta)
 				return
 			} else {
 				w.Header().Set("Content-Type", file.Meta.ContentType)
@@ -139,73 +314,267 @@ func (app *Imagor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if e, ok := WrapError(err).(Error); ok {
-			if e == ErrPass {
-				// passed till the end means not found
-				e = ErrNotFound
+			// no error image on signature mismatch, regardless of status code override
+			errorImage := app.ErrorImage != nil && !p.Meta && e != ErrSignatureMismatch
+			e = app.responseError(e)
+			if errorImage {
+				app.serveErrorImage(w, r, e)
+				return
 			}
 			w.WriteHeader(e.Code)
 			if ln > 0 {
 				w.Header().Set("Content-Length", strconv.Itoa(ln))
-				_, _ = w.Write(buf)
+				if r.Method != http.MethodHead {
+					_, _ = w.Write(buf)
+				}
 				return
 			}
-			resJSON(w, e)
+			app.resJSON(w, r, e)
 		} else {
-			resJSON(w, ErrInternal)
+			app.resJSON(w, r, ErrInternal)
+		}
+		return
+	}
+	ttl := app.CacheHeaderTTL
+	if p.Expire > 0 && ttl > 0 {
+		// cached no longer than the expiry of the url
+		if remain := time.Until(time.Unix(p.Expire, 0)); remain < ttl {
+			ttl = remain
+		}
+		if ttl < time.Second {
+			ttl = 0
+		}
+	}
+	setCacheHeaders(w, ttl)
+	etag := getETag(p.Path, w.Header().Get("Content-Type"), ln)
+	w.Header().Set("ETag", etag)
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	setContentDisposition(w, p, file)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if rng := r.Header.Get("Range"); rng != "" && !strings.Contains(rng, ",") &&
+		(r.Header.Get("If-Range") == "" || r.Header.Get("If-Range") == etag) {
+		// single byte range only, multiple ranges are served in full
+		start, end, ok := parseRange(rng, ln)
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", ln))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, ln))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(buf[start:end])
 		}
 		return
 	}
-	setCacheHeaders(w, app.CacheHeaderTTL)
 	w.Header().Set("Content-Length", strconv.Itoa(ln))
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(buf)
+	if r.Method != http.MethodHead {
+		// HEAD responds headers of the full result without body
+		_, _ = w.Write(buf)
+	}
 	return
 }
 
+// paramsResponse endpoint attributes with the computed signature
+type paramsResponse struct {
+	imagorpath.Params
+	Signature string `json:"signature,omitempty"`
+}
+
+// serveParams responds the parsed endpoint attributes for previewing URL construction.
+// Signature computed only in debug mode, such that the endpoint cannot be used for signing arbitrary paths
+func (app *Imagor) serveParams(w http.ResponseWriter, p imagorpath.Params) {
+	if app.DisableParamsEndpoint {
+		w.WriteHeader(ErrNotFound.Code)
+		resJSON(w, ErrNotFound)
+		return
+	}
+	res := paramsResponse{Params: p}
+	if app.Debug {
+		res.Signature = app.Signer.Sign(p.Path)
+	}
+	resJSONIndent(w, res)
+}
+
+// serveErrorImage responds ErrorImage in place of error, not to be cached as success
+func (app *Imagor) serveErrorImage(w http.ResponseWriter, r *http.Request, e Error) {
+	code := app.ErrorImageStatusCode
+	if code == 0 {
+		code = e.Code
+	}
+	buf, err := app.ErrorImage.ReadAll()
+	if err != nil || len(buf) == 0 {
+		app.Logger.Warn("error-image", zap.Error(err))
+		w.WriteHeader(e.Code)
+		app.resJSON(w, r, e)
+		return
+	}
+	contentType := http.DetectContentType(buf)
+	if app.ErrorImage.Meta != nil && app.ErrorImage.Meta.ContentType != "" {
+		contentType = app.ErrorImage.Meta.ContentType
+	}
+	setCacheHeaders(w, 0)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.WriteHeader(code)
+	_, _ = w.Write(buf)
+}
+
+// responseError maps error to be responded, by ErrorStatusCodes if overridden
+func (app *Imagor) responseError(e Error) Error {
+	code, ok := app.ErrorStatusCodes[e]
+	if e == ErrPass {
+		// passed till the end means not found
+		e = ErrNotFound
+	}
+	if ok {
+		e.Code = code
+	}
+	return e
+}
+
 // Do executes Imagor operations
 func (app *Imagor) Do(r *http.Request, p imagorpath.Params) (blob *Blob, err error) {
+	ctx, span := app.Tracer.Start(r.Context(), "imagor.Do")
+	if ctx != r.Context() {
+		r = r.WithContext(ctx)
+	}
+	defer func() {
+		app.countError(err)
+		if blob != nil && blob.Meta != nil {
+			span.SetAttribute(AttrFormat, blob.Meta.Format)
+		}
+		endSpan(span, err)
+	}()
 	var cancel func()
-	ctx := r.Context()
 	if app.RequestTimeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, app.RequestTimeout)
 		defer cancel()
 		r = r.WithContext(ctx)
 	}
-	if !(app.Unsafe && p.Unsafe) && imagorpath.Sign(p.Path, app.Secret) != p.Hash {
-		err = ErrSignatureMismatch
-		if app.Debug {
-			app.Logger.Debug("sign-mismatch", zap.Any("params", p), zap.String("expected", imagorpath.Sign(p.Path, app.Secret)))
+	if !(app.Unsafe && p.Unsafe && app.allowUnsafe(p)) {
+		if !app.verify(p) {
+			err = ErrSignatureMismatch
+			if app.Debug {
+				app.Logger.Debug("sign-mismatch", zap.Any("params", p), zap.String("expected", app.Signer.Sign(p.Path)))
+			}
+			return
 		}
-		return
+		// expiry is part of the signed path hence cannot be tampered with
+		if p.Expire > 0 && time.Now().Unix() > p.Expire {
+			err = ErrExpired
+			return
+		}
+	}
+	if app.AutoFormat {
+		p = negotiateFormat(p, r.Header.Get("Accept"))
 	}
+	// keys derived from path only, never request host, such that cache shared across hostnames
 	resultKey := strings.TrimPrefix(p.Path, "meta/")
+	span.SetAttribute(AttrKey, resultKey)
+	var priority int
+	if app.TrustPriorityHeader {
+		priority, _ = strconv.Atoi(r.Header.Get(PriorityHeader))
+	}
 	load := func(image string) (*Blob, error) {
-		return app.loadStore(r, image)
+		blob, err := app.loadStore(r, image)
+		if err == ErrPass {
+			// processors should not pass on missing image
+			err = ErrNotFound
+		}
+		return blob, err
 	}
-	return app.acquire(ctx, "res:"+resultKey, func(ctx context.Context) (*Blob, error) {
+	// queued whether deadline hits while waiting for a processing slot
+	var queued int32
+	defer func() {
+		if errors.Is(err, context.DeadlineExceeded) && atomic.LoadInt32(&queued) == 1 {
+			err = ErrProcessingTimeout
+		}
+	}()
+	return app.acquire(ctx, "res:"+resultKey, func(ctx context.Context) (blob *Blob, err error) {
+		var loadSource = func() (*Blob, error) {
+			return app.loadStore(r, p.Image)
+		}
+		// acquired slot of MaxConcurrentProcessing
+		var acquired bool
+		defer func() {
+			if acquired {
+				app.sema.Release(1)
+			}
+		}()
+		if app.SpeculativeLoad && p.Image != "" && len(app.ResultLoaders) > 0 &&
+			(app.sema == nil || app.sema.TryAcquire(1)) {
+			acquired = app.sema != nil
+			var cancel func()
+			loadSource, cancel = app.speculativeLoad(r, p.Image)
+			defer cancel()
+		}
 		if blob, err = app.loadResult(r, resultKey); err == nil && !IsBlobEmpty(blob) {
+			span.SetAttribute(AttrCacheHit, true)
 			return blob, err
 		}
-		if blob, err = app.loadStore(r, p.Image); err != nil {
-			app.Logger.Debug("load", zap.Any("params", p), zap.Error(err))
-			return blob, err
+		span.SetAttribute(AttrCacheHit, false)
+		if app.sema != nil && !acquired {
+			atomic.StoreInt32(&queued, 1)
+			if err = app.sema.Acquire(ctx, 1); err != nil {
+				return nil, err
+			}
+			atomic.StoreInt32(&queued, 0)
+			acquired = true
 		}
-		if IsBlobEmpty(blob) {
-			return blob, err
+		var fallback bool
+		if p.Image != "" {
+			blob, err = loadSource()
+			if e, ok := WrapError(err).(Error); ok && (e == ErrPass || e.Code == http.StatusNotFound) && app.FallbackResolver != nil {
+				if image := app.FallbackResolver(p.Image); image != "" && image != p.Image {
+					app.Logger.Debug("fallback", zap.String("image", p.Image), zap.String("fallback", image))
+					blob, err = app.loadStore(r, image)
+					fallback = true
+				}
+			}
+			if err != nil {
+				app.Logger.Debug("load", zap.Any("params", p), zap.Error(err))
+				return blob, err
+			}
+			if IsBlobEmpty(blob) {
+				return blob, err
+			}
+		}
+		if app.queue != nil {
+			if err = app.queue.Acquire(ctx, priority); err != nil {
+				return nil, err
+			}
+			defer app.queue.Release()
 		}
 		var cancel func()
 		if app.ProcessTimeout > 0 {
 			ctx, cancel = context.WithTimeout(ctx, app.ProcessTimeout)
 			defer cancel()
 		}
+		var source = blob
+		var start = time.Now()
 		for _, processor := range app.Processors {
-			f, e := processor.Process(ctx, blob, p, load)
+			processStart := time.Now()
+			pctx, pspan := app.Tracer.Start(ctx, "imagor.Process")
+			pspan.SetAttribute(AttrProcessor, getType(processor))
+			f, e := processor.Process(pctx, blob, p, load)
+			endSpan(pspan, e)
+			app.observeProcess(processStart, e)
 			if e == nil {
 				blob = f
 				err = nil
 				if app.Debug {
 					app.Logger.Debug("processed", zap.Any("params", p), zap.Any("meta", f.Meta))
 				}
+				if app.ProcessorsMode == ProcessorsPipeline {
+					// output as input of the next processor
+					continue
+				}
 				break
 			} else {
 				if e == ErrPass {
@@ -218,22 +587,53 @@ func (app *Imagor) Do(r *http.Request, p imagorpath.Params) (blob *Blob, err err
 					}
 				} else {
 					err = e
-					app.Logger.Warn("process", zap.Any("params", p), zap.Error(err))
-					if errors.Is(err, context.DeadlineExceeded) {
+					app.warn("process", err, zap.Any("params", p))
+					if app.ProcessorsMode == ProcessorsPipeline || errors.Is(err, context.DeadlineExceeded) {
+						// pipeline cannot proceed without output of the failed processor,
+						// nor any processor beyond deadline
 						break
 					}
 				}
 			}
 		}
+		var elapsed = time.Since(start)
+		if err == nil && IsBlobEmpty(blob) {
+			// no source image nor generated by processors
+			err = ErrNotFound
+		}
 		if err == nil && len(app.ResultSavers) > 0 {
-			app.save(ctx, nil, app.ResultSavers, resultKey, blob)
+			if fallback {
+				// source may become available, fallback result should not be stored
+				app.Logger.Debug("skip-save-result", zap.String("key", resultKey), zap.String("reason", "fallback"))
+			} else if ctx.Err() != nil {
+				// processed best-effort beyond deadline, should not be stored
+				app.Logger.Debug("skip-save-result", zap.String("key", resultKey), zap.Error(ctx.Err()))
+			} else {
+				app.save(ctx, nil, app.ResultSavers, resultKey, blob)
+			}
+		}
+		if err == nil && app.DiagnosticHeaders {
+			stats := &blobStats{processTime: elapsed}
+			if !IsBlobEmpty(source) {
+				buf, _ := source.ReadAll()
+				stats.sourceBytes = len(buf)
+			}
+			// wrapped as blob may be shared by source or result storage
+			buf, _ := blob.ReadAll()
+			blob = NewBlobBytesWithMeta(buf, blob.Meta)
+			blob.stats = stats
 		}
 		return blob, err
 	})
 }
 
-func (app *Imagor) loadStore(r *http.Request, key string) (*Blob, error) {
-	return app.acquire(r.Context(), "img:"+key, func(ctx context.Context) (blob *Blob, err error) {
+func (app *Imagor) loadStore(r *http.Request, key string) (blob *Blob, err error) {
+	ctx, span := app.Tracer.Start(r.Context(), "imagor.loadStore")
+	span.SetAttribute(AttrKey, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+	return app.acquire(ctx, "img:"+key, func(ctx context.Context) (blob *Blob, err error) {
 		var origin Saver
 		r = r.WithContext(ctx)
 		blob, origin, err = app.load(r, app.Loaders, key)
@@ -247,10 +647,38 @@ func (app *Imagor) loadStore(r *http.Request, key string) (*Blob, error) {
 	})
 }
 
+// speculativeLoad loads source image in background,
+// such that the load is canceled if result hits
+func (app *Imagor) speculativeLoad(r *http.Request, image string) (func() (*Blob, error), func()) {
+	type result struct {
+		blob *Blob
+		err  error
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	ch := make(chan result, 1)
+	go func() {
+		blob, err := app.loadStore(r.WithContext(ctx), image)
+		ch <- result{blob, err}
+	}()
+	return func() (*Blob, error) {
+		res := <-ch
+		return res.blob, res.err
+	}, cancel
+}
+
 func (app *Imagor) loadResult(r *http.Request, key string) (blob *Blob, err error) {
 	if len(app.ResultLoaders) == 0 {
 		return
 	}
+	ctx, span := app.Tracer.Start(r.Context(), "imagor.loadResult")
+	if ctx != r.Context() {
+		r = r.WithContext(ctx)
+	}
+	span.SetAttribute(AttrKey, key)
+	defer func() {
+		span.SetAttribute(AttrCacheHit, err == nil && !IsBlobEmpty(blob))
+		endSpan(span, err)
+	}()
 	blob, _, err = app.load(r, app.ResultLoaders, key)
 	return
 }
@@ -258,17 +686,18 @@ func (app *Imagor) loadResult(r *http.Request, key string) (blob *Blob, err erro
 func (app *Imagor) load(
 	r *http.Request, loaders []Loader, key string,
 ) (blob *Blob, origin Saver, err error) {
-	var ctx = r.Context()
-	var loadCtx = ctx
-	var loadReq = r
-	var cancel func()
+	start := time.Now()
+	defer func() {
+		app.observeLoad(start, err)
+	}()
 	if app.LoadTimeout > 0 {
-		loadCtx, cancel = context.WithTimeout(loadCtx, app.LoadTimeout)
+		// overall deadline across loaders
+		ctx, cancel := context.WithTimeout(r.Context(), app.LoadTimeout)
 		defer cancel()
-		loadReq = r.WithContext(loadCtx)
+		r = r.WithContext(ctx)
 	}
 	for _, loader := range loaders {
-		f, e := loader.Load(loadReq, key)
+		f, e := app.loadWithTimeout(r, loader, key)
 		if !IsBlobEmpty(f) {
 			blob = f
 		}
@@ -285,18 +714,31 @@ func (app *Imagor) load(
 			app.Logger.Debug("loaded", zap.String("key", key))
 		}
 	} else if !errors.Is(err, context.Canceled) {
-		if err == ErrPass {
-			err = ErrNotFound
-		}
 		// log non user-initiated error finally
-		app.Logger.Warn("load", zap.String("key", key), zap.Error(err))
+		app.warn("load", err, zap.String("key", key))
 	}
 	return
 }
 
+func (app *Imagor) loadWithTimeout(
+	r *http.Request, loader Loader, key string,
+) (*Blob, error) {
+	if l, ok := loader.(TimeoutLoader); ok {
+		if timeout := l.LoadTimeout(); timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+	}
+	return loader.Load(r, key)
+}
+
 func (app *Imagor) save(
 	ctx context.Context, origin Saver, savers []Saver, key string, blob *Blob,
 ) {
+	ctx, span := app.Tracer.Start(ctx, "imagor.save")
+	span.SetAttribute(AttrKey, key)
+	defer span.End()
 	var cancel func()
 	if app.SaveTimeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, app.SaveTimeout)
@@ -314,8 +756,11 @@ func (app *Imagor) save(
 		wg.Add(1)
 		go func(saver Saver) {
 			defer wg.Done()
-			if err := saver.Save(ctx, key, blob); err != nil {
-				app.Logger.Warn("save", zap.String("key", key), zap.Error(err))
+			start := time.Now()
+			err := saver.Save(ctx, key, blob)
+			app.observeSave(start, err)
+			if err != nil {
+				app.warn("save", err, zap.String("key", key))
 			} else if app.Debug {
 				app.Logger.Debug("saved", zap.String("key", key))
 			}
@@ -340,6 +785,11 @@ func (app *Imagor) acquire(
 		// resolve deadlock
 		return fn(ctx)
 	}
+	ctx, span := app.Tracer.Start(ctx, "imagor.acquire")
+	span.SetAttribute(AttrKey, key)
+	defer func() {
+		endSpan(span, err)
+	}()
 	isCanceled := false
 	ch := app.g.DoChan(key, func() (interface{}, error) {
 		v, err := fn(context.WithValue(ctx, acquireKey{key}, true))
@@ -351,6 +801,7 @@ func (app *Imagor) acquire(
 	})
 	select {
 	case res := <-ch:
+		span.SetAttribute(AttrDeduplicated, res.Shared)
 		if !isCanceled && errors.Is(res.Err, context.Canceled) {
 			// resolve canceled
 			return app.acquire(ctx, key, fn)
@@ -390,6 +841,8 @@ func (app *Imagor) debugLog() {
 		zap.Duration("load_timeout", app.LoadTimeout),
 		zap.Duration("save_timeout", app.SaveTimeout),
 		zap.Duration("cache_header_ttl", app.CacheHeaderTTL),
+		zap.Int("process_concurrency", app.ProcessConcurrency),
+		zap.Int("max_concurrent_processing", app.MaxConcurrentProcessing),
 		zap.Strings("loaders", loaders),
 		zap.Strings("savers", savers),
 		zap.Strings("result_loaders", resultLoaders),
@@ -398,6 +851,64 @@ func (app *Imagor) debugLog() {
 	)
 }
 
+func setDiagnosticHeaders(w http.ResponseWriter, blob *Blob) {
+	if blob.stats == nil {
+		// result without stats is loaded from result storage
+		w.Header().Set("X-Imagor-Cache", "hit")
+		return
+	}
+	w.Header().Set("X-Imagor-Cache", "miss")
+	w.Header().Set("X-Imagor-Process-Time",
+		strconv.FormatFloat(float64(blob.stats.processTime)/float64(time.Millisecond), 'f', 3, 64))
+	w.Header().Set("X-Imagor-Source-Bytes", strconv.Itoa(blob.stats.sourceBytes))
+}
+
+// setContentDisposition sets attachment of filename by attachment(filename) filter,
+// with extension of the result format if not specified
+func setContentDisposition(w http.ResponseWriter, p imagorpath.Params, blob *Blob) {
+	for _, filter := range p.Filters {
+		if filter.Name != "attachment" {
+			continue
+		}
+		name := filter.Args
+		if unescape, err := url.QueryUnescape(name); err == nil {
+			name = unescape
+		}
+		if name = sanitizeFilename(name); name == "" {
+			return
+		}
+		if path.Ext(name) == "" && blob.Meta != nil && blob.Meta.Format != "" {
+			name += "." + blob.Meta.Format
+		}
+		if v := mime.FormatMediaType("attachment", map[string]string{"filename": name}); v != "" {
+			w.Header().Set("Content-Disposition", v)
+		}
+		return
+	}
+}
+
+// sanitizeFilename base name without path, control, quote and reserved characters
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i > -1 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`"*:<>?|;`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxFilenameLength-len(ext)], "") + ext
+	}
+	return name
+}
+
 func setCacheHeaders(w http.ResponseWriter, ttl time.Duration) {
 	expires := time.Now().Add(ttl)
 
@@ -405,6 +916,64 @@ func setCacheHeaders(w http.ResponseWriter, ttl time.Duration) {
 	w.Header().Add("Cache-Control", getCacheControl(ttl))
 }
 
+// getETag strong ETag derived from result path, content type and length,
+// stable across restarts for the same params
+func getETag(path, contentType string, size int) string {
+	sum := sha1.Sum([]byte(path + "\n" + contentType + "\n" + strconv.Itoa(size)))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// matchETag checks If-None-Match header against ETag, by weak comparison
+func matchETag(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses single byte range of Range header against content size,
+// returns start and exclusive end offsets, not ok if invalid or unsatisfiable
+func parseRange(rng string, size int) (start, end int, ok bool) {
+	if !strings.HasPrefix(rng, "bytes=") {
+		return
+	}
+	rng = strings.TrimSpace(strings.TrimPrefix(rng, "bytes="))
+	i := strings.Index(rng, "-")
+	if i < 0 {
+		return
+	}
+	first, last := strings.TrimSpace(rng[:i]), strings.TrimSpace(rng[i+1:])
+	if first == "" {
+		// suffix range of last n bytes
+		n, err := strconv.Atoi(last)
+		if err != nil || n <= 0 || size == 0 {
+			return
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, true
+	}
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 || start >= size {
+		return
+	}
+	end = size
+	if last != "" {
+		l, err := strconv.Atoi(last)
+		if err != nil || l < start {
+			return 0, 0, false
+		}
+		if l+1 < size {
+			end = l + 1
+		}
+	}
+	return start, end, true
+}
+
 func getCacheControl(ttl time.Duration) string {
 	if ttl == 0 {
 		return "private, no-cache, no-store, must-revalidate"
@@ -413,6 +982,22 @@ func getCacheControl(ttl time.Duration) string {
 	return fmt.Sprintf("public, s-maxage=%d, max-age=%d, no-transform", ttlSec, ttlSec)
 }
 
+// resJSON response JSON compact or pretty-printed,
+// by pretty query param if specified, otherwise PrettyJSON option
+func (app *Imagor) resJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	pretty := app.PrettyJSON
+	if q := r.URL.Query().Get("pretty"); q != "" {
+		if b, err := strconv.ParseBool(q); err == nil {
+			pretty = b
+		}
+	}
+	if pretty {
+		resJSONIndent(w, v)
+	} else {
+		resJSON(w, v)
+	}
+}
+
 func resJSON(w http.ResponseWriter, v interface{}) {
 	buf, _ := json.Marshal(v)
 	w.Header().Set("Content-Type", "application/json")
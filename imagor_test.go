@@ -2,6 +2,7 @@ package imagor
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,12 +10,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"io"
 	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -66,6 +72,87 @@ func TestWithUnsafe(t *testing.T) {
 	assert.Equal(t, w.Body.String(), jsonStr(ErrSignatureMismatch))
 }
 
+func TestWithUnsafePrefixes(t *testing.T) {
+	app := New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithUnsafePrefixes("public/, raw.githubusercontent.com/", "https://example.org/", "example.net"),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+	)
+	assert.Equal(t, []string{"public/", "raw.githubusercontent.com/", "https://example.org/", "example.net"}, app.UnsafePrefixes)
+	tests := []struct {
+		name string
+		path string
+		code int
+	}{
+		{"unsafe prefix", "/unsafe/fit-in/100x100/public/foo.jpg", 200},
+		{"unsafe host", "/unsafe/raw.githubusercontent.com/foo.jpg", 200},
+		{"unsafe host with scheme", "/unsafe/https%3A%2F%2Fraw.githubusercontent.com%2Ffoo.jpg", 200},
+		{"unsafe prefix with scheme", "/unsafe/example.org/foo.jpg", 200},
+		{"unsafe other prefix", "/unsafe/fit-in/100x100/private/foo.jpg", 403},
+		{"unsafe other host", "/unsafe/example.com/foo.jpg", 403},
+		{"unsafe host without slash", "/unsafe/example.net/foo.jpg", 200},
+		{"unsafe host suffixed", "/unsafe/example.net.evil.com/foo.jpg", 403},
+		{"unsafe host suffixed with scheme", "/unsafe/https%3A%2F%2Fexample.org.evil.com%2Ffoo.jpg", 403},
+		{"unsafe prefix suffixed", "/unsafe/public-private/foo.jpg", 403},
+		{"unsafe traversal", "/unsafe/public/../private/foo.jpg", 403},
+		{"signed other prefix", "/" + imagorpath.Generate(imagorpath.Params{Image: "private/foo.jpg"}, "1234"), 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(
+				http.MethodGet, "https://example.com"+tt.path, nil))
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == 403 {
+				assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithScopedSecret(t *testing.T) {
+	app := New(
+		WithSecret("1234"),
+		WithScopedSecret("abcd", "fit-in/200x200 300x0/filters:format(webp):quality(80)"),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+	)
+	sign := func(secret, path string) string {
+		return "/" + imagorpath.Sign(path, secret) + "/" + path
+	}
+	tests := []struct {
+		name string
+		path string
+		code int
+	}{
+		{"secret", sign("1234", "500x500/foo.jpg"), 200},
+		{"scoped", sign("abcd", "fit-in/200x200/foo.jpg"), 200},
+		{"scoped filters", sign("abcd", "300x0/filters:format(webp):quality(80)/bar/foo.jpg"), 200},
+		{"scoped dimensions altered", sign("abcd", "fit-in/500x500/foo.jpg"), 403},
+		{"scoped fit-in removed", sign("abcd", "200x200/foo.jpg"), 403},
+		{"scoped filters altered", sign("abcd", "300x0/filters:format(webp):quality(100)/foo.jpg"), 403},
+		{"scoped filter added", sign("abcd", "fit-in/200x200/filters:format(webp)/foo.jpg"), 403},
+		{"scoped no transformation", sign("abcd", "foo.jpg"), 403},
+		{"base signature with altered path", "/" + imagorpath.Sign("fit-in/200x200/foo.jpg", "abcd") + "/fit-in/500x500/foo.jpg", 403},
+		{"wrong secret", sign("efgh", "fit-in/200x200/foo.jpg"), 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(
+				http.MethodGet, "https://example.com"+tt.path, nil))
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == 403 {
+				assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+			}
+		})
+	}
+}
+
 func TestAcquireDeadlockResolve(t *testing.T) {
 	ctx := context.Background()
 	app := New()
@@ -136,6 +223,149 @@ func TestWithSecret(t *testing.T) {
 	assert.Equal(t, w.Body.String(), jsonStr(ErrSignatureMismatch))
 }
 
+func TestWithSigner(t *testing.T) {
+	signer := imagorpath.NewHMACSigner(sha256.New, 28, "1234")
+	path := "fit-in/100x100/foo.jpg"
+	hash := signer.Sign(path)
+	assert.Len(t, hash, 28)
+
+	app := New(
+		WithSecret("1234"),
+		WithSigner(signer),
+		WithUnsafe(true))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+path, nil))
+		return w
+	}
+	assert.Equal(t, 200, serve(hash+"/"+path).Code)
+	assert.Equal(t, 200, serve("unsafe/"+path).Code)
+	for _, p := range []string{
+		imagorpath.Sign(path, "1234") + "/" + path,
+		hash + "/fit-in/200x200/foo.jpg",
+		hash[:27] + "/" + path,
+		path,
+	} {
+		w := serve(p)
+		assert.Equal(t, 403, w.Code)
+		assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+	}
+	assert.Equal(t, imagorpath.Sign(path, "1234"), New(WithSecret("1234")).Signer.Sign(path), "default signer")
+
+	t.Run("without secret", func(t *testing.T) {
+		app := New(
+			WithSigner(signer),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobBytes([]byte(image)), nil
+			})))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+hash+"/"+path, nil))
+		assert.Equal(t, 200, w.Code)
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+imagorpath.Sign(path, "")+"/"+path, nil))
+		assert.Equal(t, 403, w.Code)
+	})
+}
+
+func TestExpire(t *testing.T) {
+	app := New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte("foo")), nil
+		})))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+path, nil))
+		return w
+	}
+	future := imagorpath.Params{Expire: time.Now().Add(time.Hour).Unix(), Image: "foo.jpg"}
+	past := imagorpath.Params{Expire: time.Now().Add(-time.Second).Unix(), Image: "foo.jpg"}
+
+	w := serve(imagorpath.Generate(future, "1234"))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+
+	w = serve(imagorpath.Generate(past, "1234"))
+	assert.Equal(t, 410, w.Code)
+	assert.Equal(t, jsonStr(ErrExpired), w.Body.String())
+
+	// tampering expiry breaks signature
+	tampered := strings.Replace(imagorpath.Generate(past, "1234"),
+		fmt.Sprintf("exp:%d", past.Expire), fmt.Sprintf("exp:%d", future.Expire), 1)
+	w = serve(tampered)
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+
+	// unsafe bypasses both signature and expiry
+	w = serve(imagorpath.GenerateUnsafe(past))
+	assert.Equal(t, 200, w.Code)
+
+	// cache headers capped by expiry
+	app = New(
+		WithSecret("1234"),
+		WithCacheHeaderTTL(time.Hour*24),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte("foo")), nil
+		})))
+	w = serve(imagorpath.Generate(future, "1234"))
+	assert.Equal(t, 200, w.Code)
+	var sMaxAge, maxAge int
+	_, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "public, s-maxage=%d, max-age=%d, no-transform", &sMaxAge, &maxAge)
+	require.NoError(t, err)
+	assert.Equal(t, sMaxAge, maxAge)
+	assert.LessOrEqual(t, maxAge, 3600)
+	assert.Greater(t, maxAge, 3500)
+	expires, err := time.Parse(time.RFC1123, w.Header().Get("Expires"))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, expires.Unix(), future.Expire)
+
+	w = serve(imagorpath.Generate(imagorpath.Params{Image: "foo.jpg"}, "1234"))
+	assert.Equal(t, "public, s-maxage=86400, max-age=86400, no-transform", w.Header().Get("Cache-Control"))
+}
+
+func TestWithScopedSecretOnly(t *testing.T) {
+	app := New(
+		WithScopedSecret("abcd", "fit-in/200x200"),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+	)
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+path, nil))
+		return w.Code
+	}
+	assert.Equal(t, 200, serve(imagorpath.Sign("fit-in/200x200/foo.jpg", "abcd")+"/fit-in/200x200/foo.jpg"))
+	// empty secret signature not accepted in place of scoped secret
+	assert.Equal(t, 403, serve(imagorpath.Sign("500x500/foo.jpg", "")+"/500x500/foo.jpg"))
+	assert.Equal(t, 403, serve(imagorpath.Sign("fit-in/200x200/foo.jpg", "")+"/fit-in/200x200/foo.jpg"))
+}
+
+func TestWithSignerFactory(t *testing.T) {
+	factory := func(secret string) imagorpath.Signer {
+		return imagorpath.NewHMACSigner(sha256.New, 0, secret)
+	}
+	app := New(
+		WithSecret("1234"),
+		WithSignerFactory(factory),
+		WithScopedSecret("abcd", "fit-in/200x200"),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+	)
+	serve := func(hash, path string) int {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+hash+"/"+path, nil))
+		return w.Code
+	}
+	path := "fit-in/200x200/foo.jpg"
+	assert.Equal(t, 200, serve(factory("1234").Sign(path), path))
+	assert.Equal(t, 200, serve(factory("abcd").Sign(path), path), "scoped secret of the same signer")
+	assert.Equal(t, 403, serve(imagorpath.Sign(path, "abcd"), path))
+	assert.Equal(t, 403, serve(factory("abcd").Sign("500x500/foo.jpg"), "500x500/foo.jpg"))
+}
+
 func TestWithCacheHeaderTTL(t *testing.T) {
 	t.Run("default", func(t *testing.T) {
 		app := New(
@@ -160,6 +390,643 @@ func TestWithCacheHeaderTTL(t *testing.T) {
 	})
 }
 
+func TestETag(t *testing.T) {
+	app := New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})))
+	serve := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		app.ServeHTTP(w, r)
+		return w
+	}
+	w := serve("/unsafe/foo", "")
+	assert.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{40}"$`, etag)
+	assert.Equal(t, etag, serve("/unsafe/foo", "").Header().Get("ETag"), "stable")
+	assert.NotEqual(t, etag, serve("/unsafe/bar", "").Header().Get("ETag"))
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"abc", ` + etag, "*"} {
+		w = serve("/unsafe/foo", ifNoneMatch)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+	}
+	w = serve("/unsafe/foo", `"abc"`)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+
+	w = serve("/unsafe/foo", "")
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/", nil))
+	assert.Empty(t, w.Header().Get("ETag"), "no etag on error")
+}
+
+func TestRange(t *testing.T) {
+	app := New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte("0123456789")), nil
+		})))
+	serve := func(rng, ifRange string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		if ifRange != "" {
+			r.Header.Set("If-Range", ifRange)
+		}
+		app.ServeHTTP(w, r)
+		return w
+	}
+	w := serve("", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	etag := w.Header().Get("ETag")
+
+	for _, tt := range []struct {
+		rng          string
+		body         string
+		contentRange string
+	}{
+		{"bytes=0-3", "0123", "bytes 0-3/10"},
+		{"bytes=2-2", "2", "bytes 2-2/10"},
+		{"bytes=5-", "56789", "bytes 5-9/10"},
+		{"bytes=7-100", "789", "bytes 7-9/10"},
+		{"bytes=-3", "789", "bytes 7-9/10"},
+		{"bytes=-20", "0123456789", "bytes 0-9/10"},
+	} {
+		w = serve(tt.rng, "")
+		assert.Equal(t, http.StatusPartialContent, w.Code, tt.rng)
+		assert.Equal(t, tt.body, w.Body.String(), tt.rng)
+		assert.Equal(t, tt.contentRange, w.Header().Get("Content-Range"), tt.rng)
+		assert.Equal(t, strconv.Itoa(len(tt.body)), w.Header().Get("Content-Length"), tt.rng)
+	}
+	for _, rng := range []string{"bytes=10-", "bytes=5-2", "bytes=-0", "bytes=a-b", "bytes=-", "items=0-1"} {
+		w = serve(rng, "")
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code, rng)
+		assert.Equal(t, "bytes */10", w.Header().Get("Content-Range"), rng)
+		assert.Empty(t, w.Body.String(), rng)
+	}
+
+	w = serve("bytes=0-1,4-5", "")
+	assert.Equal(t, 200, w.Code, "multiple ranges served in full")
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	w = serve("bytes=0-1", etag)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "01", w.Body.String())
+	w = serve("bytes=0-1", `"stale"`)
+	assert.Equal(t, 200, w.Code, "If-Range mismatch served in full")
+	assert.Equal(t, "0123456789", w.Body.String())
+}
+
+func TestHead(t *testing.T) {
+	app := New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "missing" {
+				return nil, ErrNotFound
+			}
+			return NewBlobBytes([]byte("0123456789")), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobBytesWithMeta([]byte("abcdef"), &Meta{ContentType: "image/png"}), nil
+		})),
+	)
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(method, "https://example.com"+target, nil))
+		return w
+	}
+	get := serve(http.MethodGet, "/unsafe/foo")
+	head := serve(http.MethodHead, "/unsafe/foo")
+	assert.Equal(t, 200, head.Code)
+	assert.Empty(t, head.Body.String())
+	assert.Equal(t, "image/png", head.Header().Get("Content-Type"))
+	assert.Equal(t, "6", head.Header().Get("Content-Length"))
+	for _, key := range []string{"Content-Type", "Content-Length", "Cache-Control", "ETag"} {
+		assert.Equal(t, get.Header().Get(key), head.Header().Get(key), key)
+	}
+
+	r := httptest.NewRequest(http.MethodHead, "https://example.com/unsafe/foo", nil)
+	r.Header.Set("Range", "bytes=1-2")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodHead, "/foo").Code, "signature verified")
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodHead, "/unsafe/missing").Code)
+}
+
+func TestWithSpeculativeLoad(t *testing.T) {
+	started := make(chan struct{}, 1)
+	canceled := make(chan error, 1)
+	app := New(
+		WithUnsafe(true),
+		WithSpeculativeLoad(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			started <- struct{}{}
+			if image == "miss.jpg" {
+				return NewBlobBytes([]byte("source")), nil
+			}
+			<-r.Context().Done()
+			canceled <- r.Context().Err()
+			return nil, r.Context().Err()
+		})),
+		WithResultLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			select {
+			case <-started:
+				// source load in parallel to result lookup
+			case <-time.After(time.Second):
+				return nil, errors.New("source load not started")
+			}
+			if image == "hit.jpg" {
+				return NewBlobBytes([]byte("result")), nil
+			}
+			return nil, ErrNotFound
+		})),
+	)
+	assert.True(t, app.SpeculativeLoad)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/hit.jpg", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "result", w.Body.String())
+	select {
+	case err := <-canceled:
+		assert.Equal(t, context.Canceled, err, "source load canceled on result hit")
+	case <-time.After(time.Second):
+		t.Fatal("source load not canceled")
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/miss.jpg", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "source", w.Body.String())
+
+	var loadCnt int
+	app = New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			loadCnt++
+			return NewBlobBytes([]byte("source")), nil
+		})),
+		WithResultLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte("result")), nil
+		})),
+	)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/hit.jpg", nil))
+	assert.Equal(t, "result", w.Body.String())
+	assert.Equal(t, 0, loadCnt, "no source load on result hit by default")
+
+	var speculated int32
+	app = New(
+		WithUnsafe(true),
+		WithSpeculativeLoad(true),
+		WithMaxConcurrentProcessing(1),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			atomic.AddInt32(&speculated, 1)
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		})),
+		WithResultLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			time.Sleep(time.Millisecond * 10)
+			return NewBlobBytes([]byte("result")), nil
+		})),
+	)
+	require.True(t, app.sema.TryAcquire(1))
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/hit.jpg", nil))
+	assert.Equal(t, "result", w.Body.String())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&speculated), "no speculative load without processing slot")
+	app.sema.Release(1)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/hit.jpg", nil))
+	assert.Equal(t, "result", w.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&speculated), "speculative load within processing slot")
+	assert.True(t, app.sema.TryAcquire(1), "processing slot released")
+}
+
+func TestWithAutoFormat(t *testing.T) {
+	resultStore := &mapStore{Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{}}
+	app := New(
+		WithUnsafe(true),
+		WithAutoFormat(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobBytes([]byte(p.Path)), nil
+		})),
+	)
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		result string
+		vary   bool
+	}{
+		{"avif", "/unsafe/fit-in/100x100/foo.jpg", "image/avif,image/webp,image/*,*/*;q=0.8",
+			"fit-in/100x100/filters:format(avif)/foo.jpg", true},
+		{"webp", "/unsafe/fit-in/100x100/foo.jpg", "image/webp,*/*",
+			"fit-in/100x100/filters:format(webp)/foo.jpg", true},
+		{"avif refused", "/unsafe/filters:fill(white)/foo.jpg", "image/avif;q=0, image/webp;q=0.9",
+			"filters:fill(white):format(webp)/foo.jpg", true},
+		{"unsupported", "/unsafe/fit-in/100x100/foo.jpg", "image/*,*/*;q=0.8",
+			"fit-in/100x100/foo.jpg", true},
+		{"no accept", "/unsafe/fit-in/100x100/foo.jpg", "",
+			"fit-in/100x100/foo.jpg", true},
+		{"explicit format", "/unsafe/filters:format(png)/foo.jpg", "image/avif,image/webp",
+			"filters:format(png)/foo.jpg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "https://example.com"+tt.path, nil)
+			r.Header.Set("Accept", tt.accept)
+			app.ServeHTTP(w, r)
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, tt.result, w.Body.String())
+			assert.Contains(t, resultStore.Map, tt.result, "result stored per format variant")
+			if tt.vary {
+				assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			} else {
+				assert.Empty(t, w.Header().Get("Vary"))
+			}
+		})
+	}
+	t.Run("disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo.jpg", nil)
+		r.Header.Set("Accept", "image/avif")
+		New(WithUnsafe(true)).ServeHTTP(w, r)
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+}
+
+type metricsRecorder struct {
+	mu      sync.Mutex
+	load    []string
+	process []string
+	save    []string
+	errors  []Error
+}
+
+func (m *metricsRecorder) ObserveLoad(d time.Duration, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load = append(m.load, outcome)
+}
+
+func (m *metricsRecorder) ObserveProcess(d time.Duration, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.process = append(m.process, outcome)
+}
+
+func (m *metricsRecorder) ObserveSave(d time.Duration, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.save = append(m.save, outcome)
+}
+
+func (m *metricsRecorder) CountError(err Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, err)
+}
+
+func TestWithMetrics(t *testing.T) {
+	metrics := &metricsRecorder{}
+	app := New(
+		WithUnsafe(true),
+		WithMetrics(metrics),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			switch image {
+			case "foo":
+				return NewBlobBytes([]byte("foo")), nil
+			case "broken":
+				return nil, errors.New("broken")
+			}
+			return nil, ErrNotFound
+		})),
+		WithSavers(saverFunc(func(ctx context.Context, image string, blob *Blob) error {
+			return nil
+		})),
+		WithProcessors(
+			processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				return blob, ErrPass
+			}),
+			processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				return blob, nil
+			}),
+		),
+	)
+	for _, path := range []string{"/unsafe/foo", "/unsafe/bar", "/unsafe/broken", "/foo"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+	}
+	assert.Equal(t, []string{OutcomeHit, OutcomeMiss, OutcomeError}, metrics.load)
+	assert.Equal(t, []string{OutcomeMiss, OutcomeHit}, metrics.process)
+	assert.Equal(t, []string{OutcomeHit}, metrics.save)
+	assert.Equal(t, []Error{ErrNotFound, NewError("broken", 500), ErrSignatureMismatch}, metrics.errors)
+
+	t.Run("status code overridden", func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		app := New(WithUnsafe(true), WithMetrics(metrics), WithErrorStatusCode(ErrNotFound, 410),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return nil, ErrNotFound
+			})))
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/bar", nil))
+		assert.Equal(t, []Error{NewError(ErrNotFound.Message, 410)}, metrics.errors)
+	})
+	t.Run("no metrics", func(t *testing.T) {
+		assert.NotPanics(t, func() {
+			New(WithUnsafe(true)).ServeHTTP(httptest.NewRecorder(),
+				httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/bar", nil))
+		})
+	})
+}
+
+type tracerKey struct{}
+
+type recordedSpan struct {
+	Name   string
+	Parent string
+	Attrs  map[string]interface{}
+	Err    error
+	Ended  bool
+}
+
+type tracerRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *tracerRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &spanRecorder{t: t, s: &recordedSpan{Name: name, Attrs: map[string]interface{}{}}}
+	if parent, ok := ctx.Value(tracerKey{}).(*spanRecorder); ok {
+		span.s.Parent = parent.s.Name
+	}
+	t.spans = append(t.spans, span.s)
+	return context.WithValue(ctx, tracerKey{}, span), span
+}
+
+func (t *tracerRecorder) find(name string, attrs map[string]interface{}) (res []recordedSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.Name != name {
+			continue
+		}
+		matched := true
+		for k, v := range attrs {
+			if s.Attrs[k] != v {
+				matched = false
+			}
+		}
+		if matched {
+			res = append(res, *s)
+		}
+	}
+	return
+}
+
+func (t *tracerRecorder) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = nil
+}
+
+type spanRecorder struct {
+	t *tracerRecorder
+	s *recordedSpan
+}
+
+func (s *spanRecorder) SetAttribute(key string, value interface{}) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.Attrs[key] = value
+}
+
+func (s *spanRecorder) RecordError(err error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.Err = err
+}
+
+func (s *spanRecorder) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.Ended = true
+}
+
+func TestWithTracer(t *testing.T) {
+	tracer := &tracerRecorder{}
+	release := make(chan struct{})
+	resultStore := &mapStore{Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{}}
+	app := New(
+		WithUnsafe(true),
+		WithTracer(tracer),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "foo" || image == "slow" {
+				return NewBlobBytes([]byte(image)), nil
+			}
+			return nil, ErrNotFound
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+		WithProcessors(
+			processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				return blob, ErrPass
+			}),
+			processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				if p.Image == "slow" {
+					<-release
+				}
+				buf, _ := blob.ReadAll()
+				return NewBlobBytesWithMeta(buf, &Meta{Format: "png"}), nil
+			}),
+		),
+	)
+
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+	do := tracer.find("imagor.Do", nil)
+	require.Len(t, do, 1)
+	assert.Equal(t, "", do[0].Parent)
+	assert.Equal(t, map[string]interface{}{
+		AttrKey: "foo", AttrCacheHit: false, AttrFormat: "png",
+	}, do[0].Attrs)
+	assert.True(t, do[0].Ended)
+	assert.NoError(t, do[0].Err)
+
+	loadResult := tracer.find("imagor.loadResult", nil)
+	require.Len(t, loadResult, 1)
+	assert.Equal(t, "imagor.Do", loadResult[0].Parent)
+	assert.Equal(t, false, loadResult[0].Attrs[AttrCacheHit])
+	assert.Equal(t, ErrNotFound, loadResult[0].Err)
+
+	loadStore := tracer.find("imagor.loadStore", map[string]interface{}{AttrKey: "foo"})
+	require.Len(t, loadStore, 1)
+	assert.Equal(t, "imagor.Do", loadStore[0].Parent)
+	assert.Len(t, tracer.find("imagor.acquire", map[string]interface{}{AttrKey: "img:foo"}), 1)
+	assert.Len(t, tracer.find("imagor.acquire", map[string]interface{}{AttrKey: "res:foo", AttrDeduplicated: false}), 1)
+
+	process := tracer.find("imagor.Process", nil)
+	require.Len(t, process, 2)
+	for _, s := range process {
+		assert.Equal(t, "processorFunc", s.Attrs[AttrProcessor])
+		assert.Equal(t, "imagor.acquire", s.Parent)
+		assert.NoError(t, s.Err, "pass should not be recorded as error")
+		assert.True(t, s.Ended)
+	}
+	save := tracer.find("imagor.save", map[string]interface{}{AttrKey: "foo"})
+	require.Len(t, save, 1)
+	assert.True(t, save[0].Ended)
+
+	tracer.reset()
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+	assert.Len(t, tracer.find("imagor.Do", map[string]interface{}{AttrCacheHit: true, AttrFormat: "png"}), 1)
+	assert.Len(t, tracer.find("imagor.loadResult", map[string]interface{}{AttrCacheHit: true}), 1)
+	assert.Empty(t, tracer.find("imagor.Process", nil))
+
+	t.Run("deduplicated", func(t *testing.T) {
+		tracer.reset()
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/slow", nil))
+			}()
+		}
+		for len(tracer.find("imagor.acquire", map[string]interface{}{AttrKey: "res:slow"})) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(time.Millisecond * 10)
+		close(release)
+		wg.Wait()
+		assert.Len(t, tracer.find("imagor.acquire", map[string]interface{}{AttrKey: "res:slow", AttrDeduplicated: true}), 2)
+		assert.Len(t, tracer.find("imagor.Process", nil), 2, "processed once")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		tracer.reset()
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/bar", nil))
+		do := tracer.find("imagor.Do", nil)
+		require.Len(t, do, 1)
+		assert.Equal(t, ErrNotFound, do[0].Err)
+		assert.Equal(t, "imagor.Do", tracer.find("imagor.loadStore", nil)[0].Parent)
+	})
+
+	t.Run("no-op tracer", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithTracer(nil))
+		assert.Equal(t, noopTracer{}, app.Tracer)
+		assert.NotPanics(t, func() {
+			app.ServeHTTP(httptest.NewRecorder(),
+				httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/bar", nil))
+		})
+	})
+}
+
+func TestWithProcessorsMode(t *testing.T) {
+	appendProcessor := func(suffix string, calls *[]string) Processor {
+		return processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			*calls = append(*calls, suffix)
+			buf, err := blob.ReadAll()
+			if err != nil {
+				return nil, err
+			}
+			return NewBlobBytesWithMeta(append(buf, suffix...), &Meta{Format: suffix}), nil
+		})
+	}
+	passProcessor := processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		return blob, ErrPass
+	})
+	failProcessor := processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		return nil, errors.New("fail")
+	})
+	loader := loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		return NewBlobBytes([]byte(image)), nil
+	})
+	serve := func(app *Imagor) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+		return w
+	}
+
+	t.Run("fallback by default", func(t *testing.T) {
+		var calls []string
+		app := New(WithUnsafe(true), WithLoaders(loader), WithProcessors(
+			passProcessor, appendProcessor("a", &calls), appendProcessor("b", &calls)))
+		assert.Equal(t, ProcessorsFallback, app.ProcessorsMode)
+		w := serve(app)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fooa", w.Body.String())
+		assert.Equal(t, []string{"a"}, calls)
+	})
+	t.Run("pipeline", func(t *testing.T) {
+		var calls []string
+		app := New(WithUnsafe(true), WithLoaders(loader), WithProcessorsMode(ProcessorsPipeline), WithProcessors(
+			appendProcessor("a", &calls), appendProcessor("b", &calls)))
+		assert.Equal(t, ProcessorsPipeline, app.ProcessorsMode)
+		w := serve(app)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fooab", w.Body.String())
+		assert.Equal(t, []string{"a", "b"}, calls)
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/meta/foo", nil)
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Equal(t, jsonStr(&Meta{Format: "b"}), w.Body.String(), "meta of the last processor")
+	})
+	t.Run("pipeline pass", func(t *testing.T) {
+		var calls []string
+		app := New(WithUnsafe(true), WithLoaders(loader), WithProcessorsMode(ProcessorsPipeline), WithProcessors(
+			appendProcessor("a", &calls), passProcessor, appendProcessor("b", &calls)))
+		w := serve(app)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fooab", w.Body.String())
+	})
+	t.Run("pipeline error", func(t *testing.T) {
+		var calls []string
+		app := New(WithUnsafe(true), WithLoaders(loader), WithProcessorsMode(ProcessorsPipeline), WithProcessors(
+			appendProcessor("a", &calls), failProcessor, appendProcessor("b", &calls)))
+		w := serve(app)
+		assert.Equal(t, 500, w.Code)
+		assert.Equal(t, "fooa", w.Body.String(), "output prior to the failed processor")
+		assert.Equal(t, []string{"a"}, calls, "rest of pipeline skipped")
+	})
+	t.Run("fallback error", func(t *testing.T) {
+		var calls []string
+		app := New(WithUnsafe(true), WithLoaders(loader), WithProcessors(
+			failProcessor, appendProcessor("b", &calls)))
+		w := serve(app)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "foob", w.Body.String())
+	})
+	t.Run("invalid mode ignored", func(t *testing.T) {
+		app := New(WithProcessorsMode("foo"))
+		assert.Equal(t, ProcessorsFallback, app.ProcessorsMode)
+	})
+}
+
 func TestVersion(t *testing.T) {
 	app := New(
 		WithDebug(true),
@@ -185,7 +1052,10 @@ func TestParams(t *testing.T) {
 	w := httptest.NewRecorder()
 	app.ServeHTTP(w, r)
 	assert.Equal(t, 200, w.Code)
-	buf, _ := json.MarshalIndent(imagorpath.Parse(r.URL.EscapedPath()), "", "  ")
+	buf, _ := json.MarshalIndent(paramsResponse{
+		Params:    imagorpath.Parse(r.URL.EscapedPath()),
+		Signature: "_-19cQt1szHeUV0WyWFntvTImDI=",
+	}, "", "  ")
 	assert.Equal(t, string(buf), w.Body.String())
 
 	r = httptest.NewRequest(
@@ -193,11 +1063,43 @@ func TestParams(t *testing.T) {
 	w = httptest.NewRecorder()
 	app.ServeHTTP(w, r)
 	assert.Equal(t, 200, w.Code)
-	buf, _ = json.MarshalIndent(imagorpath.Parse(r.URL.EscapedPath()), "", "  ")
+	buf, _ = json.MarshalIndent(paramsResponse{
+		Params:    imagorpath.Parse(r.URL.EscapedPath()),
+		Signature: "_-19cQt1szHeUV0WyWFntvTImDI=",
+	}, "", "  ")
 	assert.Equal(t, string(buf), w.Body.String())
-}
 
-type mapStore struct {
+	var res map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "foo.jpg", res["image"])
+	assert.Equal(t, "_-19cQt1szHeUV0WyWFntvTImDI=", res["signature"])
+
+	t.Run("no signature unless debug", func(t *testing.T) {
+		app := New(WithSecret("1234"))
+		r := httptest.NewRequest(
+			http.MethodGet, "https://example.com/params/unsafe/fit-in/100x100/foo.jpg", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code)
+		buf, _ := json.MarshalIndent(imagorpath.Parse(r.URL.EscapedPath()), "", "  ")
+		assert.Equal(t, string(buf), w.Body.String())
+		assert.NotContains(t, w.Body.String(), "signature")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		app := New(
+			WithDebug(true),
+			WithSecret("1234"),
+			WithDisableParamsEndpoint(true))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "https://example.com/params/foo.jpg", nil))
+		assert.Equal(t, 404, w.Code)
+		assert.Equal(t, jsonStr(ErrNotFound), w.Body.String())
+	})
+}
+
+type mapStore struct {
 	Map     map[string]*Blob
 	LoadCnt map[string]int
 	SaveCnt map[string]int
@@ -217,6 +1119,11 @@ func (s *mapStore) Save(ctx context.Context, image string, blob *Blob) error {
 	return nil
 }
 
+func (s *mapStore) Delete(ctx context.Context, image string) error {
+	delete(s.Map, image)
+	return nil
+}
+
 func TestWithLoadersStoragesProcessors(t *testing.T) {
 	store := &mapStore{
 		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
@@ -413,6 +1320,85 @@ func TestWithSameStore(t *testing.T) {
 	})
 }
 
+func TestCacheKeysHostIndependent(t *testing.T) {
+	store := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	resultStore := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	var loadCnt int
+	app := New(
+		WithLoaders(
+			store,
+			loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				loadCnt++
+				return NewBlobBytes([]byte(image)), nil
+			}),
+		),
+		WithSavers(store),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+		WithUnsafe(true),
+	)
+	for _, host := range []string{
+		"https://example.com", "http://example.com", "https://cdn.example.com:8080", "http://127.0.0.1",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, host+"/unsafe/fit-in/100x100/foo.jpg", nil)
+		app.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "foo.jpg", w.Body.String())
+	}
+	assert.Equal(t, 1, loadCnt)
+	assert.Equal(t, 1, store.SaveCnt["foo.jpg"])
+	assert.Equal(t, 1, resultStore.SaveCnt["fit-in/100x100/foo.jpg"])
+	assert.Equal(t, 3, resultStore.LoadCnt["fit-in/100x100/foo.jpg"])
+	assert.Len(t, store.Map, 1)
+	assert.Len(t, resultStore.Map, 1)
+}
+
+func TestAttachment(t *testing.T) {
+	app := New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "missing" {
+				return nil, ErrNotFound
+			}
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			buf, _ := blob.ReadAll()
+			return NewBlobBytesWithMeta(buf, &Meta{Format: "jpeg", ContentType: "image/jpeg"}), nil
+		})),
+	)
+	tests := []struct {
+		name   string
+		path   string
+		header string
+	}{
+		{"filename", "/unsafe/filters:attachment(photo.jpg)/foo", "attachment; filename=photo.jpg"},
+		{"extension of format", "/unsafe/filters:attachment(photo)/foo", "attachment; filename=photo.jpeg"},
+		{"escaped", "/unsafe/filters:attachment(my%20photo.jpg)/foo", `attachment; filename="my photo.jpg"`},
+		{"path traversal", "/unsafe/filters:attachment(..%2F..%2Fetc%2Fpasswd)/foo", "attachment; filename=passwd.jpeg"},
+		{"backslash path", `/unsafe/filters:attachment(C:%5Cfoo%5Cbar.png)/foo`, "attachment; filename=bar.png"},
+		{"reserved characters", "/unsafe/filters:attachment(a%22b%3Bc%0D%0A.jpg)/foo", "attachment; filename=a_b_c__.jpg"},
+		{"non-ascii", "/unsafe/filters:attachment(%D1%84%D0%BE%D1%82%D0%BE.jpg)/foo", "attachment; filename*=utf-8''%D1%84%D0%BE%D1%82%D0%BE.jpg"},
+		{"too long", "/unsafe/filters:attachment(" + strings.Repeat("a", 300) + ".jpg)/foo",
+			"attachment; filename=" + strings.Repeat("a", 251) + ".jpg"},
+		{"empty name", "/unsafe/filters:attachment(..)/foo", ""},
+		{"no attachment", "/unsafe/filters:format(jpeg)/foo", ""},
+		{"error", "/unsafe/filters:attachment(photo.jpg)/missing", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+tt.path, nil))
+			assert.Equal(t, tt.header, w.Header().Get("Content-Disposition"))
+		})
+	}
+}
+
 func TestWithLoadTimeout(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.String(), "sleep") {
@@ -495,6 +1481,90 @@ func TestWithLoadTimeout(t *testing.T) {
 	}
 }
 
+type timeoutLoader struct {
+	loaderFunc
+	timeout time.Duration
+}
+
+func (l timeoutLoader) LoadTimeout() time.Duration {
+	return l.timeout
+}
+
+func TestTimeoutLoader(t *testing.T) {
+	var deadlines []time.Duration
+	record := func(r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		require.True(t, ok)
+		deadlines = append(deadlines, time.Until(deadline))
+	}
+	app := New(
+		WithUnsafe(true),
+		WithLoadTimeout(time.Second),
+		WithLoaders(
+			timeoutLoader{
+				loaderFunc: func(r *http.Request, image string) (*Blob, error) {
+					record(r)
+					<-r.Context().Done()
+					return nil, r.Context().Err()
+				},
+				timeout: time.Millisecond * 10,
+			},
+			loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				record(r)
+				return NewBlobBytes([]byte("ok")), nil
+			}),
+		),
+	)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	require.Len(t, deadlines, 2)
+	assert.LessOrEqual(t, deadlines[0], time.Millisecond*10)
+	assert.Greater(t, deadlines[1], time.Millisecond*500)
+
+	t.Run("zero timeout uses default", func(t *testing.T) {
+		deadlines = nil
+		app := New(
+			WithUnsafe(true),
+			WithLoadTimeout(time.Second),
+			WithLoaders(timeoutLoader{
+				loaderFunc: func(r *http.Request, image string) (*Blob, error) {
+					record(r)
+					return NewBlobBytes([]byte("ok")), nil
+				},
+			}),
+		)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+		assert.Equal(t, 200, w.Code)
+		require.Len(t, deadlines, 1)
+		assert.Greater(t, deadlines[0], time.Millisecond*500)
+		assert.LessOrEqual(t, deadlines[0], time.Second)
+	})
+	t.Run("overall deadline across loaders", func(t *testing.T) {
+		deadlines = nil
+		sleep := func(r *http.Request, image string) (*Blob, error) {
+			record(r)
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		}
+		app := New(
+			WithUnsafe(true),
+			WithLoadTimeout(time.Millisecond*50),
+			WithLoaders(
+				timeoutLoader{loaderFunc: sleep, timeout: time.Millisecond * 40},
+				timeoutLoader{loaderFunc: sleep, timeout: time.Millisecond * 40},
+			),
+		)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+		assert.Equal(t, http.StatusRequestTimeout, w.Code)
+		require.Len(t, deadlines, 2)
+		assert.LessOrEqual(t, deadlines[1], time.Millisecond*10, "remaining of overall deadline")
+	})
+}
+
 func TestSuppression(t *testing.T) {
 	app := New(
 		WithDebug(true), WithLogger(zap.NewExample()),
@@ -539,3 +1609,832 @@ func TestSuppression(t *testing.T) {
 	}
 	assert.NotEqual(t, resMap["a"], resMap["b"])
 }
+
+func TestWithProcessConcurrency(t *testing.T) {
+	process := func(t *testing.T, options ...Option) []string {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var order []string
+		app := New(append([]Option{
+			WithUnsafe(true),
+			WithProcessConcurrency(1),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobBytes([]byte(image)), nil
+			})),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				if p.Image == "block" {
+					close(started)
+					<-release
+				}
+				mu.Lock()
+				order = append(order, p.Image)
+				mu.Unlock()
+				return blob, nil
+			})),
+		}, options...)...)
+		assert.Equal(t, 1, app.ProcessConcurrency)
+		var wg sync.WaitGroup
+		do := func(image, priority string) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/"+image, nil)
+			r.Header.Set(PriorityHeader, priority)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, image, w.Body.String())
+		}
+		wg.Add(1)
+		go do("block", "0")
+		<-started
+		for i, req := range [][2]string{{"low", "1"}, {"high", "5"}, {"mid", "3"}} {
+			wg.Add(1)
+			go do(req[0], req[1])
+			for app.queue.Waiting() < i+1 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		close(release)
+		wg.Wait()
+		return order
+	}
+	t.Run("trusted priority", func(t *testing.T) {
+		assert.Equal(t, []string{"block", "high", "mid", "low"}, process(t, WithTrustPriorityHeader(true)))
+	})
+	t.Run("untrusted priority ignored", func(t *testing.T) {
+		assert.Equal(t, []string{"block", "low", "high", "mid"}, process(t))
+	})
+}
+
+func TestWithMaxConcurrentProcessing(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	resultStore := &mapStore{Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{}}
+	app := New(
+		WithUnsafe(true),
+		WithMaxConcurrentProcessing(1),
+		WithRequestTimeout(time.Millisecond*50),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			if p.Image == "block" {
+				close(started)
+				<-release
+			}
+			return blob, nil
+		})),
+	)
+	assert.Equal(t, 1, app.MaxConcurrentProcessing)
+	do := func(image string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/"+image, nil))
+		return w
+	}
+	w := do("foo")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		do("block")
+	}()
+	<-started
+
+	w = do("bar")
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, jsonStr(ErrProcessingTimeout), w.Body.String())
+
+	// result storage hit not gated
+	w = do("foo")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+
+	close(release)
+	<-done
+	for !app.sema.TryAcquire(1) {
+		time.Sleep(time.Millisecond)
+	}
+	app.sema.Release(1)
+	w = do("baz")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "baz", w.Body.String())
+}
+
+func TestWithPrettyJSON(t *testing.T) {
+	meta := &Meta{Format: "a", ContentType: "b", Width: 167, Height: 167}
+	compactMeta, _ := json.Marshal(meta)
+	prettyMeta, _ := json.MarshalIndent(meta, "", "  ")
+	compactErr, _ := json.Marshal(ErrSignatureMismatch)
+	prettyErr, _ := json.MarshalIndent(ErrSignatureMismatch, "", "  ")
+	newApp := func(options ...Option) *Imagor {
+		return New(append(options,
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobBytes([]byte("foo")), nil
+			})),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				return NewBlobBytesWithMeta([]byte("bar"), meta), nil
+			})),
+		)...)
+	}
+	tests := []struct {
+		name string
+		app  *Imagor
+		url  string
+		res  string
+	}{
+		{"default compact meta", newApp(WithUnsafe(true)), "/unsafe/meta/foo", string(compactMeta)},
+		{"default compact error", newApp(), "/meta/foo", string(compactErr)},
+		{"pretty query meta", newApp(WithUnsafe(true)), "/unsafe/meta/foo?pretty=true", string(prettyMeta)},
+		{"pretty query error", newApp(), "/meta/foo?pretty=1", string(prettyErr)},
+		{"pretty option meta", newApp(WithUnsafe(true), WithPrettyJSON(true)), "/unsafe/meta/foo", string(prettyMeta)},
+		{"pretty option error", newApp(WithPrettyJSON(true)), "/meta/foo", string(prettyErr)},
+		{"compact query override", newApp(WithUnsafe(true), WithPrettyJSON(true)), "/unsafe/meta/foo?pretty=false", string(compactMeta)},
+		{"invalid query", newApp(WithUnsafe(true)), "/unsafe/meta/foo?pretty=foo", string(compactMeta)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.app.ServeHTTP(w, httptest.NewRequest(
+				http.MethodGet, "https://example.com"+tt.url, nil))
+			assert.Equal(t, tt.res, w.Body.String())
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestProcessTimeoutPartialResult(t *testing.T) {
+	resultStore := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	app := New(
+		WithUnsafe(true),
+		WithProcessTimeout(time.Millisecond*5),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			if p.Image == "partial" {
+				// best-effort result beyond deadline
+				<-ctx.Done()
+				return NewBlobBytes([]byte("part")), nil
+			}
+			return blob, nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+	)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(
+		http.MethodGet, "https://example.com/unsafe/partial", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "part", w.Body.String())
+	assert.Equal(t, 0, resultStore.SaveCnt["partial"])
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(
+		http.MethodGet, "https://example.com/unsafe/full", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "full", w.Body.String())
+	assert.Equal(t, 1, resultStore.SaveCnt["full"])
+}
+
+func TestWithoutSourceImage(t *testing.T) {
+	var loadCnt int
+	app := New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			loadCnt++
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			if IsBlobEmpty(blob) {
+				for _, f := range p.Filters {
+					if f.Name == "canvas" {
+						return NewBlobBytes([]byte("canvas")), nil
+					}
+				}
+				return nil, ErrPass
+			}
+			return blob, nil
+		})),
+	)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(
+		http.MethodGet, "https://example.com/unsafe/filters:canvas(400,300)/", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "canvas", w.Body.String())
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(
+		http.MethodGet, "https://example.com/unsafe/filters:blur(5)/", nil))
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, jsonStr(ErrNotFound), w.Body.String())
+	assert.Equal(t, 0, loadCnt)
+}
+
+func TestWithErrorStatusCode(t *testing.T) {
+	newApp := func(options ...Option) *Imagor {
+		return New(append([]Option{
+			WithUnsafe(true),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				switch image {
+				case "missing":
+					return nil, ErrNotFound
+				case "foo", "bar":
+					return NewBlobBytes([]byte(image)), nil
+				}
+				return nil, ErrPass
+			})),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				if buf, _ := blob.ReadAll(); string(buf) == "bar" {
+					// image loaded by processor passed by all loaders
+					if _, err := load("unknown"); err != nil {
+						return nil, err
+					}
+				}
+				return blob, nil
+			})),
+		}, options...)...)
+	}
+	tests := []struct {
+		name    string
+		options []Option
+		path    string
+		code    int
+		res     string
+	}{
+		{"pass default", nil, "/unsafe/unknown", 404, jsonStr(ErrNotFound)},
+		{"not found default", nil, "/unsafe/missing", 404, jsonStr(ErrNotFound)},
+		{
+			"pass", []Option{WithErrorStatusCode(ErrPass, 502)},
+			"/unsafe/unknown", 502, jsonStr(NewError(ErrNotFound.Message, 502)),
+		},
+		{
+			"pass not affecting not found", []Option{WithErrorStatusCode(ErrPass, 502)},
+			"/unsafe/missing", 404, jsonStr(ErrNotFound),
+		},
+		{
+			"not found", []Option{WithErrorStatusCode(ErrPass, 502), WithErrorStatusCode(ErrNotFound, 410)},
+			"/unsafe/missing", 410, jsonStr(NewError(ErrNotFound.Message, 410)),
+		},
+		{
+			"pass on processor load as not found", []Option{WithErrorStatusCode(ErrPass, 502)},
+			"/unsafe/bar", 404, "bar",
+		},
+		{
+			"signature mismatch", []Option{WithErrorStatusCode(ErrSignatureMismatch, 401)},
+			"/foo", 401, jsonStr(NewError(ErrSignatureMismatch.Message, 401)),
+		},
+		{"skipped if not positive", []Option{WithErrorStatusCode(ErrPass, 0)}, "/unsafe/unknown", 404, jsonStr(ErrNotFound)},
+		{"ok", []Option{WithErrorStatusCode(ErrPass, 502)}, "/unsafe/foo", 200, "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newApp(tt.options...).ServeHTTP(w, httptest.NewRequest(
+				http.MethodGet, "https://example.com"+tt.path, nil))
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.res, w.Body.String())
+		})
+	}
+}
+
+func TestWithErrorImage(t *testing.T) {
+	gif := []byte("GIF89a placeholder")
+	app := New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithErrorImage(NewBlobBytes(gif)),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "broken.jpg" {
+				return nil, ErrUnsupportedFormat
+			}
+			return nil, ErrNotFound
+		})))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+path, nil))
+		return w
+	}
+	for path, code := range map[string]int{
+		"unsafe/foo.jpg":    404,
+		"unsafe/broken.jpg": 406,
+	} {
+		w := serve(path)
+		assert.Equal(t, code, w.Code, path)
+		assert.Equal(t, "image/gif", w.Header().Get("Content-Type"), path)
+		assert.Equal(t, string(gif), w.Body.String(), path)
+		assert.Equal(t, "private, no-cache, no-store, must-revalidate", w.Header().Get("Cache-Control"), path)
+		assert.Empty(t, w.Header().Get("ETag"), path)
+	}
+
+	w := serve("foo.jpg")
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String(), "no error image on signature mismatch")
+
+	w = serve("unsafe/meta/foo.jpg")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, jsonStr(ErrNotFound), w.Body.String(), "no error image on meta")
+
+	notFound := WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		return nil, ErrNotFound
+	}))
+	app = New(
+		WithUnsafe(true),
+		notFound,
+		WithErrorImage(NewBlobBytes(gif)),
+		WithErrorImageStatusCode(200),
+		WithErrorImageStatusCode(0))
+	w = serve("unsafe/foo.jpg")
+	assert.Equal(t, 200, w.Code, "configured status code")
+	assert.Equal(t, string(gif), w.Body.String())
+
+	app = New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithErrorImage(NewBlobBytes(gif)),
+		WithErrorStatusCode(ErrSignatureMismatch, 404))
+	w = serve("foo.jpg")
+	assert.Equal(t, 404, w.Code, "overridden status code")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"),
+		"no error image on signature mismatch of overridden status code")
+
+	app = New(WithUnsafe(true), notFound, WithErrorImage(NewBlobFilePath("non-exists.gif")))
+	w = serve("unsafe/foo.jpg")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, jsonStr(ErrNotFound), w.Body.String(), "JSON error if error image not available")
+
+	app = New(WithUnsafe(true), notFound, WithPrettyJSON(true),
+		WithErrorImage(NewBlobFilePath("non-exists.gif")))
+	w = serve("unsafe/foo.jpg")
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), "\n  ", "pretty JSON if error image not available")
+}
+
+func TestWithErrorLogSampling(t *testing.T) {
+	newApp := func(n int) (*Imagor, *observer.ObservedLogs) {
+		core, logs := observer.New(zap.WarnLevel)
+		return New(
+			WithUnsafe(true),
+			WithLogger(zap.New(core)),
+			WithErrorLogSampling(n),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				if strings.HasPrefix(image, "missing") {
+					return nil, ErrNotFound
+				}
+				return nil, errors.New("upstream error " + image)
+			})),
+		), logs
+	}
+	request := func(app *Imagor, path string) {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(
+			http.MethodGet, "https://example.com/unsafe/"+path, nil))
+	}
+	t.Run("sampled per error type", func(t *testing.T) {
+		app, logs := newApp(2)
+		now := time.Now()
+		app.sampler.now = func() time.Time { return now }
+		for i := 0; i < 5; i++ {
+			request(app, "missing"+strconv.Itoa(i))
+			request(app, "error"+strconv.Itoa(i))
+		}
+		assert.Equal(t, 4, logs.FilterMessage("load").Len())
+		assert.Equal(t, 2, logs.FilterField(zap.Error(ErrNotFound)).Len())
+
+		now = now.Add(time.Second)
+		request(app, "missing5")
+		assert.Equal(t, 5, logs.FilterMessage("load").Len())
+	})
+	t.Run("no sampling", func(t *testing.T) {
+		app, logs := newApp(0)
+		assert.Nil(t, app.sampler)
+		for i := 0; i < 5; i++ {
+			request(app, "missing"+strconv.Itoa(i))
+		}
+		assert.Equal(t, 5, logs.FilterMessage("load").Len())
+	})
+}
+
+func TestWithFallbackImages(t *testing.T) {
+	newApp := func(fallback string) (*Imagor, *mapStore) {
+		resultStore := &mapStore{
+			Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+		}
+		return New(
+			WithUnsafe(true),
+			WithFallbackImages(fallback),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				if strings.HasPrefix(image, "missing") || strings.HasSuffix(image, "/missing") {
+					return nil, ErrNotFound
+				}
+				if image == "404" {
+					return NewBlobBytes([]byte("not found page")), NewErrorFromStatusCode(404)
+				}
+				return NewBlobBytes([]byte(image)), nil
+			})),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				buf, _ := blob.ReadAll()
+				return NewBlobBytes([]byte("processed:" + string(buf))), nil
+			})),
+			WithResultLoaders(resultStore),
+			WithResultSavers(resultStore),
+		), resultStore
+	}
+	tests := []struct {
+		name     string
+		fallback string
+		path     string
+		code     int
+		res      string
+	}{
+		{"found", "default.jpg", "/unsafe/foo.jpg", 200, "processed:foo.jpg"},
+		{"default fallback", "default.jpg", "/unsafe/missing.jpg", 200, "processed:default.jpg"},
+		{"http not found fallback", "default.jpg", "/unsafe/404", 200, "processed:default.jpg"},
+		{"prefix fallback", "default.jpg,products/=products/default.jpg", "/unsafe/products/missing", 200, "processed:products/default.jpg"},
+		{"longest prefix fallback", "products/=products/default.jpg, products/shoes/=shoes.jpg", "/unsafe/products/shoes/missing", 200, "processed:shoes.jpg"},
+		{"no matching prefix", "products/=products/default.jpg", "/unsafe/users/missing", 404, jsonStr(ErrNotFound)},
+		{"fallback missing", "missing-default.jpg", "/unsafe/missing.jpg", 404, jsonStr(ErrNotFound)},
+		{"no fallback", "", "/unsafe/missing.jpg", 404, jsonStr(ErrNotFound)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, resultStore := newApp(tt.fallback)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(
+				http.MethodGet, "https://example.com"+tt.path, nil))
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.res, w.Body.String())
+			if tt.name == "found" {
+				assert.Equal(t, 1, len(resultStore.Map))
+			} else {
+				// fallback result not stored
+				assert.Empty(t, resultStore.Map)
+			}
+		})
+	}
+	t.Run("resolver", func(t *testing.T) {
+		app := New(
+			WithUnsafe(true),
+			WithFallbackResolver(func(image string) string {
+				return strings.TrimSuffix(image, ".webp") + ".jpg"
+			}),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				if strings.HasSuffix(image, ".webp") {
+					return nil, ErrNotFound
+				}
+				return NewBlobBytes([]byte(image)), nil
+			})),
+		)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "https://example.com/unsafe/fit-in/100x100/foo.webp", nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "foo.jpg", w.Body.String())
+	})
+}
+
+func TestWithDiagnosticHeaders(t *testing.T) {
+	newApp := func(enabled bool, processor processorFunc) *Imagor {
+		resultStore := &mapStore{
+			Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+		}
+		return New(
+			WithUnsafe(true),
+			WithDiagnosticHeaders(enabled),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobBytes([]byte(image)), nil
+			})),
+			WithProcessors(processor),
+			WithResultLoaders(resultStore),
+			WithResultSavers(resultStore),
+		)
+	}
+	process := processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		time.Sleep(time.Millisecond * 5)
+		return NewBlobBytes([]byte("processed")), nil
+	})
+	pass := processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		return blob, nil
+	})
+	serve := func(app *Imagor, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "https://example.com"+path, nil))
+		return w
+	}
+	t.Run("fresh and cached", func(t *testing.T) {
+		app := newApp(true, process)
+		w := serve(app, "/unsafe/fit-in/100x100/source-image")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "processed", w.Body.String())
+		assert.Equal(t, "miss", w.Header().Get("X-Imagor-Cache"))
+		assert.Equal(t, "12", w.Header().Get("X-Imagor-Source-Bytes"))
+		ms, err := strconv.ParseFloat(w.Header().Get("X-Imagor-Process-Time"), 64)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, ms, float64(5))
+
+		w = serve(app, "/unsafe/fit-in/100x100/source-image")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "processed", w.Body.String())
+		assert.Equal(t, "hit", w.Header().Get("X-Imagor-Cache"))
+		assert.Empty(t, w.Header().Get("X-Imagor-Source-Bytes"))
+		assert.Empty(t, w.Header().Get("X-Imagor-Process-Time"))
+	})
+	t.Run("passed through", func(t *testing.T) {
+		w := serve(newApp(true, pass), "/unsafe/foo")
+		assert.Equal(t, "foo", w.Body.String())
+		assert.Equal(t, "miss", w.Header().Get("X-Imagor-Cache"))
+		assert.Equal(t, "3", w.Header().Get("X-Imagor-Source-Bytes"))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		app := newApp(false, process)
+		for i := 0; i < 2; i++ {
+			w := serve(app, "/unsafe/foo")
+			assert.Equal(t, "processed", w.Body.String())
+			assert.Empty(t, w.Header().Get("X-Imagor-Cache"))
+			assert.Empty(t, w.Header().Get("X-Imagor-Source-Bytes"))
+			assert.Empty(t, w.Header().Get("X-Imagor-Process-Time"))
+		}
+	})
+}
+
+func TestWarm(t *testing.T) {
+	resultStore := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	var processCnt int
+	app := New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "missing" {
+				return nil, ErrNotFound
+			}
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			processCnt++
+			buf, _ := blob.ReadAll()
+			return NewBlobBytes([]byte("processed:" + string(buf))), nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+	)
+	signed := imagorpath.Generate(imagorpath.Params{Width: 10, Height: 10, Image: "c"}, "1234")
+	results := app.Warm(context.Background(),
+		"unsafe/fit-in/100x100/a",
+		"/unsafe/filters:fill(white,1)/b",
+		signed,
+		"unsafe/missing",
+		"badhash/10x10/c",
+	)
+	assert.Equal(t, []WarmResult{
+		{Path: "/unsafe/fit-in/100x100/a"},
+		{Path: "/unsafe/filters:fill(white,1)/b"},
+		{Path: "/" + signed},
+		{Path: "/unsafe/missing", Error: ErrNotFound.Error()},
+		{Path: "/badhash/10x10/c", Error: ErrSignatureMismatch.Error()},
+	}, results)
+	assert.Equal(t, 3, processCnt)
+	assert.Equal(t, 3, len(resultStore.Map))
+	assert.Equal(t, 1, resultStore.SaveCnt["fit-in/100x100/a"])
+	assert.Equal(t, 1, resultStore.SaveCnt["filters:fill(white,1)/b"])
+	assert.Equal(t, 1, resultStore.SaveCnt["10x10/c"])
+
+	// served from result storage
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(
+		http.MethodGet, "https://example.com/unsafe/fit-in/100x100/a", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "processed:a", w.Body.String())
+	assert.Equal(t, 3, processCnt)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, []WarmResult{
+		{Path: "/unsafe/d", Error: context.Canceled.Error()},
+	}, app.Warm(ctx, "unsafe/d"))
+}
+
+func TestWithWarmPaths(t *testing.T) {
+	resultStore := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	app := New(
+		WithUnsafe(true),
+		WithWarmPaths("unsafe/fit-in/100x100/a  unsafe/filters:fill(white,1)/b", "\nunsafe/c "),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+	)
+	assert.Equal(t, []string{
+		"unsafe/fit-in/100x100/a", "unsafe/filters:fill(white,1)/b", "unsafe/c",
+	}, app.WarmPaths)
+}
+
+func TestPurge(t *testing.T) {
+	resultStore := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	var processCnt int
+	app := New(
+		WithUnsafe(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			processCnt++
+			return blob, nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore, saverFunc(func(ctx context.Context, image string, blob *Blob) error {
+			// saver without Deleter skipped
+			return nil
+		})),
+	)
+	request := func(path string) {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+		assert.Equal(t, 200, w.Code)
+	}
+	request("/unsafe/fit-in/100x100/a")
+	request("/unsafe/b")
+	request("/unsafe/fit-in/100x100/a")
+	assert.Equal(t, 2, processCnt)
+	assert.Equal(t, 2, len(resultStore.Map))
+
+	require.NoError(t, app.Purge(context.Background(), "unsafe/fit-in/100x100/a", "/unsafe/meta/c"))
+	assert.Equal(t, 1, len(resultStore.Map))
+	assert.NotNil(t, resultStore.Map["b"])
+
+	// processed again once purged
+	request("/unsafe/fit-in/100x100/a")
+	request("/unsafe/b")
+	assert.Equal(t, 3, processCnt)
+	assert.Equal(t, 2, resultStore.SaveCnt["fit-in/100x100/a"])
+}
+
+func TestWithPurgeEndpoint(t *testing.T) {
+	store := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	resultStore := &mapStore{
+		Map: map[string]*Blob{}, LoadCnt: map[string]int{}, SaveCnt: map[string]int{},
+	}
+	var processCnt int
+	app := New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithPurgeEndpoint(true),
+		WithLoaders(store, loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithSavers(store),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			processCnt++
+			return blob, nil
+		})),
+		WithResultLoaders(resultStore),
+		WithResultSavers(resultStore),
+	)
+	signed := "/" + imagorpath.Generate(imagorpath.Params{Width: 10, Height: 10, Image: "a"}, "1234")
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(method, "https://example.com"+path, nil))
+		return w
+	}
+	assert.Equal(t, 200, request(http.MethodGet, signed).Code)
+	assert.Equal(t, 200, request(http.MethodGet, signed).Code)
+	assert.Equal(t, 1, processCnt)
+	assert.Equal(t, 1, len(resultStore.Map))
+	assert.Equal(t, 1, len(store.Map))
+
+	w := request(http.MethodDelete, "/unsafe/10x10/a")
+	assert.Equal(t, 403, w.Code, "unsafe not allowed")
+	assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+	w = request(http.MethodDelete, "/"+imagorpath.Sign("20x20/a", "1234")+"/10x10/a")
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, 1, len(resultStore.Map))
+
+	w = request(http.MethodDelete, signed)
+	assert.Equal(t, 204, w.Code)
+	assert.Empty(t, resultStore.Map)
+	assert.Equal(t, 1, len(store.Map), "source kept")
+
+	// reprocessed on next request
+	assert.Equal(t, 200, request(http.MethodGet, signed).Code)
+	assert.Equal(t, 2, processCnt)
+	assert.Equal(t, 1, len(resultStore.Map))
+
+	w = request(http.MethodDelete, signed+"?source=true")
+	assert.Equal(t, 204, w.Code)
+	assert.Empty(t, resultStore.Map)
+	assert.Empty(t, store.Map)
+
+	t.Run("disabled", func(t *testing.T) {
+		app := New(WithSecret("1234"), WithResultSavers(resultStore))
+		resultStore.Map["10x10/a"] = NewBlobBytes([]byte("a"))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "https://example.com"+signed, nil))
+		assert.NotEqual(t, 204, w.Code)
+		assert.Equal(t, 1, len(resultStore.Map))
+	})
+	t.Run("empty secret", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithPurgeEndpoint(true), WithResultSavers(resultStore))
+		resultStore.Map["10x10/a"] = NewBlobBytes([]byte("a"))
+		// signature of empty secret computable by anyone
+		forged := "/" + imagorpath.Generate(imagorpath.Params{Width: 10, Height: 10, Image: "a"}, "")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "https://example.com"+forged, nil))
+		assert.Equal(t, 403, w.Code)
+		assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+		assert.Equal(t, 1, len(resultStore.Map))
+	})
+}
+
+func TestWithBatchEndpoint(t *testing.T) {
+	app := New(
+		WithSecret("1234"),
+		WithUnsafe(true),
+		WithBatchEndpoint(true),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "missing" {
+				return nil, ErrNotFound
+			}
+			return NewBlobBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			buf, _ := blob.ReadAll()
+			return NewBlobBytesWithMeta([]byte("processed:"+string(buf)), &Meta{
+				Format: "png", ContentType: "image/png", Width: p.Width, Height: p.Height,
+			}), nil
+		})),
+	)
+	signed := imagorpath.Generate(imagorpath.Params{Width: 10, Height: 10, Image: "c"}, "1234")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/batch", strings.NewReader(
+		"unsafe/fit-in/100x100/a\n/unsafe/b "+signed+"\nunsafe/missing\nbadhash/10x10/c\nunsafe/meta/20x20/d\n",
+	)))
+	assert.Equal(t, 200, w.Code)
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	type result struct {
+		path, status, contentType, body string
+	}
+	var results []result
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		buf, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(len(buf)), part.Header.Get("Content-Length"))
+		results = append(results, result{
+			part.Header.Get("X-Imagor-Path"), part.Header.Get("X-Imagor-Status"),
+			part.Header.Get("Content-Type"), string(buf),
+		})
+	}
+	metaBuf, _ := json.Marshal(&Meta{Format: "png", ContentType: "image/png", Width: 20, Height: 20})
+	assert.Equal(t, []result{
+		{"/unsafe/fit-in/100x100/a", "200", "image/png", "processed:a"},
+		{"/unsafe/b", "200", "image/png", "processed:b"},
+		{"/" + signed, "200", "image/png", "processed:c"},
+		{"/unsafe/missing", "404", "application/json", jsonStr(ErrNotFound)},
+		{"/badhash/10x10/c", "403", "application/json", jsonStr(ErrSignatureMismatch)},
+		{"/unsafe/meta/20x20/d", "200", "application/json", string(metaBuf)},
+	}, results)
+
+	t.Run("max paths", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithBatchEndpoint(true), WithBatchMaxPaths(2),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobBytes([]byte(image)), nil
+			})))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/batch", strings.NewReader("unsafe/a unsafe/b")))
+		assert.Equal(t, 200, w.Code)
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/batch", strings.NewReader("unsafe/a unsafe/b unsafe/c")))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Equal(t, jsonStr(ErrMaxPathsExceeded), w.Body.String())
+		assert.Equal(t, 100, New().BatchMaxPaths)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobBytes([]byte(image)), nil
+		})))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/batch", strings.NewReader("unsafe/a")))
+		assert.NotContains(t, w.Header().Get("Content-Type"), "multipart")
+	})
+}
@@ -12,6 +12,9 @@ func generate(p Params) string {
 	if p.Meta {
 		parts = append(parts, "meta")
 	}
+	if p.Expire > 0 {
+		parts = append(parts, "exp:"+strconv.FormatInt(p.Expire, 10))
+	}
 	if p.Trim || (p.TrimBy == TrimByTopLeft || p.TrimBy == TrimByBottomRight) {
 		trims := []string{"trim"}
 		if p.TrimBy == TrimByBottomRight {
@@ -1,12 +1,5 @@
 package imagorpath
 
-import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base64"
-	"strings"
-)
-
 const (
 	TrimByTopLeft     = "top-left"
 	TrimByBottomRight = "bottom-right"
@@ -26,6 +19,7 @@ type Params struct {
 	Unsafe        bool    `json:"unsafe,omitempty"`
 	Hash          string  `json:"hash,omitempty"`
 	Meta          bool    `json:"meta,omitempty"`
+	Expire        int64   `json:"expire,omitempty"`
 	Trim          bool    `json:"trim,omitempty"`
 	TrimBy        string  `json:"trim_by,omitempty"`
 	TrimTolerance int     `json:"trim_tolerance,omitempty"`
@@ -56,8 +50,5 @@ type Filter struct {
 
 // Sign an Imagor endpoint with secret key
 func Sign(path, secret string) string {
-	h := hmac.New(sha1.New, []byte(secret))
-	h.Write([]byte(strings.TrimPrefix(path, "/")))
-	hash := base64.URLEncoding.EncodeToString(h.Sum(nil))
-	return hash
+	return NewDefaultSigner(secret).Sign(path)
 }
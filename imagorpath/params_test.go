@@ -1,6 +1,8 @@
 package imagorpath
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"reflect"
@@ -64,6 +66,18 @@ func TestParseGenerate(t *testing.T) {
 				Filters:       []Filter{{Name: "some_filter"}},
 			},
 		},
+		{
+			name: "expire",
+			uri:  "meta/exp:1700000000/fit-in/100x0/img",
+			params: Params{
+				Path:   "meta/exp:1700000000/fit-in/100x0/img",
+				Image:  "img",
+				Meta:   true,
+				Expire: 1700000000,
+				FitIn:  true,
+				Width:  100,
+			},
+		},
 		{
 			name: "url in filter",
 			uri:  "filters:watermark(s.glbimg.com/es/ge/f/original/2011/03/29/orlandosilva_60.jpg,0,0,0)/img",
@@ -94,6 +108,24 @@ func TestParseGenerate(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "nested filter args",
+			uri:  "filters:css(brightness(1.1)%20contrast(1.2)):format(webp)/img",
+			params: Params{
+				Path:  "filters:css(brightness(1.1)%20contrast(1.2)):format(webp)/img",
+				Image: "img",
+				Filters: []Filter{
+					{
+						Name: "css",
+						Args: "brightness(1.1)%20contrast(1.2)",
+					},
+					{
+						Name: "format",
+						Args: "webp",
+					},
+				},
+			},
+		},
 		{
 			name: "no params",
 			uri:  "unsafe/https://thumbor.readthedocs.io/en/latest/_images/man_before_sharpen.png",
@@ -209,3 +241,42 @@ func TestClean(t *testing.T) {
 		"should exclude escape space",
 	)
 }
+
+func TestSigner(t *testing.T) {
+	path := "fit-in/500x500/filters:fill(white)/foo.jpg"
+	assert.Equal(t, Sign(path, "1234"), NewDefaultSigner("1234").Sign(path))
+	assert.Equal(t, Sign(path, "1234"), NewDefaultSigner("1234").Sign("/"+path))
+
+	full := NewHMACSigner(sha256.New, 0, "1234").Sign(path)
+	assert.Len(t, full, 44)
+	truncated := NewHMACSigner(sha256.New, 27, "1234").Sign(path)
+	assert.Equal(t, full[:27], truncated)
+	assert.Equal(t, full[:26], NewHMACSigner(sha256.New, 8, "1234").Sign(path), "truncate clamped")
+	assert.Equal(t, full[:28], NewHMACSigner(sha256.New, 40, "1234").Sign(path), "truncate clamped")
+	sha512 := NewHMACSigner(sha512.New, 0, "1234").Sign(path)
+	assert.Len(t, sha512, 88)
+
+	for _, hash := range []string{full, truncated, sha512, Sign(path, "1234")} {
+		p := Parse(hash + "/" + path)
+		assert.Equal(t, hash, p.Hash)
+		assert.Equal(t, path, p.Path)
+		assert.Equal(t, "foo.jpg", p.Image)
+	}
+}
+
+func TestParseUnsigned(t *testing.T) {
+	p := Parse("products/shoe.jpg")
+	assert.Empty(t, p.Hash)
+	assert.Equal(t, "products/shoe.jpg", p.Path)
+	assert.Equal(t, "products/shoe.jpg", p.Image)
+
+	p = Parse("1000x1000/img.jpg")
+	assert.Empty(t, p.Hash)
+	assert.Equal(t, 1000, p.Width)
+	assert.Equal(t, 1000, p.Height)
+	assert.Equal(t, "img.jpg", p.Image)
+
+	p = Parse("123e4567-e89b-12d3-a456-426614174000/img.jpg")
+	assert.Empty(t, p.Hash)
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174000/img.jpg", p.Image)
+}
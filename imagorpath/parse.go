@@ -11,8 +11,8 @@ var pathRegex = regexp.MustCompile(
 	"/*" +
 		// params
 		"(params/)?" +
-		// hash
-		"((unsafe/)|([A-Za-z0-9-_=]{26,30})/)?" +
+		// hash of HMAC SHA1 or truncated, HMAC SHA256, HMAC SHA512
+		"((unsafe/)|([A-Za-z0-9-_=]{26,30}|[A-Za-z0-9-_]{43}=|[A-Za-z0-9-_]{86}==)/)?" +
 		// path
 		"(.+)?",
 )
@@ -21,6 +21,8 @@ var paramsRegex = regexp.MustCompile(
 	"/*" +
 		// meta
 		"(meta/)?" +
+		// expire
+		"(exp:(\\d+)/)?" +
 		// trim
 		"(trim(:(top-left|bottom-right))?(:(\\d+))?/)?" +
 		// crop
@@ -45,7 +47,7 @@ var paramsRegex = regexp.MustCompile(
 		"(.+)?",
 )
 
-var filterRegex = regexp.MustCompile("(.+)\\((.*)\\)")
+var filterRegex = regexp.MustCompile("([^(]+)\\((.*)\\)")
 
 // Parse Params struct from Imagor endpoint URI
 func Parse(path string) (p Params) {
@@ -60,7 +62,7 @@ func Parse(path string) (p Params) {
 	index += 1
 	if match[index+1] == "unsafe/" {
 		p.Unsafe = true
-	} else if len(match[index+2]) <= 28 {
+	} else if n := len(match[index+2]); n <= 28 || n > 30 {
 		p.Hash = match[index+2]
 	}
 	index += 3
@@ -75,6 +77,10 @@ func Parse(path string) (p Params) {
 		p.Meta = true
 	}
 	index += 1
+	if match[index] != "" {
+		p.Expire, _ = strconv.ParseInt(match[index+1], 10, 64)
+	}
+	index += 2
 	if match[index] != "" {
 		p.Trim = true
 		p.TrimBy = TrimByTopLeft
@@ -142,9 +148,7 @@ func Parse(path string) (p Params) {
 }
 
 func parseFilters(filters string) (results []Filter) {
-	splits := strings.Split(filters, "):")
-	for _, seg := range splits {
-		seg = strings.TrimSuffix(seg, ")") + ")"
+	for _, seg := range splitFilters(filters) {
 		if match := filterRegex.FindStringSubmatch(seg); len(match) >= 3 {
 			results = append(results, Filter{
 				Name: strings.ToLower(match[1]),
@@ -154,3 +158,25 @@ func parseFilters(filters string) (results []Filter) {
 	}
 	return
 }
+
+// splitFilters splits filters by colon outside parentheses,
+// such that args may contain nested parentheses
+func splitFilters(filters string) (splits []string) {
+	var depth, start int
+	for i := 0; i < len(filters); i++ {
+		switch filters[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ':':
+			if depth == 0 && i > 0 && filters[i-1] == ')' {
+				splits = append(splits, filters[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(splits, filters[start:])
+}
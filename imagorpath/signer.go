@@ -0,0 +1,56 @@
+package imagorpath
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"hash"
+	"strings"
+)
+
+// Signer signs Imagor endpoint path for URL signature
+type Signer interface {
+	Sign(path string) string
+}
+
+const (
+	signerMinTruncate = 26
+	signerMaxTruncate = 28
+)
+
+type hmacSigner struct {
+	alg      func() hash.Hash
+	truncate int
+	secret   []byte
+}
+
+// NewDefaultSigner HMAC-SHA1 signer with secret, same as Sign
+func NewDefaultSigner(secret string) Signer {
+	return NewHMACSigner(sha1.New, 0, secret)
+}
+
+// NewHMACSigner HMAC signer of hash algorithm with secret, e.g. sha256.New,
+// base64url-encoded hash truncated to the length if truncate > 0.
+// Truncate length is clamped within 26 to 28,
+// such that the hash is distinguished from path segments when parsed
+func NewHMACSigner(alg func() hash.Hash, truncate int, secret string) Signer {
+	if truncate > 0 {
+		if truncate < signerMinTruncate {
+			truncate = signerMinTruncate
+		} else if truncate > signerMaxTruncate {
+			truncate = signerMaxTruncate
+		}
+	}
+	return &hmacSigner{alg: alg, truncate: truncate, secret: []byte(secret)}
+}
+
+// Sign implements Signer
+func (s *hmacSigner) Sign(path string) string {
+	h := hmac.New(s.alg, s.secret)
+	h.Write([]byte(strings.TrimPrefix(path, "/")))
+	hash := base64.URLEncoding.EncodeToString(h.Sum(nil))
+	if s.truncate > 0 && len(hash) > s.truncate {
+		hash = hash[:s.truncate]
+	}
+	return hash
+}
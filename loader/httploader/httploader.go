@@ -5,10 +5,17 @@ import (
 	"fmt"
 	"github.com/cshum/imagor"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	errTooManyRedirects   = imagor.NewError("too many redirects", http.StatusBadGateway)
+	errRedirectNotAllowed = imagor.NewError("redirect to source not allowed", http.StatusForbidden)
 )
 
 type HTTPLoader struct {
@@ -31,6 +38,10 @@ type HTTPLoader struct {
 	// MaxAllowedSize maximum bytes allowed for image
 	MaxAllowedSize int
 
+	// MaxRedirects maximum number of redirects to follow, each validated against AllowedSources.
+	// Redirect not followed if 0
+	MaxRedirects int
+
 	// DefaultScheme default image URL scheme
 	DefaultScheme string
 
@@ -39,14 +50,25 @@ type HTTPLoader struct {
 	UserAgent string
 
 	accepts []string
+	dialer  *net.Dialer
 }
 
 func New(options ...Option) *HTTPLoader {
+	// pooled keep-alive connections, such that origins are not re-dialed per image
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
 	h := &HTTPLoader{
-		Transport:       http.DefaultTransport.(*http.Transport).Clone(),
+		Transport:       transport,
 		OverrideHeaders: map[string]string{},
 		DefaultScheme:   "https",
+		MaxRedirects:    3,
 		UserAgent:       fmt.Sprintf("Imagor/%s", imagor.Version),
+		dialer:          dialer,
 	}
 	for _, option := range options {
 		option(h)
@@ -65,7 +87,7 @@ func New(options ...Option) *HTTPLoader {
 }
 
 func (h *HTTPLoader) Load(r *http.Request, image string) (*imagor.Blob, error) {
-	if r.Method != http.MethodGet || image == "" {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || image == "" {
 		return nil, imagor.ErrPass
 	}
 	u, err := url.Parse(image)
@@ -85,7 +107,7 @@ func (h *HTTPLoader) Load(r *http.Request, image string) (*imagor.Blob, error) {
 	if !isURLAllowed(u, h.AllowedSources) {
 		return nil, imagor.ErrPass
 	}
-	client := &http.Client{Transport: h.Transport}
+	client := &http.Client{Transport: h.Transport, CheckRedirect: h.checkRedirect}
 	if h.MaxAllowedSize > 0 {
 		req, err := h.newRequest(r, http.MethodHead, image)
 		if err != nil {
@@ -93,7 +115,7 @@ func (h *HTTPLoader) Load(r *http.Request, image string) (*imagor.Blob, error) {
 		}
 		resp, err := client.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, unwrapRedirectErr(err)
 		}
 		_ = resp.Body.Close()
 		if resp.StatusCode < 200 && resp.StatusCode > 206 {
@@ -110,8 +132,9 @@ func (h *HTTPLoader) Load(r *http.Request, image string) (*imagor.Blob, error) {
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, unwrapRedirectErr(err)
 	}
+	defer resp.Body.Close()
 	body := resp.Body
 	if resp.Header.Get("Content-Encoding") == "gzip" {
 		gzipBody, err := gzip.NewReader(resp.Body)
@@ -136,6 +159,28 @@ func (h *HTTPLoader) Load(r *http.Request, image string) (*imagor.Blob, error) {
 	return imagor.NewBlobBytes(buf), nil
 }
 
+// checkRedirect caps the number of redirects,
+// and validates each redirect target against AllowedSources
+func (h *HTTPLoader) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > h.MaxRedirects {
+		return errTooManyRedirects
+	}
+	if !isURLAllowed(req.URL, h.AllowedSources) {
+		return errRedirectNotAllowed
+	}
+	return nil
+}
+
+// unwrapRedirectErr unwraps imagor error of checkRedirect from url.Error
+func unwrapRedirectErr(err error) error {
+	if e, ok := err.(*url.Error); ok {
+		if ie, ok := e.Err.(imagor.Error); ok {
+			return ie
+		}
+	}
+	return err
+}
+
 func (h *HTTPLoader) newRequest(r *http.Request, method, url string) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(r.Context(), method, url, nil)
 	if err != nil {
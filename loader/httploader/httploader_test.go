@@ -6,10 +6,15 @@ import (
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testTransport map[string]string
@@ -424,3 +429,176 @@ func TestWithAccept(t *testing.T) {
 		},
 	})
 }
+
+func newConnCountServer(t testing.TB) (*httptest.Server, *int64) {
+	var conns int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts, &conns
+}
+
+func TestConnectionReuse(t *testing.T) {
+	t.Run("keep-alive", func(t *testing.T) {
+		ts, conns := newConnCountServer(t)
+		loader := New()
+		for i := 0; i < 10; i++ {
+			target := ts.URL + "/image"
+			if i%2 == 1 {
+				target = ts.URL + "/missing"
+			}
+			_, _ = loader.Load(httptest.NewRequest(http.MethodGet, "https://example.com/imagor", nil), target)
+		}
+		assert.Equal(t, int64(1), atomic.LoadInt64(conns))
+	})
+	t.Run("concurrent pooled", func(t *testing.T) {
+		ts, conns := newConnCountServer(t)
+		loader := New(WithMaxIdleConnsPerHost(4))
+		for round := 0; round < 5; round++ {
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b, err := loader.Load(httptest.NewRequest(http.MethodGet, "https://example.com/imagor", nil), ts.URL)
+					assert.NoError(t, err)
+					buf, _ := b.ReadAll()
+					assert.Equal(t, "ok", string(buf))
+				}()
+			}
+			wg.Wait()
+		}
+		// connections reused across rounds, not dialed per request
+		assert.LessOrEqual(t, atomic.LoadInt64(conns), int64(8))
+	})
+	t.Run("keep-alive disabled", func(t *testing.T) {
+		ts, conns := newConnCountServer(t)
+		loader := New(WithKeepAlive(-1))
+		for i := 0; i < 3; i++ {
+			_, err := loader.Load(httptest.NewRequest(http.MethodGet, "https://example.com/imagor", nil), ts.URL)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int64(3), atomic.LoadInt64(conns))
+	})
+}
+
+func TestWithTransportOptions(t *testing.T) {
+	loader := New(
+		WithMaxIdleConns(20),
+		WithMaxIdleConnsPerHost(10),
+		WithIdleConnTimeout(time.Minute),
+		WithKeepAlive(time.Second*15),
+		WithDialTimeout(time.Second*5),
+		WithTLSHandshakeTimeout(time.Second*3),
+		WithResponseHeaderTimeout(time.Second*2),
+	)
+	transport := loader.Transport.(*http.Transport)
+	assert.Equal(t, 20, transport.MaxIdleConns)
+	assert.Equal(t, 10, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	assert.Equal(t, time.Second*3, transport.TLSHandshakeTimeout)
+	assert.Equal(t, time.Second*2, transport.ResponseHeaderTimeout)
+	assert.False(t, transport.DisableKeepAlives)
+	assert.Equal(t, time.Second*15, loader.dialer.KeepAlive)
+	assert.Equal(t, time.Second*5, loader.dialer.Timeout)
+
+	loader = New()
+	transport = loader.Transport.(*http.Transport)
+	assert.Equal(t, 100, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, loader.dialer.Timeout)
+}
+
+func BenchmarkLoad(b *testing.B) {
+	ts, _ := newConnCountServer(b)
+	loader := New()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/imagor", nil)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := loader.Load(r, ts.URL); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestWithMaxRedirects(t *testing.T) {
+	var blockedCnt int32
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&blockedCnt, 1)
+		_, _ = w.Write([]byte("blocked"))
+	}))
+	defer blocked.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/blocked" {
+			http.Redirect(w, r, blocked.URL+"/image", http.StatusFound)
+			return
+		}
+		// redirect chain of n hops before serving image
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if n > 0 {
+			http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	loader := New(WithAllowedSources(host))
+	assert.Equal(t, 3, loader.MaxRedirects)
+	doTests(t, loader, []test{
+		{name: "no redirect", target: ts.URL + "/redirect/0", result: "ok"},
+		{name: "redirects within cap", target: ts.URL + "/redirect/3", result: "ok"},
+		{name: "redirects exceeding cap", target: ts.URL + "/redirect/4", err: "imagor: 502 too many redirects"},
+		{name: "redirect loop", target: ts.URL + "/redirect/1000", err: "imagor: 502 too many redirects"},
+		{name: "redirect to blocked host", target: ts.URL + "/blocked", err: "imagor: 403 redirect to source not allowed"},
+	})
+	assert.Equal(t, int32(0), atomic.LoadInt32(&blockedCnt), "blocked host not requested")
+
+	doTests(t, New(WithAllowedSources(host), WithMaxRedirects(0), WithMaxRedirects(-1)), []test{
+		{name: "no redirect allowed", target: ts.URL + "/redirect/0", result: "ok"},
+		{name: "redirect not followed", target: ts.URL + "/redirect/1", err: "imagor: 502 too many redirects"},
+	})
+	doTests(t, New(WithMaxRedirects(10)), []test{
+		{name: "redirects within configured cap", target: ts.URL + "/redirect/10", result: "ok"},
+		{name: "redirect to any host without allowed sources", target: ts.URL + "/blocked", result: "blocked"},
+	})
+	doTests(t, New(WithAllowedSources(host), WithMaxAllowedSize(1000)), []test{
+		{name: "head request redirect to blocked host", target: ts.URL + "/blocked", err: "imagor: 403 redirect to source not allowed"},
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&blockedCnt))
+}
+
+func TestMethod(t *testing.T) {
+	loader := New(
+		WithTransport(testTransport{"https://foo.bar/baz": "baz"}),
+		WithAllowedSources("foo.bar"),
+	)
+	for method, ok := range map[string]bool{
+		http.MethodGet:    true,
+		http.MethodHead:   true,
+		http.MethodPost:   false,
+		http.MethodDelete: false,
+	} {
+		r := httptest.NewRequest(method, "https://example.com/imagor", nil)
+		b, err := loader.Load(r, "https://foo.bar/baz")
+		if ok {
+			require.NoError(t, err, method)
+			buf, _ := b.ReadAll()
+			assert.Equal(t, "baz", string(buf), method)
+		} else {
+			assert.Equal(t, imagor.ErrPass, err, method)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type Option func(h *HTTPLoader)
@@ -38,6 +39,64 @@ func WithInsecureSkipVerifyTransport(enabled bool) Option {
 	}
 }
 
+func WithMaxIdleConns(n int) Option {
+	return func(h *HTTPLoader) {
+		if t, ok := h.Transport.(*http.Transport); ok && n > 0 {
+			t.MaxIdleConns = n
+		}
+	}
+}
+
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(h *HTTPLoader) {
+		if t, ok := h.Transport.(*http.Transport); ok && n > 0 {
+			t.MaxIdleConnsPerHost = n
+		}
+	}
+}
+
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(h *HTTPLoader) {
+		if t, ok := h.Transport.(*http.Transport); ok && timeout > 0 {
+			t.IdleConnTimeout = timeout
+		}
+	}
+}
+
+// WithKeepAlive interval of TCP keep-alive probes, or disables keep-alive connections if negative
+func WithKeepAlive(interval time.Duration) Option {
+	return func(h *HTTPLoader) {
+		if t, ok := h.Transport.(*http.Transport); ok && interval != 0 {
+			h.dialer.KeepAlive = interval
+			t.DisableKeepAlives = interval < 0
+		}
+	}
+}
+
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(h *HTTPLoader) {
+		if timeout > 0 {
+			h.dialer.Timeout = timeout
+		}
+	}
+}
+
+func WithTLSHandshakeTimeout(timeout time.Duration) Option {
+	return func(h *HTTPLoader) {
+		if t, ok := h.Transport.(*http.Transport); ok && timeout > 0 {
+			t.TLSHandshakeTimeout = timeout
+		}
+	}
+}
+
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+	return func(h *HTTPLoader) {
+		if t, ok := h.Transport.(*http.Transport); ok && timeout > 0 {
+			t.ResponseHeaderTimeout = timeout
+		}
+	}
+}
+
 func WithForwardHeaders(headers ...string) Option {
 	return func(h *HTTPLoader) {
 		for _, raw := range headers {
@@ -88,6 +147,15 @@ func WithMaxAllowedSize(maxAllowedSize int) Option {
 	}
 }
 
+// WithMaxRedirects maximum number of redirects to follow, not following redirect if 0. Skipped if negative
+func WithMaxRedirects(n int) Option {
+	return func(h *HTTPLoader) {
+		if n >= 0 {
+			h.MaxRedirects = n
+		}
+	}
+}
+
 func WithUserAgent(userAgent string) Option {
 	return func(h *HTTPLoader) {
 		if userAgent != "" {
@@ -0,0 +1,67 @@
+package imagor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Metrics outcomes
+const (
+	// OutcomeHit succeeded, e.g. image loaded or processed
+	OutcomeHit = "hit"
+	// OutcomeMiss image not found or passed by all loaders or processors
+	OutcomeMiss = "miss"
+	// OutcomeError failed by any other errors
+	OutcomeError = "error"
+)
+
+// Metrics collects latencies of load, process and save by outcome,
+// and errors responded by type
+type Metrics interface {
+	ObserveLoad(d time.Duration, outcome string)
+	ObserveProcess(d time.Duration, outcome string)
+	ObserveSave(d time.Duration, outcome string)
+	CountError(err Error)
+}
+
+func outcome(err error) string {
+	if err == nil {
+		return OutcomeHit
+	}
+	if e, ok := WrapError(err).(Error); ok && (e == ErrPass || e.Code == http.StatusNotFound) {
+		return OutcomeMiss
+	}
+	return OutcomeError
+}
+
+func (app *Imagor) observeLoad(start time.Time, err error) {
+	if app.Metrics != nil {
+		app.Metrics.ObserveLoad(time.Since(start), outcome(err))
+	}
+}
+
+func (app *Imagor) observeProcess(start time.Time, err error) {
+	if app.Metrics != nil {
+		app.Metrics.ObserveProcess(time.Since(start), outcome(err))
+	}
+}
+
+func (app *Imagor) observeSave(start time.Time, err error) {
+	if app.Metrics != nil {
+		app.Metrics.ObserveSave(time.Since(start), outcome(err))
+	}
+}
+
+func (app *Imagor) countError(err error) {
+	if app.Metrics == nil || err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	if e, ok := WrapError(err).(Error); ok {
+		// counted as responded, by ErrorStatusCodes if overridden
+		app.Metrics.CountError(app.responseError(e))
+	} else {
+		app.Metrics.CountError(ErrInternal)
+	}
+}
@@ -0,0 +1,35 @@
+package prometheusmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+)
+
+type Option func(m *PrometheusMetrics)
+
+func WithNamespace(namespace string) Option {
+	return func(m *PrometheusMetrics) {
+		if namespace != "" {
+			m.Namespace = namespace
+		}
+	}
+}
+
+func WithBuckets(buckets ...float64) Option {
+	return func(m *PrometheusMetrics) {
+		if len(buckets) > 0 {
+			m.Buckets = append([]float64{}, buckets...)
+			sort.Float64s(m.Buckets)
+		}
+	}
+}
+
+// WithRegistry registers collectors to registry instead of a new one,
+// which may be shared with collectors of the application
+func WithRegistry(registry *prometheus.Registry) Option {
+	return func(m *PrometheusMetrics) {
+		if registry != nil {
+			m.Registry = registry
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package prometheusmetrics
+
+import (
+	"github.com/cshum/imagor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultBuckets histogram buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics collects imagor metrics as Prometheus histograms and counters
+// of its own registry, exposed in Prometheus text format
+type PrometheusMetrics struct {
+	Namespace string
+	Buckets   []float64
+	Registry  *prometheus.Registry
+
+	load    *prometheus.HistogramVec
+	process *prometheus.HistogramVec
+	save    *prometheus.HistogramVec
+	errors  *prometheus.CounterVec
+	handler http.Handler
+}
+
+// New creates PrometheusMetrics
+func New(options ...Option) *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		Namespace: "imagor",
+		Buckets:   DefaultBuckets,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	if m.Registry == nil {
+		m.Registry = prometheus.NewRegistry()
+	}
+	histogram := func(name, help string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: m.Namespace,
+			Name:      name,
+			Help:      help,
+			Buckets:   m.Buckets,
+		}, []string{"outcome"})
+	}
+	m.load = histogram("load_duration_seconds", "Duration of image load in seconds by outcome")
+	m.process = histogram("process_duration_seconds", "Duration of image process in seconds by outcome")
+	m.save = histogram("save_duration_seconds", "Duration of image save in seconds by outcome")
+	m.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.Namespace,
+		Name:      "errors_total",
+		Help:      "Number of errors responded by status code",
+	}, []string{"code"})
+	m.Registry.MustRegister(m.load, m.process, m.save, m.errors)
+	m.handler = promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// ObserveLoad implements imagor.Metrics
+func (m *PrometheusMetrics) ObserveLoad(d time.Duration, outcome string) {
+	m.load.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// ObserveProcess implements imagor.Metrics
+func (m *PrometheusMetrics) ObserveProcess(d time.Duration, outcome string) {
+	m.process.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// ObserveSave implements imagor.Metrics
+func (m *PrometheusMetrics) ObserveSave(d time.Duration, outcome string) {
+	m.save.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// CountError implements imagor.Metrics, counted by status code for bounded cardinality
+func (m *PrometheusMetrics) CountError(err imagor.Error) {
+	m.errors.WithLabelValues(strconv.Itoa(err.Code)).Inc()
+}
+
+// ServeHTTP responds metrics of the registry in Prometheus text format
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+// Middleware serves metrics at path of GET request, otherwise handled by next handler
+func (m *PrometheusMetrics) Middleware(path string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && r.URL.Path == path {
+				m.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
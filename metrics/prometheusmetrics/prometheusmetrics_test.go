@@ -0,0 +1,85 @@
+package prometheusmetrics
+
+import (
+	"github.com/cshum/imagor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := New(WithNamespace("test"), WithBuckets(1, 0.1))
+	var _ imagor.Metrics = m
+	m.ObserveLoad(time.Millisecond*50, imagor.OutcomeHit)
+	m.ObserveLoad(time.Millisecond*500, imagor.OutcomeHit)
+	m.ObserveLoad(time.Second*2, imagor.OutcomeMiss)
+	m.ObserveProcess(time.Millisecond*200, imagor.OutcomeError)
+	m.CountError(imagor.ErrNotFound)
+	m.CountError(imagor.ErrNotFound)
+	m.CountError(imagor.ErrTimeout)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP test_errors_total Number of errors responded by status code
+# TYPE test_errors_total counter
+test_errors_total{code="404"} 2
+test_errors_total{code="408"} 1
+# HELP test_load_duration_seconds Duration of image load in seconds by outcome
+# TYPE test_load_duration_seconds histogram
+test_load_duration_seconds_bucket{outcome="hit",le="0.1"} 1
+test_load_duration_seconds_bucket{outcome="hit",le="1"} 2
+test_load_duration_seconds_bucket{outcome="hit",le="+Inf"} 2
+test_load_duration_seconds_sum{outcome="hit"} 0.55
+test_load_duration_seconds_count{outcome="hit"} 2
+test_load_duration_seconds_bucket{outcome="miss",le="0.1"} 0
+test_load_duration_seconds_bucket{outcome="miss",le="1"} 0
+test_load_duration_seconds_bucket{outcome="miss",le="+Inf"} 1
+test_load_duration_seconds_sum{outcome="miss"} 2
+test_load_duration_seconds_count{outcome="miss"} 1
+# HELP test_process_duration_seconds Duration of image process in seconds by outcome
+# TYPE test_process_duration_seconds histogram
+test_process_duration_seconds_bucket{outcome="error",le="0.1"} 0
+test_process_duration_seconds_bucket{outcome="error",le="1"} 1
+test_process_duration_seconds_bucket{outcome="error",le="+Inf"} 1
+test_process_duration_seconds_sum{outcome="error"} 0.2
+test_process_duration_seconds_count{outcome="error"} 1
+`, w.Body.String())
+}
+
+func TestWithRegistry(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_total", Help: "Application counter"})
+	registry.MustRegister(counter)
+	counter.Inc()
+	m := New(WithRegistry(registry))
+	assert.Equal(t, registry, m.Registry)
+	m.ObserveSave(time.Millisecond, imagor.OutcomeHit)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), "app_total 1")
+	assert.Contains(t, w.Body.String(), `imagor_save_duration_seconds_count{outcome="hit"} 1`)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	m.ObserveLoad(time.Millisecond, imagor.OutcomeHit)
+	handler := m.Middleware("/metrics")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("next"))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), "# TYPE imagor_load_duration_seconds histogram")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unsafe/foo.jpg", nil))
+	assert.Equal(t, "next", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, "next", w.Body.String())
+}
@@ -1,7 +1,9 @@
 package imagor
 
 import (
+	"github.com/cshum/imagor/imagorpath"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
@@ -45,6 +47,15 @@ func WithProcessors(processors ...Processor) Option {
 	}
 }
 
+func WithProcessorsMode(mode string) Option {
+	return func(o *Imagor) {
+		switch mode {
+		case ProcessorsFallback, ProcessorsPipeline:
+			o.ProcessorsMode = mode
+		}
+	}
+}
+
 func WithRequestTimeout(timeout time.Duration) Option {
 	return func(o *Imagor) {
 		if timeout > 0 {
@@ -87,18 +98,237 @@ func WithProcessTimeout(timeout time.Duration) Option {
 	}
 }
 
+func WithProcessConcurrency(concurrency int) Option {
+	return func(o *Imagor) {
+		if concurrency > 0 {
+			o.ProcessConcurrency = concurrency
+		}
+	}
+}
+
+func WithTrustPriorityHeader(enabled bool) Option {
+	return func(o *Imagor) {
+		o.TrustPriorityHeader = enabled
+	}
+}
+
+func WithMaxConcurrentProcessing(max int) Option {
+	return func(o *Imagor) {
+		if max > 0 {
+			o.MaxConcurrentProcessing = max
+		}
+	}
+}
+
+func WithFallbackResolver(resolver FallbackResolver) Option {
+	return func(o *Imagor) {
+		o.FallbackResolver = resolver
+	}
+}
+
+// WithFallbackImages fallback images by csv of prefix=image for fallback per prefix,
+// or image without prefix as default. Longest matching prefix applies
+func WithFallbackImages(images string) Option {
+	return func(o *Imagor) {
+		var def string
+		var prefixes, fallbacks []string
+		for _, s := range strings.Split(images, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if kv := strings.SplitN(s, "=", 2); len(kv) == 2 {
+				prefixes = append(prefixes, kv[0])
+				fallbacks = append(fallbacks, kv[1])
+			} else {
+				def = s
+			}
+		}
+		if def == "" && len(prefixes) == 0 {
+			return
+		}
+		o.FallbackResolver = func(image string) string {
+			var matched, fallback = -1, def
+			for i, prefix := range prefixes {
+				if strings.HasPrefix(image, prefix) && len(prefix) > matched {
+					matched = len(prefix)
+					fallback = fallbacks[i]
+				}
+			}
+			return fallback
+		}
+	}
+}
+
 func WithUnsafe(unsafe bool) Option {
 	return func(o *Imagor) {
 		o.Unsafe = unsafe
 	}
 }
 
+// WithUnsafePrefixes restricts unsafe requests to images of the prefixes,
+// as csv of image path prefixes or source hosts e.g. public/,raw.githubusercontent.com/
+func WithUnsafePrefixes(prefixes ...string) Option {
+	return func(o *Imagor) {
+		for _, csv := range prefixes {
+			for _, prefix := range strings.Split(csv, ",") {
+				if prefix = strings.TrimSpace(prefix); prefix != "" {
+					o.UnsafePrefixes = append(o.UnsafePrefixes, prefix)
+				}
+			}
+		}
+	}
+}
+
 func WithSecret(secret string) Option {
 	return func(o *Imagor) {
 		o.Secret = secret
 	}
 }
 
+// WithSigner custom signer for URL signature in place of HMAC-SHA1 of secret
+func WithSigner(signer imagorpath.Signer) Option {
+	return func(o *Imagor) {
+		if signer != nil {
+			o.Signer = signer
+		}
+	}
+}
+
+// WithSignerFactory creates signer of secret for both secret and scoped secrets,
+// in place of HMAC-SHA1
+func WithSignerFactory(factory func(secret string) imagorpath.Signer) Option {
+	return func(o *Imagor) {
+		if factory != nil {
+			o.SignerFactory = factory
+		}
+	}
+}
+
+// WithScopedSecret secret key signing only the whitelisted transformations,
+// transformations separated by whitespace as filter arguments may contain comma
+func WithScopedSecret(secret string, transforms ...string) Option {
+	return func(o *Imagor) {
+		if secret != "" {
+			s := ScopedSecret{Secret: secret}
+			for _, t := range transforms {
+				s.Transforms = append(s.Transforms, strings.Fields(t)...)
+			}
+			o.ScopedSecrets = append(o.ScopedSecrets, s)
+		}
+	}
+}
+
+func WithPrettyJSON(pretty bool) Option {
+	return func(o *Imagor) {
+		o.PrettyJSON = pretty
+	}
+}
+
+func WithDiagnosticHeaders(enabled bool) Option {
+	return func(o *Imagor) {
+		o.DiagnosticHeaders = enabled
+	}
+}
+
+func WithPurgeEndpoint(enabled bool) Option {
+	return func(o *Imagor) {
+		o.PurgeEndpoint = enabled
+	}
+}
+
+func WithDisableParamsEndpoint(disabled bool) Option {
+	return func(o *Imagor) {
+		o.DisableParamsEndpoint = disabled
+	}
+}
+
+func WithBatchEndpoint(enabled bool) Option {
+	return func(o *Imagor) {
+		o.BatchEndpoint = enabled
+	}
+}
+
+func WithBatchMaxPaths(n int) Option {
+	return func(o *Imagor) {
+		if n > 0 {
+			o.BatchMaxPaths = n
+		}
+	}
+}
+
+// WithWarmPaths request paths separated by whitespace,
+// as paths may contain comma in filter arguments
+func WithWarmPaths(paths ...string) Option {
+	return func(o *Imagor) {
+		for _, path := range paths {
+			o.WarmPaths = append(o.WarmPaths, strings.Fields(path)...)
+		}
+	}
+}
+
+// WithErrorImage placeholder image responded on error in place of JSON
+func WithErrorImage(blob *Blob) Option {
+	return func(o *Imagor) {
+		o.ErrorImage = blob
+	}
+}
+
+// WithErrorImageStatusCode response status code of error image, skipped if code not positive
+func WithErrorImageStatusCode(code int) Option {
+	return func(o *Imagor) {
+		if code > 0 {
+			o.ErrorImageStatusCode = code
+		}
+	}
+}
+
+// WithErrorStatusCode overrides response status code of error, skipped if code not positive
+func WithErrorStatusCode(err Error, code int) Option {
+	return func(o *Imagor) {
+		if code > 0 {
+			if o.ErrorStatusCodes == nil {
+				o.ErrorStatusCodes = map[Error]int{}
+			}
+			o.ErrorStatusCodes[err] = code
+		}
+	}
+}
+
+func WithMetrics(metrics Metrics) Option {
+	return func(o *Imagor) {
+		o.Metrics = metrics
+	}
+}
+
+func WithTracer(tracer Tracer) Option {
+	return func(o *Imagor) {
+		if tracer != nil {
+			o.Tracer = tracer
+		}
+	}
+}
+
+func WithAutoFormat(enabled bool) Option {
+	return func(o *Imagor) {
+		o.AutoFormat = enabled
+	}
+}
+
+func WithSpeculativeLoad(enabled bool) Option {
+	return func(o *Imagor) {
+		o.SpeculativeLoad = enabled
+	}
+}
+
+func WithErrorLogSampling(n int) Option {
+	return func(o *Imagor) {
+		if n > 0 {
+			o.ErrorLogSampling = n
+		}
+	}
+}
+
 func WithDebug(debug bool) Option {
 	return func(o *Imagor) {
 		o.Debug = debug
@@ -0,0 +1,160 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// animateMaxFrames caps the number of frames synthesized by animate
+const animateMaxFrames = 100
+
+// renderFrames truncates the animation to the frames estimated to render before ctx deadline,
+// by render time of the first frame, such that the rest are rendered once only by export
+func renderFrames(ctx context.Context, img *vips.ImageRef) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ph := img.PageHeight()
+	n := img.Height() / ph
+	frame, err := img.Copy()
+	if err != nil {
+		return err
+	}
+	AddImageRef(ctx, frame)
+	if err = extractFrames(frame, 0, 1, ph); err != nil {
+		return err
+	}
+	start := time.Now()
+	if _, err = frame.ToBytes(); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	remaining := time.Until(deadline)
+	if elapsed <= 0 || remaining >= elapsed*time.Duration(n) {
+		return nil
+	}
+	// at least the first frame already rendered
+	fit := 1
+	if remaining > elapsed {
+		fit = int(remaining / elapsed)
+	}
+	return extractFrames(img, 0, fit, ph)
+}
+
+// flipVertical flips each frame vertically, keeping the order of animation frames
+func flipVertical(ctx context.Context, img *vips.ImageRef) error {
+	ph := img.PageHeight()
+	n := img.Height() / ph
+	if n <= 1 {
+		return img.Flip(vips.DirectionVertical)
+	}
+	frames := make([]*vips.ImageRef, 0, n)
+	for i := 0; i < n; i++ {
+		frame, err := img.Copy()
+		if err != nil {
+			return err
+		}
+		AddImageRef(ctx, frame)
+		if err = extractFrames(frame, i, 1, ph); err != nil {
+			return err
+		}
+		if err = frame.Flip(vips.DirectionVertical); err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+	}
+	if err := frames[0].ArrayJoin(frames[1:], 1); err != nil {
+		return err
+	}
+	return img.Insert(frames[0], 0, 0, false, nil)
+}
+
+// extractFrames extracts n frames of animation starting from frame i
+func extractFrames(img *vips.ImageRef, i, n, pageHeight int) (err error) {
+	// treat as single page for extracting across frames
+	if err = img.SetPageHeight(img.Height()); err != nil {
+		return
+	}
+	if err = img.ExtractArea(0, i*pageHeight, img.Width(), n*pageHeight); err != nil {
+		return
+	}
+	return img.SetPageHeight(pageHeight)
+}
+
+// animate synthesizes animation of still image by applying filter to each frame,
+// with the filter argument swept linearly from start to end value e.g. blur,0,20,10.
+// Arguments after the frame count are passed through to the filter
+func (v *VipsProcessor) animate(
+	ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args string,
+) error {
+	parts := strings.Split(args, ",")
+	if len(parts) < 4 {
+		return nil
+	}
+	fn := v.Filters[strings.TrimSpace(parts[0])]
+	if fn == nil {
+		return nil
+	}
+	start, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return nil
+	}
+	end, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return nil
+	}
+	n, _ := strconv.Atoi(parts[3])
+	if n > animateMaxFrames {
+		n = animateMaxFrames
+	}
+	if v.MaxAnimationFrames > 0 && n > v.MaxAnimationFrames {
+		n = v.MaxAnimationFrames
+	}
+	if n < 2 {
+		return nil
+	}
+	ph := img.PageHeight()
+	if n*img.Width()*ph > v.MaxWidth*v.MaxHeight {
+		// frames joined within the pixel budget of max width and height
+		return imagor.ErrMaxSizeExceeded
+	}
+	// integer sweep for filters of integer arguments
+	integer := start == math.Trunc(start) && end == math.Trunc(end)
+	frames := make([]*vips.ImageRef, 0, n)
+	for i := 0; i < n; i++ {
+		frame, err := img.Copy()
+		if err != nil {
+			return err
+		}
+		AddImageRef(ctx, frame)
+		value := start + (end-start)*float64(i)/float64(n-1)
+		arg := strconv.FormatFloat(value, 'f', 2, 64)
+		if integer {
+			arg = strconv.Itoa(int(math.Round(value)))
+		}
+		if err = fn(ctx, frame, load, append([]string{arg}, parts[4:]...)...); err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+	}
+	if err := frames[0].ArrayJoin(frames[1:], 1); err != nil {
+		return err
+	}
+	if err := img.Insert(frames[0], 0, 0, true, nil); err != nil {
+		return err
+	}
+	if err := img.SetPageHeight(ph); err != nil {
+		return err
+	}
+	SetPageN(ctx, n)
+	return nil
+}
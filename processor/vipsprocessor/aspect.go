@@ -0,0 +1,30 @@
+package vipsprocessor
+
+import "math"
+
+const (
+	orientationPortrait  = "portrait"
+	orientationLandscape = "landscape"
+	orientationSquare    = "square"
+)
+
+// aspectRatio source dimensions for layout pre-computation, as displayed after EXIF orientation
+type aspectRatio struct {
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	AspectRatio float64 `json:"aspect_ratio"`
+	Orientation string  `json:"orientation"`
+}
+
+func newAspectRatio(w, h int) *aspectRatio {
+	a := &aspectRatio{Width: w, Height: h, Orientation: orientationSquare}
+	if h > 0 {
+		a.AspectRatio = math.Round(float64(w)/float64(h)*10000) / 10000
+	}
+	if w > h {
+		a.Orientation = orientationLandscape
+	} else if w < h {
+		a.Orientation = orientationPortrait
+	}
+	return a
+}
@@ -0,0 +1,91 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+const (
+	autoFormatProbeSize = 200
+	// flat graphic if the most frequent colors cover this ratio of pixels
+	flatGraphicColors = 32
+	flatGraphicRatio  = 0.9
+)
+
+// supportsAlpha whether export format keeps alpha channel
+func supportsAlpha(format vips.ImageType) bool {
+	switch format {
+	case vips.ImageTypeJPEG, vips.ImageTypeBMP:
+		return false
+	}
+	return true
+}
+
+// autoFormat picks output format by image content for format(auto):
+// WebP for animation and photo with alpha, PNG for flat graphic, JPEG for opaque photo
+func autoFormat(ctx context.Context, img *vips.ImageRef) (vips.ImageType, error) {
+	if img.Height() > img.PageHeight() {
+		return vips.ImageTypeWEBP, nil
+	}
+	// sample on downsized copy, nearest neighbour so that no new colors introduced
+	probe, err := img.Copy()
+	if err != nil {
+		return vips.ImageTypeUnknown, err
+	}
+	AddImageRef(ctx, probe)
+	if scale := autoFormatProbeSize / math.Max(float64(img.Width()), float64(img.PageHeight())); scale < 1 {
+		if err = probe.Resize(scale, vips.KernelNearest); err != nil {
+			return vips.ImageTypeUnknown, err
+		}
+	}
+	m, err := toNRGBA(probe)
+	if err != nil {
+		return vips.ImageTypeUnknown, err
+	}
+	switch {
+	case isFlatGraphic(m):
+		return vips.ImageTypePNG, nil
+	case hasTransparency(m):
+		return vips.ImageTypeWEBP, nil
+	default:
+		return vips.ImageTypeJPEG, nil
+	}
+}
+
+// isFlatGraphic checks if image consists of few dominant colors e.g. logo, icon or chart
+func isFlatGraphic(m *image.NRGBA) bool {
+	counts := map[color.NRGBA]int{}
+	for i := 0; i+3 < len(m.Pix); i += 4 {
+		c := color.NRGBA{R: m.Pix[i], G: m.Pix[i+1], B: m.Pix[i+2], A: m.Pix[i+3]}
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		counts[c]++
+	}
+	if len(counts) <= flatGraphicColors {
+		return true
+	}
+	ns := make([]int, 0, len(counts))
+	for _, n := range counts {
+		ns = append(ns, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ns)))
+	var top int
+	for _, n := range ns[:flatGraphicColors] {
+		top += n
+	}
+	return float64(top) >= flatGraphicRatio*float64(len(m.Pix)/4)
+}
+
+func hasTransparency(m *image.NRGBA) bool {
+	for i := 3; i < len(m.Pix); i += 4 {
+		if m.Pix[i] < 0xff {
+			return true
+		}
+	}
+	return false
+}
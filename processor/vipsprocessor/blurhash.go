@@ -0,0 +1,145 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	blurHashProbeSize   = 32
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// parseBlurHash parses blurhash([x, y]) filter args of component counts, each clamped 1 to 9
+func parseBlurHash(args string) (x, y int) {
+	x, y = blurHashComponentsX, blurHashComponentsY
+	arr := strings.Split(args, ",")
+	if n, err := strconv.Atoi(arr[0]); err == nil {
+		x = clampInt(n, 1, 9)
+	}
+	if len(arr) > 1 {
+		if n, err := strconv.Atoi(arr[1]); err == nil {
+			y = clampInt(n, 1, 9)
+		}
+	}
+	return
+}
+
+// blurHash BlurHash of the first frame, computed from downscaled image to keep it cheap
+func blurHash(ctx context.Context, img *vips.ImageRef, x, y int) (string, error) {
+	w, h := img.Width(), img.PageHeight()
+	probe, err := img.Copy()
+	if err != nil {
+		return "", err
+	}
+	AddImageRef(ctx, probe)
+	if h < probe.Height() {
+		// first frame of animation
+		if err = probe.ExtractArea(0, 0, w, h); err != nil {
+			return "", err
+		}
+	}
+	if scale := blurHashProbeSize / math.Max(float64(w), float64(h)); scale < 1 {
+		if err = probe.Resize(scale, vips.KernelLinear); err != nil {
+			return "", err
+		}
+	}
+	m, err := toNRGBA(probe)
+	if err != nil {
+		return "", err
+	}
+	return encodeBlurHash(m, x, y), nil
+}
+
+// encodeBlurHash encodes image into BlurHash of x by y components,
+// see https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func encodeBlurHash(m *image.NRGBA, x, y int) string {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	factors := make([][3]float64, 0, x*y)
+	for j := 0; j < y; j++ {
+		for i := 0; i < x; i++ {
+			var f [3]float64
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			for py := 0; py < h; py++ {
+				for px := 0; px < w; px++ {
+					c := m.NRGBAAt(b.Min.X+px, b.Min.Y+py)
+					basis := norm *
+						math.Cos(math.Pi*float64(i)*float64(px)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(py)/float64(h))
+					f[0] += basis * srgbToLinear(c.R)
+					f[1] += basis * srgbToLinear(c.G)
+					f[2] += basis * srgbToLinear(c.B)
+				}
+			}
+			scale := 1 / float64(w*h)
+			f[0], f[1], f[2] = f[0]*scale, f[1]*scale, f[2]*scale
+			factors = append(factors, f)
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(encode83((x-1)+(y-1)*9, 1))
+	maxValue := 1.0
+	if ac := factors[1:]; len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		sb.WriteString(encode83(quantisedMax, 1))
+	} else {
+		sb.WriteString(encode83(0, 1))
+	}
+	dc := factors[0]
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range factors[1:] {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+	return sb.String()
+}
+
+func encode83(value, length int) string {
+	buf := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(buf)
+}
+
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,40 @@
+package vipsprocessor
+
+import (
+	"fmt"
+	"github.com/cshum/imagor"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// canvas generates a solid color SVG source from canvas(width,height[,color]) args,
+// for requests without a source image
+func (v *VipsProcessor) canvas(args string) (*imagor.Blob, error) {
+	parts := strings.Split(args, ",")
+	if len(parts) < 2 {
+		return nil, imagor.NewError("canvas: width and height required", http.StatusBadRequest)
+	}
+	w, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	h, _ := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if w <= 0 || h <= 0 || w > v.MaxWidth || h > v.MaxHeight {
+		return nil, imagor.NewError(
+			fmt.Sprintf("canvas: invalid size %dx%d", w, h), http.StatusBadRequest)
+	}
+	fill := "#ffffff"
+	opacity := 1
+	if len(parts) > 2 {
+		switch name := strings.ToLower(strings.TrimSpace(parts[2])); name {
+		case "none", "transparent":
+			opacity = 0
+		default:
+			c := getColor(nil, name)
+			fill = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		}
+	}
+	return imagor.NewBlobBytes([]byte(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+			`<rect x="0" y="0" width="%d" height="%d" fill="%s" fill-opacity="%d"/>`+
+			`</svg>`,
+		w, h, w, h, w, h, fill, opacity))), nil
+}
@@ -0,0 +1,99 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"strconv"
+	"strings"
+)
+
+const collageMaxGrid = 10
+
+// collage arranges crop regions of the image into grid,
+// collage(layout, spacing, x1, y1, w1, h1[, x2, y2, w2, h2...]) e.g. collage(2x2,10,...).
+// Panels are of the first region dimensions, rest of the regions are cropped to fill.
+// Spacing and empty panels are transparent for image with alpha, otherwise white
+func (v *VipsProcessor) collage(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if IsAnimated(ctx) || len(args) < 6 {
+		// skip animation support
+		return
+	}
+	cols, rows := 1, 1
+	if layout := strings.Split(strings.ToLower(args[0]), "x"); len(layout) == 2 {
+		cols, _ = strconv.Atoi(layout[0])
+		rows, _ = strconv.Atoi(layout[1])
+	}
+	cols, rows = clampInt(cols, 1, collageMaxGrid), clampInt(rows, 1, collageMaxGrid)
+	spacing, _ := strconv.Atoi(args[1])
+	var panels []*vips.ImageRef
+	var cw, ch int
+	for i := 2; i+3 < len(args) && len(panels) < cols*rows; i += 4 {
+		var x, y, w, h int
+		x, _ = strconv.Atoi(args[i])
+		y, _ = strconv.Atoi(args[i+1])
+		w, _ = strconv.Atoi(args[i+2])
+		h, _ = strconv.Atoi(args[i+3])
+		// clamp region within image
+		x = clampInt(x, 0, img.Width())
+		y = clampInt(y, 0, img.Height())
+		w = clampInt(w, 0, img.Width()-x)
+		h = clampInt(h, 0, img.Height()-y)
+		if w == 0 || h == 0 {
+			continue
+		}
+		if len(panels) == 0 {
+			cw, ch = w, h
+		} else if w*ch > h*cw {
+			// centre crop to panel aspect ratio
+			nw := clampInt(h*cw/ch, 1, w)
+			x, w = x+(w-nw)/2, nw
+		} else if w*ch < h*cw {
+			nh := clampInt(w*ch/cw, 1, h)
+			y, h = y+(h-nh)/2, nh
+		}
+		var panel *vips.ImageRef
+		if panel, err = img.Copy(); err != nil {
+			return
+		}
+		AddImageRef(ctx, panel)
+		if err = panel.ExtractArea(x, y, w, h); err != nil {
+			return
+		}
+		if w != cw || h != ch {
+			if err = panel.ResizeWithVScale(
+				float64(cw)/float64(w), float64(ch)/float64(h), vips.KernelAuto,
+			); err != nil {
+				return
+			}
+		}
+		panels = append(panels, panel)
+	}
+	if len(panels) == 0 {
+		return
+	}
+	spacing = clampInt(spacing, 0, cw+ch)
+	width := cols*cw + (cols-1)*spacing
+	height := rows*ch + (rows-1)*spacing
+	if width > v.MaxWidth || height > v.MaxHeight {
+		return imagor.ErrMaxSizeExceeded
+	}
+	if err = img.ExtractArea(0, 0, cw, ch); err != nil {
+		return
+	}
+	if img.HasAlpha() {
+		err = img.EmbedBackgroundRGBA(0, 0, width, height, &vips.ColorRGBA{})
+	} else {
+		err = img.EmbedBackground(0, 0, width, height, &vips.Color{R: 0xff, G: 0xff, B: 0xff})
+	}
+	if err != nil {
+		return
+	}
+	for i, panel := range panels {
+		x, y := i%cols*(cw+spacing), i/cols*(ch+spacing)
+		if err = img.Insert(panel, x, y, false, nil); err != nil {
+			return
+		}
+	}
+	return
+}
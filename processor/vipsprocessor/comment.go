@@ -0,0 +1,169 @@
+package vipsprocessor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"html"
+	"unicode/utf8"
+)
+
+const (
+	jpegMaxComment = 0xFFFF - 2
+	pngCommentKey  = "Comment"
+	webpFlagXMP    = 0x04
+	webpFlagAlpha  = 0x10
+)
+
+// addComment embeds text comment into exported image of
+// JPEG COM marker, PNG tEXt or iTXt chunk, or WebP XMP chunk.
+// Other formats returned untouched
+func addComment(buf []byte, comment string) []byte {
+	switch {
+	case len(buf) > 4 && buf[0] == 0xFF && buf[1] == 0xD8:
+		return jpegComment(buf, comment)
+	case bytes.HasPrefix(buf, pngHeader):
+		return pngComment(buf, comment)
+	case len(buf) > 12 && bytes.Equal(buf[8:12], webpHeader):
+		return webpComment(buf, comment)
+	}
+	return buf
+}
+
+// jpegComment inserts COM segment after the APPn segments, such that JFIF and EXIF remain leading
+func jpegComment(buf []byte, comment string) []byte {
+	if len(comment) > jpegMaxComment {
+		comment = comment[:jpegMaxComment]
+	}
+	i := 2
+	for i+4 <= len(buf) && buf[i] == 0xFF && buf[i+1] >= 0xE0 && buf[i+1] <= 0xEF {
+		end := i + 2 + (int(buf[i+2])<<8 | int(buf[i+3]))
+		if end > len(buf) {
+			return buf
+		}
+		i = end
+	}
+	seg := make([]byte, 4, 4+len(comment))
+	seg[0], seg[1] = 0xFF, 0xFE
+	binary.BigEndian.PutUint16(seg[2:], uint16(2+len(comment)))
+	seg = append(seg, comment...)
+	out := make([]byte, 0, len(buf)+len(seg))
+	out = append(out, buf[:i]...)
+	out = append(out, seg...)
+	return append(out, buf[i:]...)
+}
+
+// pngComment inserts Comment chunk after IHDR, tEXt if Latin-1 compatible ASCII otherwise iTXt of UTF-8
+func pngComment(buf []byte, comment string) []byte {
+	// signature, IHDR length, type, 13 bytes data and crc
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(buf) < ihdrEnd || string(buf[12:16]) != "IHDR" {
+		return buf
+	}
+	typ, data := "tEXt", []byte(pngCommentKey+"\x00"+comment)
+	if !isASCII(comment) {
+		// keyword, null, compression flag and method, empty language tag and translated keyword
+		typ, data = "iTXt", []byte(pngCommentKey+"\x00\x00\x00\x00\x00"+comment)
+	}
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+	out := make([]byte, 0, len(buf)+len(chunk))
+	out = append(out, buf[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, buf[ihdrEnd:]...)
+}
+
+// webpComment appends XMP chunk of dc:description, converting simple format to extended VP8X if needed
+func webpComment(buf []byte, comment string) []byte {
+	if len(buf) < 20 {
+		return buf
+	}
+	out := append([]byte{}, buf[:12]...)
+	switch string(buf[12:16]) {
+	case "VP8X":
+		if len(buf) < 30 {
+			return buf
+		}
+		if buf[20]&webpFlagXMP != 0 {
+			// existing XMP left untouched
+			return buf
+		}
+		out = append(out, buf[12:]...)
+		out[20] |= webpFlagXMP
+	case "VP8 ", "VP8L":
+		w, h, alpha, ok := webpSize(buf[12:])
+		if !ok {
+			return buf
+		}
+		vp8x := make([]byte, 18)
+		copy(vp8x, "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = webpFlagXMP
+		if alpha {
+			vp8x[8] |= webpFlagAlpha
+		}
+		putUint24(vp8x[12:], w-1)
+		putUint24(vp8x[15:], h-1)
+		out = append(out, vp8x...)
+		out = append(out, buf[12:]...)
+	default:
+		return buf
+	}
+	xmp := []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<dc:description><rdf:Alt><rdf:li xml:lang="x-default">` + html.EscapeString(comment) +
+		`</rdf:li></rdf:Alt></dc:description></rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+	chunk := make([]byte, 8, 8+len(xmp)+1)
+	copy(chunk, "XMP ")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(xmp)))
+	chunk = append(chunk, xmp...)
+	if len(xmp)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	out = append(out, chunk...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// webpSize canvas dimensions and alpha of simple format WebP VP8 or VP8L chunk
+func webpSize(chunk []byte) (w, h int, alpha, ok bool) {
+	switch string(chunk[:4]) {
+	case "VP8 ":
+		// frame tag, start code 9d 01 2a, then 14 bits width and height
+		if len(chunk) < 18 || !bytes.Equal(chunk[11:14], []byte{0x9d, 0x01, 0x2a}) {
+			return
+		}
+		w = int(binary.LittleEndian.Uint16(chunk[14:]) & 0x3fff)
+		h = int(binary.LittleEndian.Uint16(chunk[16:]) & 0x3fff)
+		return w, h, false, w > 0 && h > 0
+	case "VP8L":
+		// signature 0x2f, then 14 bits width-1, 14 bits height-1, 1 bit alpha
+		if len(chunk) < 13 || chunk[8] != 0x2f {
+			return
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:])
+		w = int(bits&0x3fff) + 1
+		h = int(bits>>14&0x3fff) + 1
+		return w, h, bits>>28&1 == 1, true
+	}
+	return
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
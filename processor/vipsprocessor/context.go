@@ -8,8 +8,10 @@ import (
 type imageRefKey struct{}
 
 type imageRefs struct {
-	imageRefs []*vips.ImageRef
-	PageN     int
+	imageRefs    []*vips.ImageRef
+	PageN        int
+	SourceWidth  int
+	SourceHeight int
 }
 
 func (r *imageRefs) Add(img *vips.ImageRef) {
@@ -55,6 +57,22 @@ func GetPageN(ctx context.Context) int {
 	return 1
 }
 
+// SetSourceSize sets dimensions of the source image before processing
+func SetSourceSize(ctx context.Context, width, height int) {
+	if r, ok := ctx.Value(imageRefKey{}).(*imageRefs); ok {
+		r.SourceWidth = width
+		r.SourceHeight = height
+	}
+}
+
+// GetSourceSize gets dimensions of the source image before processing
+func GetSourceSize(ctx context.Context) (width, height int) {
+	if r, ok := ctx.Value(imageRefKey{}).(*imageRefs); ok {
+		return r.SourceWidth, r.SourceHeight
+	}
+	return
+}
+
 func IsAnimated(ctx context.Context) bool {
 	return GetPageN(ctx) > 1
 }
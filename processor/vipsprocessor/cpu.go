@@ -0,0 +1,66 @@
+package vipsprocessor
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot mount point of cgroup filesystem
+var cgroupRoot = "/sys/fs/cgroup"
+
+// availableCPU number of CPU available to the process,
+// capped by GOMAXPROCS and cgroup CPU quota of the container if any
+func availableCPU() int {
+	n := runtime.NumCPU()
+	if p := runtime.GOMAXPROCS(0); p < n {
+		n = p
+	}
+	if quota, ok := cgroupCPUQuota(cgroupRoot); ok {
+		if q := int(math.Ceil(quota)); q < n {
+			n = q
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// cgroupCPUQuota CPU quota in number of cores from cgroup v2 cpu.max,
+// or cgroup v1 cpu.cfs_quota_us and cpu.cfs_period_us. Not ok if unlimited
+func cgroupCPUQuota(root string) (float64, bool) {
+	if buf, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		// cgroup v2 "$MAX $PERIOD", or "max $PERIOD" if unlimited
+		fields := strings.Fields(string(buf))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return parseQuota(fields[0], fields[1])
+	}
+	quota, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	// cgroup v1 quota -1 if unlimited
+	return parseQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func parseQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
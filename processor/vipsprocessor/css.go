@@ -0,0 +1,138 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/davidbyttow/govips/v2/vips"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var cssFilterRegex = regexp.MustCompile(`([a-z-]+)\(([^()]*)\)`)
+
+// css applies CSS filter functions e.g. css(brightness(1.1) contrast(1.2)) by equivalent filters
+func (v *VipsProcessor) css(ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args ...string) error {
+	filters, err := parseCSSFilter(strings.Join(args, ","))
+	if err != nil {
+		return err
+	}
+	for i, filter := range filters {
+		if i >= v.MaxFilterOps {
+			if v.Debug {
+				v.Logger.Debug("max-filter-ops-exceeded",
+					zap.String("name", filter.Name), zap.String("args", filter.Args))
+			}
+			break
+		}
+		if fn := v.Filters[filter.Name]; fn != nil {
+			if err := fn(ctx, img, load, strings.Split(filter.Args, ",")...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseCSSFilter parses CSS filter functions into equivalent filters
+func parseCSSFilter(s string) (filters imagorpath.Filters, err error) {
+	if u, e := url.PathUnescape(s); e == nil {
+		s = u
+	}
+	s = strings.ToLower(s)
+	if rest := strings.TrimSpace(cssFilterRegex.ReplaceAllString(s, "")); rest != "" {
+		return nil, imagor.NewError("css: invalid filter "+rest, http.StatusBadRequest)
+	}
+	for _, match := range cssFilterRegex.FindAllStringSubmatch(s, -1) {
+		name, arg := match[1], strings.TrimSpace(match[2])
+		var filter imagorpath.Filter
+		switch name {
+		case "brightness":
+			filter.Name = "modulate"
+			filter.Args = formatFloat((cssAmount(arg, 1)-1)*100) + ",0,0"
+		case "contrast":
+			// inverse of contrast filter factor
+			f := cssAmount(arg, 1)
+			filter.Name = "contrast"
+			filter.Args = formatFloat(259 * 255 * (f - 1) / (259 + 255*f) / 2.55)
+		case "saturate":
+			filter.Name = "saturation"
+			filter.Args = formatFloat((cssAmount(arg, 1) - 1) * 100)
+		case "grayscale":
+			filter.Name = "saturation"
+			filter.Args = formatFloat(-math.Min(cssAmount(arg, 1), 1) * 100)
+		case "hue-rotate":
+			filter.Name = "hue"
+			filter.Args = formatFloat(cssAngle(arg))
+		case "blur":
+			filter.Name = "blur"
+			filter.Args = formatFloat(cssAmount(strings.TrimSuffix(arg, "px"), 0))
+		case "sepia":
+			a := 1 - math.Min(cssAmount(arg, 1), 1)
+			filter.Name = "color_matrix"
+			filter.Args = formatFloats(
+				0.393+0.607*a, 0.769-0.769*a, 0.189-0.189*a,
+				0.349-0.349*a, 0.686+0.314*a, 0.168-0.168*a,
+				0.272-0.272*a, 0.534-0.534*a, 0.131+0.869*a,
+			)
+		case "invert":
+			a := math.Min(cssAmount(arg, 1), 1)
+			filter.Name = "color_matrix"
+			filter.Args = formatFloats(
+				1-2*a, 0, 0, 255*a,
+				0, 1-2*a, 0, 255*a,
+				0, 0, 1-2*a, 255*a,
+			)
+		default:
+			return nil, imagor.NewError("css: unsupported filter function "+name, http.StatusBadRequest)
+		}
+		filters = append(filters, filter)
+	}
+	return
+}
+
+// cssAmount parses number or percentage, def if empty
+func cssAmount(s string, def float64) float64 {
+	if s == "" {
+		return def
+	}
+	if strings.HasSuffix(s, "%") {
+		f, _ := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return math.Max(f/100, 0)
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return math.Max(f, 0)
+}
+
+// cssAngle parses angle of deg, rad, grad or turn into degrees
+func cssAngle(s string) float64 {
+	for _, unit := range []struct {
+		suffix string
+		deg    float64
+	}{{"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}, {"deg", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			f, _ := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+			return f * unit.deg
+		}
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func formatFloat(f float64) string {
+	// plus zero for no negative zero
+	return strconv.FormatFloat(math.Round(f*1000)/1000+0, 'f', -1, 64)
+}
+
+func formatFloats(fs ...float64) string {
+	var s []string
+	for _, f := range fs {
+		s = append(s, formatFloat(f))
+	}
+	return strings.Join(s, ",")
+}
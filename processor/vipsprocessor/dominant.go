@@ -0,0 +1,47 @@
+package vipsprocessor
+
+import (
+	"context"
+	"fmt"
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// dominantColor hex color of the first frame shrunk to 1x1,
+// weighted by alpha such that transparent pixels do not contribute.
+// Black if fully transparent
+func dominantColor(ctx context.Context, img *vips.ImageRef) (string, error) {
+	w, h := img.Width(), img.PageHeight()
+	probe, err := img.Copy()
+	if err != nil {
+		return "", err
+	}
+	AddImageRef(ctx, probe)
+	if h < probe.Height() {
+		// first frame of animation
+		if err = probe.ExtractArea(0, 0, w, h); err != nil {
+			return "", err
+		}
+	}
+	if probe.HasAlpha() {
+		if err = probe.PremultiplyAlpha(); err != nil {
+			return "", err
+		}
+	}
+	if err = probe.ResizeWithVScale(1/float64(w), 1/float64(h), vips.KernelLinear); err != nil {
+		return "", err
+	}
+	if probe.HasAlpha() {
+		if err = probe.UnpremultiplyAlpha(); err != nil {
+			return "", err
+		}
+	}
+	m, err := toNRGBA(probe)
+	if err != nil {
+		return "", err
+	}
+	c := m.NRGBAAt(m.Bounds().Min.X, m.Bounds().Min.Y)
+	if c.A == 0 {
+		return "#000000", nil
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B), nil
+}
@@ -0,0 +1,121 @@
+package vipsprocessor
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const exifGPSTag = 0x8825
+
+var exifHeader = []byte("Exif\x00\x00")
+var webpHeader = []byte("WEBP")
+
+// exifTIFF locates the EXIF TIFF structure of JPEG or WebP
+func exifTIFF(buf []byte) []byte {
+	if len(buf) > 4 && buf[0] == 0xFF && buf[1] == 0xD8 {
+		i := 2
+		for i+4 <= len(buf) && buf[i] == 0xFF {
+			marker := buf[i+1]
+			if marker == 0xDA || marker == 0xD9 {
+				break
+			}
+			end := i + 2 + (int(buf[i+2])<<8 | int(buf[i+3]))
+			if end > len(buf) {
+				break
+			}
+			if marker == 0xE1 && bytes.HasPrefix(buf[i+4:end], exifHeader) {
+				return buf[i+4+len(exifHeader) : end]
+			}
+			i = end
+		}
+		return nil
+	}
+	if len(buf) > 12 && bytes.Equal(buf[8:12], webpHeader) {
+		i := 12
+		for i+8 <= len(buf) {
+			size := int(binary.LittleEndian.Uint32(buf[i+4:]))
+			end := i + 8 + size
+			if end > len(buf) {
+				break
+			}
+			if string(buf[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(buf[i+8:end], exifHeader)
+			}
+			i = end + size%2
+		}
+	}
+	return nil
+}
+
+// stripGPS removes GPS tags from the EXIF of JPEG or WebP in place,
+// keeping the rest of EXIF untouched
+func stripGPS(buf []byte) []byte {
+	tiff := exifTIFF(buf)
+	if len(tiff) < 8 {
+		return buf
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return buf
+	}
+	ifd := int(bo.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return buf
+	}
+	n := int(bo.Uint16(tiff[ifd:]))
+	end := ifd + 2 + n*12
+	if end+4 > len(tiff) {
+		return buf
+	}
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + i*12
+		if bo.Uint16(tiff[entry:]) != exifGPSTag {
+			continue
+		}
+		clearIFD(tiff, bo, int(bo.Uint32(tiff[entry+8:])))
+		// remove GPS pointer from IFD0, shifting subsequent entries and next IFD offset
+		copy(tiff[entry:], tiff[entry+12:end+4])
+		for j := end - 8; j < end+4; j++ {
+			tiff[j] = 0
+		}
+		bo.PutUint16(tiff[ifd:], uint16(n-1))
+		break
+	}
+	return buf
+}
+
+var exifTypeSize = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// clearIFD zeros out IFD entries and their values
+func clearIFD(tiff []byte, bo binary.ByteOrder, ifd int) {
+	if ifd <= 0 || ifd+2 > len(tiff) {
+		return
+	}
+	n := int(bo.Uint16(tiff[ifd:]))
+	end := ifd + 2 + n*12
+	if end > len(tiff) {
+		return
+	}
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + i*12
+		size := exifTypeSize[bo.Uint16(tiff[entry+2:])] * int(bo.Uint32(tiff[entry+4:]))
+		if size > 4 {
+			offset := int(bo.Uint32(tiff[entry+8:]))
+			if offset > 0 && offset+size <= len(tiff) {
+				for j := offset; j < offset+size; j++ {
+					tiff[j] = 0
+				}
+			}
+		}
+	}
+	for j := ifd; j < end; j++ {
+		tiff[j] = 0
+	}
+}
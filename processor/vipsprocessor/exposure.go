@@ -0,0 +1,178 @@
+package vipsprocessor
+
+import (
+	"bytes"
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"image/png"
+	"math"
+	"strconv"
+)
+
+const (
+	autoExposureTarget   = 50
+	autoExposureMaxGamma = 2.5
+	autoExposureProbe    = 256
+)
+
+// probeLuminance mean Rec. 601 luma of image weighted by alpha, 0 to 255,
+// measured on a downsized sRGB copy. Not ok if image is fully transparent
+func probeLuminance(ctx context.Context, img *vips.ImageRef) (mean float64, ok bool, err error) {
+	probe, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, probe)
+	size := img.Width()
+	if img.Height() > size {
+		size = img.Height()
+	}
+	if s := float64(autoExposureProbe) / float64(size); s < 1 {
+		if err = probe.Resize(s, vips.KernelLinear); err != nil {
+			return
+		}
+	}
+	if err = probe.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		return
+	}
+	if probe.BandFormat() != vips.BandFormatUchar {
+		if err = probe.Cast(vips.BandFormatUchar); err != nil {
+			return
+		}
+	}
+	var luma, alpha *vips.ImageRef
+	for i, c := range []float64{0.299, 0.587, 0.114} {
+		band, err := probe.Copy()
+		if err != nil {
+			return 0, false, err
+		}
+		AddImageRef(ctx, band)
+		if err = band.ExtractBand(i, 1); err != nil {
+			return 0, false, err
+		}
+		if err = band.Linear1(c, 0); err != nil {
+			return 0, false, err
+		}
+		if luma == nil {
+			luma = band
+		} else if err = luma.Add(band); err != nil {
+			return 0, false, err
+		}
+	}
+	if !probe.HasAlpha() {
+		mean, err = luma.Average()
+		return mean, err == nil, err
+	}
+	if alpha, err = probe.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, alpha)
+	if err = alpha.ExtractBand(probe.Bands()-1, 1); err != nil {
+		return
+	}
+	a, err := alpha.Average()
+	if err != nil || a == 0 {
+		return
+	}
+	if err = luma.Multiply(alpha); err != nil {
+		return
+	}
+	if mean, err = luma.Average(); err != nil {
+		return
+	}
+	return mean / a, true, nil
+}
+
+// exposureGamma gamma moving mean luminance toward target, both 0 to 1,
+// capped within 1/max and max
+func exposureGamma(mean, target, max float64) float64 {
+	mean = math.Min(math.Max(mean, 1.0/255), 254.0/255)
+	g := math.Log(target) / math.Log(mean)
+	return math.Min(math.Max(g, 1/max), max)
+}
+
+// gammaLUT lookup table image raising input to the power of g,
+// 16-bit if ushort otherwise 8-bit
+func gammaLUT(ctx context.Context, g float64, ushort bool) (*vips.ImageRef, error) {
+	var m image.Image
+	if ushort {
+		lut := image.NewGray16(image.Rect(0, 0, 65536, 1))
+		for i := 0; i < 65536; i++ {
+			v := uint16(math.Round(65535 * math.Pow(float64(i)/65535, g)))
+			lut.Pix[2*i], lut.Pix[2*i+1] = uint8(v>>8), uint8(v)
+		}
+		m = lut
+	} else {
+		lut := image.NewGray(image.Rect(0, 0, 256, 1))
+		for i := range lut.Pix {
+			lut.Pix[i] = uint8(math.Round(255 * math.Pow(float64(i)/255, g)))
+		}
+		m = lut
+	}
+	var w bytes.Buffer
+	if err := png.Encode(&w, m); err != nil {
+		return nil, err
+	}
+	lut, err := vips.NewImageFromBuffer(w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	AddImageRef(ctx, lut)
+	return lut, nil
+}
+
+func autoExposure(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var (
+		target   = float64(autoExposureTarget)
+		maxGamma = autoExposureMaxGamma
+	)
+	if len(args) > 0 && args[0] != "" {
+		if t, e := strconv.ParseFloat(args[0], 64); e == nil {
+			target = math.Min(math.Max(t, 1), 99)
+		}
+	}
+	if len(args) > 1 && args[1] != "" {
+		if g, e := strconv.ParseFloat(args[1], 64); e == nil && g > 1 {
+			maxGamma = g
+		}
+	}
+	mean, ok, err := probeLuminance(ctx, img)
+	if err != nil || !ok {
+		return
+	}
+	g := exposureGamma(mean/255, target/100, maxGamma)
+	if math.Abs(g-1) < 0.01 {
+		return
+	}
+	ushort := img.BandFormat() == vips.BandFormatUshort
+	if !ushort && img.BandFormat() != vips.BandFormatUchar {
+		if err = img.Cast(vips.BandFormatUchar); err != nil {
+			return
+		}
+	}
+	lut, err := gammaLUT(ctx, g, ushort)
+	if err != nil {
+		return
+	}
+	if !img.HasAlpha() {
+		return img.Maplut(lut)
+	}
+	// map colour bands, keeping alpha band
+	alpha, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, alpha)
+	if err = alpha.ExtractBand(img.Bands()-1, 1); err != nil {
+		return
+	}
+	if err = img.ExtractBand(0, img.Bands()-1); err != nil {
+		return
+	}
+	if err = img.Maplut(lut); err != nil {
+		return
+	}
+	return img.BandJoin(alpha)
+}
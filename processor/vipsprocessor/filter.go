@@ -1,12 +1,18 @@
 package vipsprocessor
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/cshum/imagor"
 	"github.com/cshum/imagor/imagorpath"
 	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"math"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -25,12 +31,18 @@ func (v *VipsProcessor) watermark(ctx context.Context, img *vips.ImageRef, load
 	if blob, err = load(image); err != nil {
 		return
 	}
+	if img.Orientation() > 1 {
+		// base image not yet auto rotated, placement would be off once displayed upright
+		if err = img.AutoRotate(); err != nil {
+			return
+		}
+	}
 	var x, y, w, h int
 	var across = 1
 	var down = 1
 	var overlay *vips.ImageRef
 	var n = -1
-	var hasRepeat = args[1] == "repeat" || args[2] == "repeat"
+	var hasRepeat = ln >= 3 && (args[1] == "repeat" || args[2] == "repeat")
 	if hasRepeat {
 		// no animated watermark on repeat mode
 		n = 1
@@ -185,6 +197,164 @@ func (v *VipsProcessor) fill(ctx context.Context, img *vips.ImageRef, w, h int,
 	return
 }
 
+// letterbox extends image to the target aspect ratio, letterbox(w,h[,fill]),
+// with bars filled by blurred background or color, black by default
+func (v *VipsProcessor) letterbox(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 2 {
+		return
+	}
+	rw, _ := strconv.ParseFloat(args[0], 64)
+	rh, _ := strconv.ParseFloat(args[1], 64)
+	if rw <= 0 || rh <= 0 {
+		return
+	}
+	colour := "black"
+	if len(args) > 2 && args[2] != "" {
+		colour = strings.Join(args[2:], ",")
+	}
+	w, h := img.Width(), img.PageHeight()
+	fw, fh := float64(w), float64(h)
+	if ratio := rw / rh; fw/fh > ratio {
+		// letterbox bars top and bottom
+		fh = math.Round(fw / ratio)
+	} else {
+		// pillarbox bars left and right
+		fw = math.Round(fh * ratio)
+	}
+	if fw > float64(v.MaxWidth) || fh > float64(v.MaxHeight) {
+		return imagor.ErrMaxSizeExceeded
+	}
+	width, height := int(fw), int(fh)
+	if width == w && height == h {
+		return
+	}
+	return v.fill(ctx, img, width, height, 0, 0, 0, 0, colour)
+}
+
+// insetBorder draws border inside the image edges, inset_border(width[, color]),
+// such that the image dimensions stay unchanged
+func insetBorder(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	b, _ := strconv.Atoi(args[0])
+	w, h := img.Width(), img.PageHeight()
+	if b <= 0 {
+		return
+	}
+	if m := (int(math.Min(float64(w), float64(h))) + 1) / 2; b > m {
+		b = m
+	}
+	c := &vips.Color{}
+	if len(args) > 1 {
+		c = getColor(img, args[1])
+	}
+	if img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	ink := vips.ColorRGBA{R: c.R, G: c.G, B: c.B, A: 0xff}
+	// each frame of animation
+	for top := 0; top < img.Height(); top += h {
+		for _, r := range [][4]int{
+			{0, top, w, b}, {0, top + h - b, w, b},
+			{0, top, b, h}, {w - b, top, b, h},
+		} {
+			if err = img.DrawRect(ink, r[0], r[1], r[2], r[3], true); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// border draws solid color border around the image, enlarging the image dimensions,
+// border(width[, color]) or border(width_x, width_y, color), black by default.
+// Transparent border by color none or transparent
+func border(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	bx, _ := strconv.Atoi(args[0])
+	by := bx
+	colour := ""
+	if len(args) > 2 {
+		by, _ = strconv.Atoi(args[1])
+		colour = args[2]
+	} else if len(args) > 1 {
+		colour = args[1]
+	}
+	bx, by = clampInt(bx, 0, img.Width()), clampInt(by, 0, img.PageHeight())
+	if bx == 0 && by == 0 {
+		return
+	}
+	if img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	w, h := img.Width()+bx*2, img.PageHeight()+by*2
+	if isTransparent(colour) {
+		if !img.HasAlpha() {
+			if err = img.AddAlpha(); err != nil {
+				return
+			}
+		}
+		return img.EmbedBackgroundRGBA(bx, by, w, h, &vips.ColorRGBA{})
+	}
+	c := &vips.Color{}
+	if colour != "" {
+		c = getColor(img, colour)
+	}
+	// image alpha kept within the opaque border
+	return img.EmbedBackground(bx, by, w, h, c)
+}
+
+// padding adds padding of pixels on each side regardless of fit-in,
+// padding(left, top, right, bottom[, color]), transparent by default
+func (v *VipsProcessor) padding(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 4 {
+		return imagor.NewError("padding: expected left,top,right,bottom", http.StatusBadRequest)
+	}
+	var pads [4]int
+	for i := range pads {
+		if pads[i], err = strconv.Atoi(strings.TrimSpace(args[i])); err != nil || pads[i] < 0 {
+			return imagor.NewError("padding: invalid padding "+args[i], http.StatusBadRequest)
+		}
+	}
+	left, top, right, bottom := pads[0], pads[1], pads[2], pads[3]
+	if left+top+right+bottom == 0 {
+		return
+	}
+	w, h := img.Width()+left+right, img.PageHeight()+top+bottom
+	if w > v.MaxWidth || h > v.MaxHeight {
+		return imagor.ErrMaxSizeExceeded
+	}
+	if img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	if len(args) < 5 || isTransparent(args[4]) {
+		if !img.HasAlpha() {
+			if err = img.AddAlpha(); err != nil {
+				return
+			}
+		}
+		return img.EmbedBackgroundRGBA(left, top, w, h, &vips.ColorRGBA{})
+	}
+	return img.EmbedBackground(left, top, w, h, getColor(img, args[4]))
+}
+
+func isTransparent(colour string) bool {
+	switch strings.ToLower(colour) {
+	case "none", "transparent":
+		return true
+	}
+	return false
+}
+
 func roundCorner(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
 	var rx, ry int
 	var c *vips.Color
@@ -234,166 +404,1331 @@ func roundCorner(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, arg
 	return nil
 }
 
-func backgroundColor(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) == 0 {
+func circle(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var mask *vips.ImageRef
+	var w = img.Width()
+	var h = img.PageHeight()
+	if mask, err = vips.NewThumbnailFromBuffer([]byte(fmt.Sprintf(`
+		<svg viewBox="0 0 %d %d">
+			<circle cx="%f" cy="%f" r="%f" fill="#fff"/>
+		</svg>
+	`, w, h, float64(w)/2, float64(h)/2, math.Min(float64(w), float64(h))/2)), w, h, vips.InterestingNone); err != nil {
 		return
 	}
-	if !img.HasAlpha() {
+	AddImageRef(ctx, mask)
+	if n := GetPageN(ctx); n > 1 {
+		if err = mask.Replicate(1, n); err != nil {
+			return
+		}
+	}
+	if err = img.Composite(mask, vips.BlendModeDestIn, 0, 0); err != nil {
 		return
 	}
-	return img.Flatten(getColor(img, args[0]))
+	if len(args) > 0 && args[0] != "" {
+		return img.Flatten(getColor(img, args[0]))
+	}
+	return
 }
 
-func rotate(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) == 0 {
+func safeZone(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var (
+		ln      = len(args)
+		percent = 90.0
+		mode    = "crop"
+		c       = &vips.Color{R: 0xff, G: 0xff}
+	)
+	if ln > 0 && args[0] != "" {
+		percent, _ = strconv.ParseFloat(args[0], 64)
+	}
+	if ln > 1 && args[1] != "" {
+		mode = args[1]
+	}
+	if ln > 2 {
+		c = getColor(img, args[2])
+	}
+	if percent <= 0 || percent >= 100 {
 		return
 	}
-	if angle, _ := strconv.Atoi(args[0]); angle > 0 {
-		vAngle := vips.Angle0
-		switch angle {
-		case 90:
-			vAngle = vips.Angle270
-		case 180:
-			vAngle = vips.Angle180
-		case 270:
-			vAngle = vips.Angle90
-		}
-		if err = img.Rotate(vAngle); err != nil {
-			return err
+	var (
+		w    = img.Width()
+		h    = img.PageHeight()
+		zw   = int(math.Round(float64(w) * percent / 100))
+		zh   = int(math.Round(float64(h) * percent / 100))
+		left = (w - zw) / 2
+		top  = (h - zh) / 2
+	)
+	if mode != "overlay" {
+		return img.ExtractArea(left, top, zw, zh)
+	}
+	// draw guides of the safe zone
+	var guide *vips.ImageRef
+	var stroke = int(math.Max(1, math.Round(float64(w)/200)))
+	if guide, err = vips.NewThumbnailFromBuffer([]byte(fmt.Sprintf(`
+		<svg viewBox="0 0 %d %d">
+			<rect x="%d" y="%d" width="%d" height="%d"
+			 fill="none" stroke="#%02x%02x%02x" stroke-width="%d"/>
+		</svg>
+	`, w, h, left, top, zw, zh, c.R, c.G, c.B, stroke)), w, h, vips.InterestingNone); err != nil {
+		return
+	}
+	AddImageRef(ctx, guide)
+	if n := GetPageN(ctx); n > 1 {
+		if err = guide.Replicate(1, n); err != nil {
+			return
 		}
 	}
-	return
-}
-
-func grayscale(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
-	return img.Modulate(1, 0, 0)
+	return img.Composite(guide, vips.BlendModeOver, 0, 0)
 }
 
-func brightness(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) == 0 {
+func (v *VipsProcessor) mapPalette(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 || IsAnimated(ctx) {
+		// skip animation support
 		return
 	}
-	b, _ := strconv.ParseFloat(args[0], 64)
-	b = b * 255 / 100
-	return linearRGB(img, []float64{1, 1, 1}, []float64{b, b, b})
-}
-
-func contrast(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) == 0 {
+	palette := v.Palettes[args[0]]
+	if len(palette) == 0 {
 		return
 	}
-	a, _ := strconv.ParseFloat(args[0], 64)
-	a = a * 255 / 100
-	a = math.Min(math.Max(a, -255), 255)
-	a = (259 * (a + 255)) / (255 * (259 - a))
-	b := 128 - a*128
-	return linearRGB(img, []float64{a, a, a}, []float64{b, b, b})
-}
-
-func hue(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) == 0 {
+	if err = toRGB8(img); err != nil {
 		return
 	}
-	h, _ := strconv.ParseFloat(args[0], 64)
-	return img.Modulate(1, 1, h)
+	if len(args) > 1 && (args[1] == "dither" || args[1] == "true") {
+		// spread of the ordered dither by palette colors per channel
+		spread := 256 / math.Ceil(math.Cbrt(float64(len(palette))))
+		if err = orderedDither(ctx, img, spread); err != nil {
+			return
+		}
+	}
+	// map colors without alpha, alpha kept
+	return mapRGB(ctx, img, func(c color.NRGBA) color.NRGBA {
+		return color.NRGBAModel.Convert(palette.Convert(c)).(color.NRGBA)
+	})
 }
 
-func saturation(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) == 0 {
+func replaceColor(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 2 {
 		return
 	}
-	s, _ := strconv.ParseFloat(args[0], 64)
-	s = 1 + s/100
-	return img.Modulate(1, s, 0)
-}
-
-func rgb(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) != 3 {
+	var (
+		from      = getColor(img, args[0])
+		to        = getColor(img, args[1])
+		tolerance float64
+		scale     = 1.0
+	)
+	if len(args) > 2 {
+		tolerance, _ = strconv.ParseFloat(args[2], 64)
+	}
+	if img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	format := img.BandFormat()
+	if format == vips.BandFormatUshort {
+		scale = 257
+	}
+	// squared euclidean distance of rgb to color from
+	dist, err := img.Copy()
+	if err != nil {
 		return
 	}
-	r, _ := strconv.ParseFloat(args[0], 64)
-	g, _ := strconv.ParseFloat(args[1], 64)
-	b, _ := strconv.ParseFloat(args[2], 64)
-	r = r * 255 / 100
-	g = g * 255 / 100
-	b = b * 255 / 100
-	return linearRGB(img, []float64{1, 1, 1}, []float64{r, g, b})
-}
-
-func modulate(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if len(args) != 3 {
+	AddImageRef(ctx, dist)
+	if err = dist.ExtractBand(0, 3); err != nil {
 		return
 	}
-	b, _ := strconv.ParseFloat(args[0], 64)
-	s, _ := strconv.ParseFloat(args[1], 64)
-	h, _ := strconv.ParseFloat(args[2], 64)
-	b = 1 + b/100
-	s = 1 + s/100
-	return img.Modulate(b, s, h)
-}
-
-func blur(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	if IsAnimated(ctx) {
-		// skip animation support
+	if err = dist.Linear([]float64{1, 1, 1}, []float64{
+		-scale * float64(from.R), -scale * float64(from.G), -scale * float64(from.B),
+	}); err != nil {
 		return
 	}
-	var sigma float64
-	switch len(args) {
-	case 2:
-		sigma, _ = strconv.ParseFloat(args[1], 64)
-		break
-	case 1:
-		sigma, _ = strconv.ParseFloat(args[0], 64)
-		break
+	if err = dist.Multiply(dist); err != nil {
+		return
 	}
-	sigma /= 2
-	if sigma > 0 {
-		return img.GaussianBlur(sigma)
+	mask, err := sumBands(ctx, dist)
+	if err != nil {
+		return
 	}
-	return
-}
-
-func sharpen(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
-	var sigma float64
-	switch len(args) {
-	case 1:
-		sigma, _ = strconv.ParseFloat(args[0], 64)
-		break
-	case 2, 3:
-		sigma, _ = strconv.ParseFloat(args[1], 64)
-		break
+	// mask 255 within tolerance otherwise 0, as squared distances are integers
+	t := math.Floor(tolerance * scale * tolerance * scale)
+	if err = mask.Linear1(-255, 255*(t+1)); err != nil {
+		return
 	}
-	sigma = 1 + sigma*2
-	return img.Sharpen(sigma, 1, 2)
-}
-
-func stripIcc(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
-	return img.RemoveICCProfile()
-}
-
-func stripExif(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
-	return img.RemoveICCProfile()
-}
-
-func trimFilter(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) error {
-	var (
-		ln        = len(args)
-		pos       string
-		tolerance int
-	)
-	if ln > 0 {
-		tolerance, _ = strconv.Atoi(args[0])
+	if err = mask.Cast(vips.BandFormatUchar); err != nil {
+		return
 	}
-	if ln > 1 {
-		pos = args[1]
+	if avg, e := mask.Average(); e != nil || avg == 0 {
+		// nothing replaced
+		return e
 	}
-	return trim(ctx, img, pos, tolerance)
+	if err = mask.Linear1(1.0/255, 0); err != nil {
+		return
+	}
+	fill, err := mask.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, fill)
+	if err = fill.Linear([]float64{
+		scale * float64(to.R), scale * float64(to.G), scale * float64(to.B),
+	}, []float64{0, 0, 0}); err != nil {
+		return
+	}
+	if err = mask.Linear1(-1, 1); err != nil {
+		return
+	}
+	rgb, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, rgb)
+	if err = rgb.ExtractBand(0, 3); err != nil {
+		return
+	}
+	if err = rgb.Multiply(mask); err != nil {
+		return
+	}
+	if err = rgb.Add(fill); err != nil {
+		return
+	}
+	if err = rgb.Cast(format); err != nil {
+		return
+	}
+	if img.HasAlpha() {
+		alpha, err := img.Copy()
+		if err != nil {
+			return err
+		}
+		AddImageRef(ctx, alpha)
+		if err = alpha.ExtractBand(img.Bands()-1, 1); err != nil {
+			return err
+		}
+		if err = rgb.BandJoin(alpha); err != nil {
+			return err
+		}
+	}
+	return img.Insert(rgb, 0, 0, false, nil)
 }
 
-func linearRGB(img *vips.ImageRef, a, b []float64) error {
-	if img.HasAlpha() {
+func (v *VipsProcessor) diff(ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 || IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	name := args[0]
+	if unescape, e := url.QueryUnescape(args[0]); e == nil {
+		name = unescape
+	}
+	amplify := 1.0
+	if len(args) > 1 {
+		if amplify, _ = strconv.ParseFloat(args[1], 64); amplify <= 0 {
+			amplify = 1
+		}
+	}
+	var blob *imagor.Blob
+	if blob, err = load(name); err != nil {
+		return
+	}
+	if img.Orientation() > 1 {
+		if err = img.AutoRotate(); err != nil {
+			return
+		}
+	}
+	// align dimensions of the other image to the current image
+	var other *vips.ImageRef
+	if other, err = v.newThumbnail(
+		blob, img.Width(), img.PageHeight(), vips.InterestingNone, vips.SizeForce, 1,
+	); err != nil {
+		return
+	}
+	AddImageRef(ctx, other)
+	if other.Width() != img.Width() || other.Height() != img.Height() {
+		return imagor.NewError("diff: dimensions mismatch", http.StatusUnprocessableEntity)
+	}
+	for _, m := range []*vips.ImageRef{img, other} {
+		if err = toRGB8(m); err != nil {
+			return
+		}
+		if !m.HasAlpha() {
+			if err = m.AddAlpha(); err != nil {
+				return
+			}
+		}
+	}
+	var amplified, abs *vips.ImageRef
+	if amplified, err = absLUT(ctx, amplify); err != nil {
+		return
+	}
+	if abs, err = absLUT(ctx, 1); err != nil {
+		return
+	}
+	// absolute difference of each channel amplified, by lookup of difference offset by 255
+	if err = other.Linear1(-1, 255); err != nil {
+		return
+	}
+	if err = img.Add(other); err != nil {
+		return
+	}
+	if err = img.Cast(vips.BandFormatUshort); err != nil {
+		return
+	}
+	if err = img.Maplut(amplified); err != nil {
+		return
+	}
+	// alpha difference applied to all channels, by max(c, a) = (c + a + |c - a|) / 2
+	alpha, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, alpha)
+	if err = alpha.ExtractBand(3, 1); err != nil {
+		return
+	}
+	if err = img.ExtractBand(0, 3); err != nil {
+		return
+	}
+	d, err := alpha.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, d)
+	if err = d.Linear1(-1, 255); err != nil {
+		return
+	}
+	if err = d.Add(img); err != nil {
+		return
+	}
+	if err = d.Cast(vips.BandFormatUshort); err != nil {
+		return
+	}
+	if err = d.Maplut(abs); err != nil {
+		return
+	}
+	if err = img.Add(alpha); err != nil {
+		return
+	}
+	if err = img.Add(d); err != nil {
+		return
+	}
+	if err = img.Linear1(0.5, 0); err != nil {
+		return
+	}
+	if err = img.Cast(vips.BandFormatUchar); err != nil {
+		return
+	}
+	return img.BandJoinConst([]float64{255})
+}
+
+// absLUT lookup table of absolute difference offset by 255 multiplied by amplify, capped at 255
+func absLUT(ctx context.Context, amplify float64) (*vips.ImageRef, error) {
+	m := image.NewGray(image.Rect(0, 0, 511, 1))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(math.Min(math.Abs(float64(i-255))*amplify, 255))
+	}
+	return newImageFromGo(ctx, m)
+}
+
+const (
+	// zoomBlurMaxSteps caps scaled copies composited, at cost of a full image resize each
+	zoomBlurMaxSteps = 16
+	// zoomBlurMinScale caps the scale up of the last copy at 10x
+	zoomBlurMinScale = 0.1
+)
+
+// zoomBlur radial blur zooming from center, zoom_blur(strength[, x, y])
+// of strength 0 to 100 in % of the distance to center, and center x y in fraction of dimensions,
+// averaging copies progressively scaled up from center
+func zoomBlur(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 || IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	strength, _ := strconv.ParseFloat(args[0], 64)
+	if strength = math.Min(strength, 100) / 100; !(strength > 0) {
+		return
+	}
+	cx, cy := 0.5, 0.5
+	if len(args) > 2 {
+		cx, _ = strconv.ParseFloat(args[1], 64)
+		cy, _ = strconv.ParseFloat(args[2], 64)
+	}
+	var (
+		w, h   = img.Width(), img.Height()
+		x0     = cx * float64(w-1)
+		y0     = cy * float64(h-1)
+		format = img.BandFormat()
+		alpha  = img.HasAlpha()
+		// copies along the zoom path, enough for a continuous smear
+		n = clampInt(int(strength*math.Max(float64(w), float64(h))/2), 2, zoomBlurMaxSteps)
+	)
+	src, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, src)
+	if alpha {
+		if err = src.PremultiplyAlpha(); err != nil {
+			return
+		}
+	}
+	var sum *vips.ImageRef
+	for k := 0; k < n; k++ {
+		// copy scaled up from center, i.e. sampled progressively toward center
+		t := math.Max(1-strength*float64(k)/float64(n-1), zoomBlurMinScale)
+		layer, err := src.Copy()
+		if err != nil {
+			return err
+		}
+		AddImageRef(ctx, layer)
+		if k > 0 {
+			if err = layer.Resize(1/t, vips.KernelLinear); err != nil {
+				return err
+			}
+			// center stays in place
+			left := clampInt(int(math.Round(x0*float64(layer.Width()-w)/float64(w))), 0, layer.Width()-w)
+			top := clampInt(int(math.Round(y0*float64(layer.Height()-h)/float64(h))), 0, layer.Height()-h)
+			if err = layer.ExtractArea(left, top, w, h); err != nil {
+				return err
+			}
+		}
+		if sum == nil {
+			sum = layer
+		} else if err = sum.Add(layer); err != nil {
+			return err
+		}
+	}
+	if err = sum.Linear1(1/float64(n), 0); err != nil {
+		return
+	}
+	if alpha {
+		if err = sum.UnpremultiplyAlpha(); err != nil {
+			return
+		}
+	}
+	if err = sum.Cast(format); err != nil {
+		return
+	}
+	return img.Insert(sum, 0, 0, false, nil)
+}
+
+func (v *VipsProcessor) depthBlur(ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 2 || IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	name := args[0]
+	if unescape, e := url.QueryUnescape(args[0]); e == nil {
+		name = unescape
+	}
+	sigma, _ := strconv.ParseFloat(args[1], 64)
+	if sigma /= 2; sigma <= 0 {
+		return
+	}
+	var mask, blurred *vips.ImageRef
+	if mask, err = v.loadMask(ctx, img, load, name); err != nil {
+		return
+	}
+	if blurred, err = img.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, blurred)
+	if err = blurred.GaussianBlur(sigma); err != nil {
+		return
+	}
+	return maskBlend(ctx, img, img, blurred, mask, "depth_blur")
+}
+
+func (v *VipsProcessor) sharpenMask(ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 2 || IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	name := args[0]
+	if unescape, e := url.QueryUnescape(args[0]); e == nil {
+		name = unescape
+	}
+	amount, _ := strconv.ParseFloat(args[1], 64)
+	if amount <= 0 {
+		return
+	}
+	var mask, sharpened *vips.ImageRef
+	if mask, err = v.loadMask(ctx, img, load, name); err != nil {
+		return
+	}
+	if sharpened, err = img.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, sharpened)
+	if err = sharpened.Sharpen(1+amount*2, 1, 2); err != nil {
+		return
+	}
+	return maskBlend(ctx, img, sharpened, img, mask, "sharpen_mask")
+}
+
+// loadMask loads mask image resized to the current image, autorotating the image if needed
+func (v *VipsProcessor) loadMask(
+	ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, name string,
+) (*vips.ImageRef, error) {
+	blob, err := load(name)
+	if err != nil {
+		return nil, err
+	}
+	if img.Orientation() > 1 {
+		if err = img.AutoRotate(); err != nil {
+			return nil, err
+		}
+	}
+	mask, err := v.newThumbnail(
+		blob, img.Width(), img.PageHeight(), vips.InterestingNone, vips.SizeForce, 1,
+	)
+	if err != nil {
+		return nil, err
+	}
+	AddImageRef(ctx, mask)
+	return mask, nil
+}
+
+// maskBlend replaces image by blending white and black images by mask luminance,
+// white as the white image and black as the black image
+func maskBlend(ctx context.Context, img, white, black, mask *vips.ImageRef, name string) (err error) {
+	if white.Width() != mask.Width() || white.Height() != mask.Height() ||
+		black.Width() != mask.Width() || black.Height() != mask.Height() {
+		return imagor.NewError(name+": dimensions mismatch", http.StatusUnprocessableEntity)
+	}
+	format := img.BandFormat()
+	// blend factor by mask luminance multiplied by mask alpha, 0 to 1
+	var k, a, w, b *vips.ImageRef
+	if k, err = mask.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, k)
+	if err = k.ToColorSpace(vips.InterpretationBW); err != nil {
+		return
+	}
+	scale := 1.0 / 255
+	if k.HasAlpha() {
+		if a, err = k.Copy(); err != nil {
+			return
+		}
+		AddImageRef(ctx, a)
+		if err = a.ExtractBand(k.Bands()-1, 1); err != nil {
+			return
+		}
+		if err = k.ExtractBand(0, 1); err != nil {
+			return
+		}
+		if err = k.Multiply(a); err != nil {
+			return
+		}
+		scale /= 255
+	}
+	if err = k.Linear1(scale, 0); err != nil {
+		return
+	}
+	// white * k + black * (1 - k)
+	if w, err = white.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, w)
+	if err = w.Multiply(k); err != nil {
+		return
+	}
+	if err = k.Linear1(-1, 1); err != nil {
+		return
+	}
+	if b, err = black.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, b)
+	if err = b.Multiply(k); err != nil {
+		return
+	}
+	if err = w.Add(b); err != nil {
+		return
+	}
+	if err = w.Cast(format); err != nil {
+		return
+	}
+	return img.Insert(w, 0, 0, false, nil)
+}
+
+func (v *VipsProcessor) ifLarger(ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args ...string) error {
+	return v.conditional(ctx, img, load, true, args...)
+}
+
+func (v *VipsProcessor) ifSmaller(ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, args ...string) error {
+	return v.conditional(ctx, img, load, false, args...)
+}
+
+// conditional applies filter of name args[1] with args[2:] if source image
+// is larger or smaller than args[0] of width, or WxH of width or height
+func (v *VipsProcessor) conditional(
+	ctx context.Context, img *vips.ImageRef, load imagor.LoadFunc, larger bool, args ...string,
+) error {
+	if len(args) < 2 {
+		return nil
+	}
+	fn := v.Filters[args[1]]
+	if fn == nil {
+		return nil
+	}
+	var (
+		sw, sh = GetSourceSize(ctx)
+		size   = strings.SplitN(args[0], "x", 2)
+		w, _   = strconv.Atoi(size[0])
+		h      int
+		ok     bool
+	)
+	if len(size) > 1 {
+		h, _ = strconv.Atoi(size[1])
+	}
+	if larger {
+		ok = (w > 0 && sw > w) || (h > 0 && sh > h)
+	} else {
+		ok = (w <= 0 || sw < w) && (h <= 0 || sh < h) && (w > 0 || h > 0)
+	}
+	if !ok {
+		return nil
+	}
+	return fn(ctx, img, load, args[2:]...)
+}
+
+func backgroundColor(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	if !img.HasAlpha() {
+		return
+	}
+	return img.Flatten(getColor(img, args[0]))
+}
+
+func animationBackground(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if !IsAnimated(ctx) || !img.HasAlpha() {
+		return
+	}
+	c := &vips.Color{R: 0xff, G: 0xff, B: 0xff}
+	if len(args) > 0 && args[0] != "" {
+		// resolved once such that auto color of the first frame applies to all frames
+		c = getColor(img, strings.Join(args, ","))
+	}
+	// flatten all frames at once, no transparency left for frames to disagree on
+	return img.Flatten(c)
+}
+
+func gradientBg(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 2 || !img.HasAlpha() {
+		return
+	}
+	var (
+		c1     = getColor(img, args[0])
+		c2     = getColor(img, args[1])
+		w      = img.Width()
+		h      = img.PageHeight()
+		x2, y2 = 0, 1
+	)
+	if len(args) > 2 && args[2] == "horizontal" {
+		x2, y2 = 1, 0
+	}
+	var gradient *vips.ImageRef
+	if gradient, err = vips.NewThumbnailFromBuffer([]byte(fmt.Sprintf(`
+		<svg viewBox="0 0 %d %d">
+			<defs>
+				<linearGradient id="bg" x1="0" y1="0" x2="%d" y2="%d">
+					<stop offset="0" stop-color="#%02x%02x%02x"/>
+					<stop offset="1" stop-color="#%02x%02x%02x"/>
+				</linearGradient>
+			</defs>
+			<rect x="0" y="0" width="%d" height="%d" fill="url(#bg)"/>
+		</svg>
+	`, w, h, x2, y2, c1.R, c1.G, c1.B, c2.R, c2.G, c2.B, w, h)), w, h, vips.InterestingNone); err != nil {
+		return
+	}
+	AddImageRef(ctx, gradient)
+	if n := GetPageN(ctx); n > 1 {
+		if err = gradient.Replicate(1, n); err != nil {
+			return
+		}
+	}
+	return img.Composite(gradient, vips.BlendModeDestOver, 0, 0)
+}
+
+func checker(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if !img.HasAlpha() {
+		return
+	}
+	var (
+		size = 8
+		c1   = &vips.Color{R: 0xff, G: 0xff, B: 0xff}
+		c2   = &vips.Color{R: 0xcc, G: 0xcc, B: 0xcc}
+		w    = img.Width()
+		h    = img.PageHeight()
+	)
+	if len(args) > 0 && args[0] != "" {
+		if n, _ := strconv.Atoi(args[0]); n > 0 {
+			size = n
+		}
+	}
+	if len(args) > 1 && args[1] != "" {
+		c1 = getColor(img, args[1])
+	}
+	if len(args) > 2 && args[2] != "" {
+		c2 = getColor(img, args[2])
+	}
+	var board *vips.ImageRef
+	if board, err = vips.NewThumbnailFromBuffer([]byte(fmt.Sprintf(`
+		<svg viewBox="0 0 %d %d" shape-rendering="crispEdges">
+			<defs>
+				<pattern id="checker" x="0" y="0" width="%d" height="%d" patternUnits="userSpaceOnUse">
+					<rect x="0" y="0" width="%d" height="%d" fill="#%02x%02x%02x"/>
+					<rect x="%d" y="0" width="%d" height="%d" fill="#%02x%02x%02x"/>
+					<rect x="0" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"/>
+				</pattern>
+			</defs>
+			<rect x="0" y="0" width="%d" height="%d" fill="url(#checker)"/>
+		</svg>
+	`, w, h, size*2, size*2,
+		size*2, size*2, c1.R, c1.G, c1.B,
+		size, size, size, c2.R, c2.G, c2.B,
+		size, size, size, c2.R, c2.G, c2.B,
+		w, h)), w, h, vips.InterestingNone); err != nil {
+		return
+	}
+	AddImageRef(ctx, board)
+	if n := GetPageN(ctx); n > 1 {
+		if err = board.Replicate(1, n); err != nil {
+			return
+		}
+	}
+	if err = img.Composite(board, vips.BlendModeDestOver, 0, 0); err != nil {
+		return
+	}
+	// board is opaque, flatten such that the output has no transparency left
+	return img.Flatten(c1)
+}
+
+func (v *VipsProcessor) minSize(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) < 2 {
+		return
+	}
+	var (
+		w, _ = strconv.Atoi(args[0])
+		h, _ = strconv.Atoi(args[1])
+	)
+	if w < img.Width() {
+		w = img.Width()
+	}
+	if h < img.PageHeight() {
+		h = img.PageHeight()
+	}
+	if w == img.Width() && h == img.PageHeight() {
+		return
+	}
+	if w > v.MaxWidth || h > v.MaxHeight {
+		return imagor.ErrMaxSizeExceeded
+	}
+	left := (w - img.Width()) / 2
+	top := (h - img.PageHeight()) / 2
+	if len(args) < 3 && img.HasAlpha() {
+		return img.EmbedBackgroundRGBA(left, top, w, h, &vips.ColorRGBA{})
+	}
+	c := &vips.Color{R: 0xff, G: 0xff, B: 0xff}
+	if len(args) > 2 {
+		c = getColor(img, args[2])
+	}
+	if img.HasAlpha() {
+		if err = img.Flatten(c); err != nil {
+			return
+		}
+	}
+	return img.EmbedBackground(left, top, w, h, c)
+}
+
+// padReflect pads the image by mirroring the edge pixels,
+// pad_reflect(size) for all sides or pad_reflect(left,top,right,bottom)
+func padReflect(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 || IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	var pads [4]int
+	for i := range pads {
+		if len(args) >= 4 {
+			pads[i], _ = strconv.Atoi(args[i])
+		} else {
+			pads[i], _ = strconv.Atoi(args[0])
+		}
+	}
+	w, h := img.Width(), img.PageHeight()
+	for i := range pads {
+		// mirror at most once of the image
+		if pads[i] < 0 {
+			pads[i] = 0
+		} else if i%2 == 0 && pads[i] > w {
+			pads[i] = w
+		} else if i%2 == 1 && pads[i] > h {
+			pads[i] = h
+		}
+	}
+	if pads == [4]int{} {
+		return
+	}
+	return img.Embed(pads[0], pads[1], w+pads[0]+pads[2], h+pads[1]+pads[3], vips.ExtendMirror)
+}
+
+func colorMatrix(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var m []float64
+	for _, arg := range args {
+		f, e := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+		if e != nil {
+			return imagor.NewError("color_matrix: invalid matrix value "+arg, http.StatusBadRequest)
+		}
+		m = append(m, f)
+	}
+	var cols int
+	switch len(m) {
+	case 9:
+		cols = 3
+	case 12:
+		// 4th column as offset
+		cols = 4
+	default:
+		return imagor.NewError("color_matrix: expected 3x3 or 3x4 matrix", http.StatusBadRequest)
+	}
+	return recombRGB(ctx, img, m, cols)
+}
+
+// recombRGB recombines rgb bands by 3x3 matrix, or 3x4 with 4th column as offset, keeping alpha
+func recombRGB(ctx context.Context, img *vips.ImageRef, m []float64, cols int) (err error) {
+	if img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	format := img.BandFormat()
+	scale := 1.0
+	if format == vips.BandFormatUshort {
+		scale = 257
+	}
+	var bands []*vips.ImageRef
+	var band *vips.ImageRef
+	for i := 0; i < 3; i++ {
+		var out *vips.ImageRef
+		for j := 0; j < 3; j++ {
+			if band, err = img.Copy(); err != nil {
+				return
+			}
+			AddImageRef(ctx, band)
+			if err = band.ExtractBand(j, 1); err != nil {
+				return
+			}
+			if err = band.Linear1(m[i*cols+j], 0); err != nil {
+				return
+			}
+			if out == nil {
+				out = band
+			} else if err = out.Add(band); err != nil {
+				return
+			}
+		}
+		if cols == 4 {
+			if err = out.Linear1(1, scale*m[i*cols+3]); err != nil {
+				return
+			}
+		}
+		bands = append(bands, out)
+	}
+	if img.HasAlpha() {
+		if band, err = img.Copy(); err != nil {
+			return
+		}
+		AddImageRef(ctx, band)
+		if err = band.ExtractBand(3, 1); err != nil {
+			return
+		}
+		bands = append(bands, band)
+	}
+	out := bands[0]
+	if err = out.BandJoin(bands[1:]...); err != nil {
+		return
+	}
+	if err = out.Cast(format); err != nil {
+		return
+	}
+	return img.Insert(out, 0, 0, false, nil)
+}
+
+// sepiaMatrix classic sepia tone coefficients
+var sepiaMatrix = []float64{
+	0.393, 0.769, 0.189,
+	0.349, 0.686, 0.168,
+	0.272, 0.534, 0.131,
+}
+
+// sepia applies warm brown tone by sepia([intensity]) of 0 to 100, interpolated with the original
+func sepia(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	intensity := 100.0
+	if len(args) > 0 && args[0] != "" {
+		intensity, _ = strconv.ParseFloat(args[0], 64)
+	}
+	a := math.Max(math.Min(intensity, 100), 0) / 100
+	if a == 0 {
+		return
+	}
+	m := make([]float64, 9)
+	for i, f := range sepiaMatrix {
+		var identity float64
+		if i%4 == 0 {
+			identity = 1
+		}
+		m[i] = identity + (f-identity)*a
+	}
+	return recombRGB(ctx, img, m, 3)
+}
+
+func enlarge(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	sw, sh := GetSourceSize(ctx)
+	if sw == 0 || sh == 0 || (img.Width() <= sw && img.PageHeight() <= sh) {
+		// applies only when upscaled
+		return
+	}
+	var amount float64 = 1
+	if len(args) > 0 && args[0] != "" {
+		amount, _ = strconv.ParseFloat(args[0], 64)
+	}
+	if amount <= 0 {
+		return
+	}
+	scale := math.Max(float64(img.Width())/float64(sw), float64(img.PageHeight())/float64(sh))
+	// upscale softens details over the scale in pixels, hence sharpen radius grows with it
+	sigma := math.Min(0.5+scale*0.3, 3)
+	return img.Sharpen(sigma, 2, 3*amount)
+}
+
+func rotate(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	if angle, _ := strconv.Atoi(args[0]); angle > 0 {
+		vAngle := vips.Angle0
+		switch angle {
+		case 90:
+			vAngle = vips.Angle270
+		case 180:
+			vAngle = vips.Angle180
+		case 270:
+			vAngle = vips.Angle90
+		}
+		if err = img.Rotate(vAngle); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// flip mirrors the image vertically, each frame of animation
+func flip(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
+	if img.Orientation() > 1 {
+		// mirror as displayed, such that orientation stays correct
+		if err = img.AutoRotate(); err != nil {
+			return
+		}
+	}
+	return flipVertical(ctx, img)
+}
+
+// flop mirrors the image horizontally
+func flop(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
+	if img.Orientation() > 1 {
+		if err = img.AutoRotate(); err != nil {
+			return
+		}
+	}
+	return img.Flip(vips.DirectionHorizontal)
+}
+
+func grayscale(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
+	return img.Modulate(1, 0, 0)
+}
+
+func brightness(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	b, _ := strconv.ParseFloat(args[0], 64)
+	b = b * 255 / 100
+	return linearRGB(img, []float64{1, 1, 1}, []float64{b, b, b})
+}
+
+func opacity(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	o, e := strconv.ParseFloat(args[0], 64)
+	if e != nil || o >= 100 {
+		return
+	}
+	if o < 0 {
+		o = 0
+	}
+	if !img.HasAlpha() {
+		if err = img.AddAlpha(); err != nil {
+			return
+		}
+	}
+	// scale alpha band only, all frames at once for animation
+	a := make([]float64, img.Bands())
+	b := make([]float64, img.Bands())
+	for i := range a {
+		a[i] = 1
+	}
+	a[len(a)-1] = o / 100
+	return img.Linear(a, b)
+}
+
+func contrast(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	a, _ := strconv.ParseFloat(args[0], 64)
+	a = a * 255 / 100
+	a = math.Min(math.Max(a, -255), 255)
+	a = (259 * (a + 255)) / (255 * (259 - a))
+	b := 128 - a*128
+	return linearRGB(img, []float64{a, a, a}, []float64{b, b, b})
+}
+
+func hue(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	h, _ := strconv.ParseFloat(args[0], 64)
+	return img.Modulate(1, 1, h)
+}
+
+func saturation(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 {
+		return
+	}
+	s, _ := strconv.ParseFloat(args[0], 64)
+	s = 1 + s/100
+	return img.Modulate(1, s, 0)
+}
+
+func rgb(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) != 3 {
+		return
+	}
+	r, _ := strconv.ParseFloat(args[0], 64)
+	g, _ := strconv.ParseFloat(args[1], 64)
+	b, _ := strconv.ParseFloat(args[2], 64)
+	r = r * 255 / 100
+	g = g * 255 / 100
+	b = b * 255 / 100
+	return linearRGB(img, []float64{1, 1, 1}, []float64{r, g, b})
+}
+
+func modulate(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) != 3 {
+		return
+	}
+	b, _ := strconv.ParseFloat(args[0], 64)
+	s, _ := strconv.ParseFloat(args[1], 64)
+	h, _ := strconv.ParseFloat(args[2], 64)
+	b = 1 + b/100
+	s = 1 + s/100
+	return img.Modulate(b, s, h)
+}
+
+func blur(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	var sigma float64
+	switch len(args) {
+	case 2:
+		sigma, _ = strconv.ParseFloat(args[1], 64)
+		break
+	case 1:
+		sigma, _ = strconv.ParseFloat(args[0], 64)
+		break
+	}
+	sigma /= 2
+	if sigma > 0 {
+		return img.GaussianBlur(sigma)
+	}
+	return
+}
+
+func pixelate(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var block = 1
+	if len(args) > 0 {
+		block, _ = strconv.Atoi(args[0])
+	}
+	if block <= 1 {
+		return
+	}
+	if len(args) < 5 {
+		return pixelateImage(img, block)
+	}
+	var x, y, w, h int
+	x, _ = strconv.Atoi(args[1])
+	y, _ = strconv.Atoi(args[2])
+	w, _ = strconv.Atoi(args[3])
+	h, _ = strconv.Atoi(args[4])
+	// clamp region within frame
+	ph := img.PageHeight()
+	x = clampInt(x, 0, img.Width())
+	y = clampInt(y, 0, ph)
+	w = clampInt(w, 0, img.Width()-x)
+	h = clampInt(h, 0, ph-y)
+	if w == 0 || h == 0 {
+		return
+	}
+	for top := y; top < img.Height(); top += ph {
+		var region *vips.ImageRef
+		if region, err = img.Copy(); err != nil {
+			return
+		}
+		AddImageRef(ctx, region)
+		if err = region.ExtractArea(x, top, w, h); err != nil {
+			return
+		}
+		if err = pixelateImage(region, block); err != nil {
+			return
+		}
+		if err = img.Insert(region, x, top, false, nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// pixelateImage mosaics image into blocks, by nearest neighbour shrink then enlarge to the original size.
+// Blocks are aligned per frame for animation
+func pixelateImage(img *vips.ImageRef, block int) (err error) {
+	w, h, ph := img.Width(), img.Height(), img.PageHeight()
+	sw, sh := (w+block-1)/block, (ph+block-1)/block*(h/ph)
+	if err = img.ResizeWithVScale(
+		float64(sw)/float64(w), float64(sh)/float64(h), vips.KernelNearest); err != nil {
+		return
+	}
+	return img.ResizeWithVScale(
+		float64(w)/float64(img.Width()), float64(h)/float64(img.Height()), vips.KernelNearest)
+}
+
+func sharpen(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var sigma float64
+	switch len(args) {
+	case 1:
+		sigma, _ = strconv.ParseFloat(args[0], 64)
+		break
+	case 2, 3:
+		sigma, _ = strconv.ParseFloat(args[1], 64)
+		break
+	}
+	sigma = 1 + sigma*2
+	return img.Sharpen(sigma, 1, 2)
+}
+
+func stripIcc(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
+	return img.RemoveICCProfile()
+}
+
+func stripExif(_ context.Context, img *vips.ImageRef, _ imagor.LoadFunc, _ ...string) (err error) {
+	return img.RemoveICCProfile()
+}
+
+func trimFilter(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) error {
+	var (
+		ln        = len(args)
+		pos       string
+		tolerance int
+	)
+	if ln > 0 {
+		tolerance, _ = strconv.Atoi(args[0])
+	}
+	if ln > 1 {
+		pos = args[1]
+	}
+	return trim(ctx, img, pos, tolerance)
+}
+
+func linearRGB(img *vips.ImageRef, a, b []float64) error {
+	if img.HasAlpha() {
 		a = append(a, 1)
 		b = append(b, 0)
 	}
 	return img.Linear(a, b)
 }
+
+// sumBands single band image of the sum of all bands
+func sumBands(ctx context.Context, img *vips.ImageRef) (sum *vips.ImageRef, err error) {
+	for i := 0; i < img.Bands(); i++ {
+		band, err := img.Copy()
+		if err != nil {
+			return nil, err
+		}
+		AddImageRef(ctx, band)
+		if err = band.ExtractBand(i, 1); err != nil {
+			return nil, err
+		}
+		if sum == nil {
+			sum = band
+		} else if err = sum.Add(band); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// bayer4 ordered dither threshold matrix
+var bayer4 = [16]uint8{0, 8, 2, 10, 12, 4, 14, 6, 3, 11, 1, 9, 15, 7, 13, 5}
+
+// orderedDither offsets colour bands of 8-bit sRGB image by tiled Bayer threshold within spread
+func orderedDither(ctx context.Context, img *vips.ImageRef, spread float64) (err error) {
+	m := image.NewGray(image.Rect(0, 0, 4, 4))
+	copy(m.Pix, bayer4[:])
+	var pattern *vips.ImageRef
+	if pattern, err = newImageFromGo(ctx, m); err != nil {
+		return
+	}
+	w, h := img.Width(), img.Height()
+	if err = pattern.Replicate((w+3)/4, (h+3)/4); err != nil {
+		return
+	}
+	if err = pattern.ExtractArea(0, 0, w, h); err != nil {
+		return
+	}
+	// threshold centered around zero, on each colour band
+	a, b := spread/16, spread/32-spread/2
+	if err = pattern.Linear([]float64{a, a, a}, []float64{b, b, b}); err != nil {
+		return
+	}
+	if img.HasAlpha() {
+		if err = pattern.BandJoinConst([]float64{0}); err != nil {
+			return
+		}
+	}
+	if err = img.Add(pattern); err != nil {
+		return
+	}
+	return img.Cast(vips.BandFormatUchar)
+}
+
+// toRGB8 converts image to 8-bit sRGB
+func toRGB8(img *vips.ImageRef) (err error) {
+	if img.Interpretation() != vips.InterpretationSRGB || img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	if img.BandFormat() != vips.BandFormatUchar {
+		return img.Cast(vips.BandFormatUchar)
+	}
+	return
+}
+
+// rgbLUTBits bits per channel indexing rgb lookup tables within ushort
+const rgbLUTBits = 5
+
+// mapRGB maps colors of 8-bit sRGB image by fn through vips lookup table,
+// sampled at the centers of 5 bits per channel, alpha kept
+func mapRGB(ctx context.Context, img *vips.ImageRef, fn func(c color.NRGBA) color.NRGBA) (err error) {
+	const (
+		levels = 1 << rgbLUTBits
+		shift  = 8 - rgbLUTBits
+	)
+	// lookup table of colors by index of r, g, b bits
+	table := image.NewNRGBA(image.Rect(0, 0, levels*levels*levels, 1))
+	for i := 0; i < levels*levels*levels; i++ {
+		c := fn(color.NRGBA{
+			R: uint8(i/(levels*levels)<<shift + 1<<(shift-1)),
+			G: uint8(i/levels%levels<<shift + 1<<(shift-1)),
+			B: uint8(i%levels<<shift + 1<<(shift-1)),
+			A: 0xff,
+		})
+		table.Pix[i*4], table.Pix[i*4+1], table.Pix[i*4+2], table.Pix[i*4+3] = c.R, c.G, c.B, 0xff
+	}
+	var lut, bits *vips.ImageRef
+	if lut, err = newImageFromGo(ctx, table); err != nil {
+		return
+	}
+	shifts := image.NewGray(image.Rect(0, 0, 256, 1))
+	for i := range shifts.Pix {
+		shifts.Pix[i] = uint8(i >> shift)
+	}
+	if bits, err = newImageFromGo(ctx, shifts); err != nil {
+		return
+	}
+	rgb, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, rgb)
+	if err = rgb.ExtractBand(0, 3); err != nil {
+		return
+	}
+	if err = rgb.Maplut(bits); err != nil {
+		return
+	}
+	if err = rgb.Linear([]float64{levels * levels, levels, 1}, []float64{0, 0, 0}); err != nil {
+		return
+	}
+	var index *vips.ImageRef
+	if index, err = sumBands(ctx, rgb); err != nil {
+		return
+	}
+	if err = index.Cast(vips.BandFormatUshort); err != nil {
+		return
+	}
+	if err = index.Maplut(lut); err != nil {
+		return
+	}
+	if img.HasAlpha() {
+		alpha, err := img.Copy()
+		if err != nil {
+			return err
+		}
+		AddImageRef(ctx, alpha)
+		if err = alpha.ExtractBand(img.Bands()-1, 1); err != nil {
+			return err
+		}
+		if err = index.BandJoin(alpha); err != nil {
+			return err
+		}
+	}
+	return img.Insert(index, 0, 0, false, nil)
+}
+
+// newImageFromGo loads Go image as vips image by png
+func newImageFromGo(ctx context.Context, m image.Image) (*vips.ImageRef, error) {
+	var w bytes.Buffer
+	if err := png.Encode(&w, m); err != nil {
+		return nil, err
+	}
+	ref, err := vips.NewImageFromBuffer(w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	AddImageRef(ctx, ref)
+	return ref, nil
+}
+
+// toNRGBA exports image as Go image for pixel operations
+func toNRGBA(img *vips.ImageRef) (*image.NRGBA, error) {
+	buf, _, err := img.ExportPng(vips.NewPngExportParams())
+	if err != nil {
+		return nil, err
+	}
+	src, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := src.(*image.NRGBA); ok {
+		return m, nil
+	}
+	m := image.NewNRGBA(src.Bounds())
+	draw.Draw(m, m.Bounds(), src, src.Bounds().Min, draw.Src)
+	return m, nil
+}
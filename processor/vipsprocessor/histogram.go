@@ -0,0 +1,102 @@
+package vipsprocessor
+
+import (
+	"context"
+	"fmt"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"math"
+	"strings"
+)
+
+const (
+	histBins      = 64
+	histProbeSize = 256
+)
+
+// luminanceHistogram counts of Rec. 601 luma of the opaque pixels in bins
+func luminanceHistogram(m *image.NRGBA, bins int) []int {
+	hist := make([]int, bins)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := m.NRGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			l := 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+			hist[clampInt(int(l*float64(bins)/256), 0, bins-1)]++
+		}
+	}
+	return hist
+}
+
+// histOverlayHeight height of histogram overlay scaled by image width,
+// capped by half of the page height
+func histOverlayHeight(width, pageHeight int) int {
+	maxHeight := pageHeight / 2
+	if maxHeight < 1 {
+		maxHeight = 1
+	}
+	return clampInt(width/5, 1, maxHeight)
+}
+
+func histOverlay(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	var top = len(args) > 0 && args[0] == "top"
+	var w = img.Width()
+	var h = img.PageHeight()
+	// luminance on downsized copy
+	probe, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, probe)
+	if scale := histProbeSize / math.Max(float64(w), float64(img.Height())); scale < 1 {
+		if err = probe.Resize(scale, vips.KernelNearest); err != nil {
+			return
+		}
+	}
+	m, err := toNRGBA(probe)
+	if err != nil {
+		return
+	}
+	hist := luminanceHistogram(m, histBins)
+	var peak int
+	for _, n := range hist {
+		if n > peak {
+			peak = n
+		}
+	}
+	var oh = histOverlayHeight(w, h)
+	var oy = h - oh
+	if top {
+		oy = 0
+	}
+	var bars strings.Builder
+	var bw = float64(w) / histBins
+	for i, n := range hist {
+		if n == 0 {
+			continue
+		}
+		bh := float64(oh) * float64(n) / float64(peak)
+		_, _ = fmt.Fprintf(&bars, `<rect x="%f" y="%f" width="%f" height="%f" fill="#fff" fill-opacity="0.85"/>`,
+			float64(i)*bw, float64(oy+oh)-bh, bw, bh)
+	}
+	var overlay *vips.ImageRef
+	if overlay, err = vips.NewThumbnailFromBuffer([]byte(fmt.Sprintf(`
+		<svg viewBox="0 0 %d %d">
+			<rect x="0" y="%d" width="%d" height="%d" fill="#000" fill-opacity="0.5"/>
+			%s
+		</svg>
+	`, w, h, oy, w, oh, bars.String())), w, h, vips.InterestingNone); err != nil {
+		return
+	}
+	AddImageRef(ctx, overlay)
+	if n := GetPageN(ctx); n > 1 {
+		if err = overlay.Replicate(1, n); err != nil {
+			return
+		}
+	}
+	return img.Composite(overlay, vips.BlendModeOver, 0, 0)
+}
@@ -0,0 +1,139 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"math"
+	"strconv"
+)
+
+const (
+	horizonMaxAngle  = 45
+	horizonProbeSize = 300
+)
+
+// levelHorizon rotates the image to level the dominant horizontal line,
+// level_horizon([max_angle[, color]]) with correction capped by max_angle degrees, 10 by default
+func levelHorizon(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	maxAngle := 10.0
+	if len(args) > 0 && args[0] != "" {
+		maxAngle, _ = strconv.ParseFloat(args[0], 64)
+	}
+	if maxAngle = math.Min(maxAngle, horizonMaxAngle); !(maxAngle > 0) {
+		return
+	}
+	if img.Orientation() > 1 {
+		// detect horizon as displayed
+		if err = img.AutoRotate(); err != nil {
+			return
+		}
+	}
+	// detect on downsized copy
+	var probe *vips.ImageRef
+	if probe, err = img.Copy(); err != nil {
+		return
+	}
+	AddImageRef(ctx, probe)
+	if scale := horizonProbeSize / math.Max(float64(img.Width()), float64(img.PageHeight())); scale < 1 {
+		if err = probe.Resize(scale, vips.KernelLinear); err != nil {
+			return
+		}
+	}
+	var m *image.NRGBA
+	if m, err = toNRGBA(probe); err != nil {
+		return
+	}
+	angle := horizonAngle(m, maxAngle)
+	if angle == 0 {
+		return
+	}
+	w, h := img.Width(), img.PageHeight()
+	bg := &vips.ColorRGBA{}
+	if len(args) > 1 || !img.HasAlpha() {
+		c := &vips.Color{R: 0xff, G: 0xff, B: 0xff}
+		if len(args) > 1 {
+			c = getColor(img, args[1])
+		}
+		if img.HasAlpha() {
+			if err = img.Flatten(c); err != nil {
+				return
+			}
+		}
+		bg = &vips.ColorRGBA{R: c.R, G: c.G, B: c.B, A: 0xff}
+	}
+	if err = img.Similarity(1, angle, bg, 0, 0, 0, 0); err != nil {
+		return
+	}
+	// crop the rotated bounding box back to original dimensions
+	return img.ExtractArea((img.Width()-w)/2, (img.PageHeight()-h)/2, w, h)
+}
+
+// horizonAngle detects the tilt in degrees of the dominant horizontal line within maxAngle,
+// by the angle of sharpest projection profile of horizontal edges.
+// Positive if the line rises to the right, i.e. to be leveled by rotating clockwise
+func horizonAngle(m *image.NRGBA, maxAngle float64) float64 {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 || !(maxAngle > 0) {
+		return 0
+	}
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := m.PixOffset(b.Min.X+x, b.Min.Y+y)
+			lum[y*w+x] = 0.299*float64(m.Pix[i]) + 0.587*float64(m.Pix[i+1]) + 0.114*float64(m.Pix[i+2])
+		}
+	}
+	type edge struct{ x, y, weight float64 }
+	var edges []edge
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := lum[y*w+x+1] - lum[y*w+x-1]
+			gy := lum[(y+1)*w+x] - lum[(y-1)*w+x]
+			if math.Abs(gy) > math.Abs(gx) && math.Abs(gy) > 16 {
+				edges = append(edges, edge{float64(x), float64(y), math.Abs(gy)})
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return 0
+	}
+	// candidate angles of 0.1 degree steps
+	var (
+		n         = int(maxAngle * 10)
+		best      int
+		bestScore float64
+		bins      = make([]float64, h+2*w+2)
+	)
+	for i := -n; i <= n; i++ {
+		sin, cos := math.Sincos(float64(i) / 10 * math.Pi / 180)
+		for j := range bins {
+			bins[j] = 0
+		}
+		for _, e := range edges {
+			bins[int(math.Round(e.y*cos+e.x*sin))+w] += e.weight
+		}
+		var score float64
+		for _, v := range bins {
+			score += v * v
+		}
+		// prefer smaller correction on ties
+		if score > bestScore || (score == bestScore && abs(i) < abs(best)) {
+			best, bestScore = i, score
+		}
+	}
+	return float64(best) / 10
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+package vipsprocessor
+
+import "math"
+
+// jpegScans truncates a progressive JPEG after n scans,
+// resulting a valid but less detailed JPEG for progressive preview.
+// Returns the buffer unchanged if not a JPEG or has n or fewer scans
+func jpegScans(buf []byte, n int) []byte {
+	if n <= 0 || len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return buf
+	}
+	var scans int
+	i := 2
+	for i+4 <= len(buf) {
+		if buf[i] != 0xFF {
+			return buf
+		}
+		marker := buf[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xD9 {
+			// EOI
+			return buf
+		}
+		length := int(buf[i+2])<<8 | int(buf[i+3])
+		i += 2 + length
+		if marker != 0xDA {
+			continue
+		}
+		scans++
+		// skip entropy coded data until next non RST marker
+		for i+1 < len(buf) {
+			if buf[i] == 0xFF && buf[i+1] != 0x00 &&
+				(buf[i+1] < 0xD0 || buf[i+1] > 0xD7) {
+				break
+			}
+			i++
+		}
+		if scans == n && i+1 < len(buf) && buf[i+1] != 0xD9 {
+			// cut after the n-th scan and close with EOI
+			out := make([]byte, i+2)
+			copy(out, buf[:i])
+			out[i], out[i+1] = 0xFF, 0xD9
+			return out
+		}
+	}
+	return buf
+}
+
+// standard IJG luminance quantization table in zigzag order
+var jpegStdLuminance = [64]int{
+	16, 11, 12, 14, 12, 10, 16, 14,
+	13, 14, 18, 17, 16, 19, 24, 40,
+	26, 24, 22, 22, 24, 49, 35, 37,
+	29, 40, 58, 51, 61, 60, 57, 51,
+	56, 55, 64, 72, 92, 78, 64, 68,
+	87, 69, 55, 56, 80, 109, 81, 87,
+	95, 98, 103, 104, 103, 62, 77, 113,
+	121, 112, 100, 120, 92, 101, 103, 99,
+}
+
+// jpegQuality estimates the quality JPEG was encoded at,
+// by comparing luminance quantization table against the IJG standard.
+// Returns 0 if not a JPEG or quantization table not found
+func jpegQuality(buf []byte) int {
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return 0
+	}
+	i := 2
+	for i+4 <= len(buf) && buf[i] == 0xFF {
+		marker := buf[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := i + 2 + (int(buf[i+2])<<8 | int(buf[i+3]))
+		if end > len(buf) {
+			break
+		}
+		for j := i + 4; marker == 0xDB && j < end; {
+			precision, id := buf[j]>>4, buf[j]&0x0F
+			j++
+			size := 64
+			if precision == 1 {
+				size = 128
+			}
+			if j+size > end {
+				break
+			}
+			if id == 0 {
+				var sum, std, n int
+				for k := 0; k < 64; k++ {
+					v := int(buf[j+k])
+					if precision == 1 {
+						v = int(buf[j+k*2])<<8 | int(buf[j+k*2+1])
+					}
+					if v < 255 {
+						// neglect values clamped on low quality
+						sum += v
+						std += jpegStdLuminance[k]
+						n++
+					}
+				}
+				if n == 0 {
+					return 1
+				}
+				if sum <= n {
+					return 100
+				}
+				scale := float64(sum) * 100 / float64(std)
+				var q float64
+				if scale <= 100 {
+					q = (200 - scale) / 2
+				} else {
+					q = 5000 / scale
+				}
+				return int(math.Min(math.Max(math.Round(q), 1), 100))
+			}
+			j += size
+		}
+		i = end
+	}
+	return 0
+}
+
+// jpegCMYK detects 4-component CMYK or YCCK JPEG,
+// and whether Adobe APP14 marker is present, by which decoder inverts the CMYK values
+func jpegCMYK(buf []byte) (cmyk, adobe bool) {
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return
+	}
+	i := 2
+	for i+4 <= len(buf) && buf[i] == 0xFF {
+		marker := buf[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := i + 2 + (int(buf[i+2])<<8 | int(buf[i+3]))
+		if end > len(buf) {
+			break
+		}
+		switch {
+		case marker == 0xEE && end-i >= 9 && string(buf[i+4:i+9]) == "Adobe":
+			adobe = true
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			// SOF number of components
+			if i+9 < end {
+				cmyk = buf[i+9] == 4
+			}
+		}
+		i = end
+	}
+	return
+}
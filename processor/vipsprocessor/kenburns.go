@@ -0,0 +1,125 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"math"
+)
+
+const (
+	kenBurnsProbeSize = 200
+	kenBurnsMaxZoom   = 4
+)
+
+// kenBurnsRect crop rectangle in pixels of the processed image
+type kenBurnsRect struct {
+	Left   int `json:"left"`
+	Top    int `json:"top"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// kenBurnsPath pan-and-zoom crop path from start to end crop rectangles,
+// for downstream renderer of video or slideshow
+type kenBurnsPath struct {
+	Width  int          `json:"width"`
+	Height int          `json:"height"`
+	Start  kenBurnsRect `json:"start"`
+	End    kenBurnsRect `json:"end"`
+}
+
+// kenBurns computes crop path zooming from full image into the salient region by zoom factor
+func kenBurns(ctx context.Context, img *vips.ImageRef, zoom float64) (*kenBurnsPath, error) {
+	w, h := img.Width(), img.PageHeight()
+	probe, err := img.Copy()
+	if err != nil {
+		return nil, err
+	}
+	AddImageRef(ctx, probe)
+	if h < probe.Height() {
+		// first frame of animation
+		if err = probe.ExtractArea(0, 0, w, h); err != nil {
+			return nil, err
+		}
+	}
+	if scale := kenBurnsProbeSize / math.Max(float64(w), float64(h)); scale < 1 {
+		if err = probe.Resize(scale, vips.KernelLinear); err != nil {
+			return nil, err
+		}
+	}
+	m, err := toNRGBA(probe)
+	if err != nil {
+		return nil, err
+	}
+	pw, ph := m.Bounds().Dx(), m.Bounds().Dy()
+	cw := int(math.Round(float64(pw) / zoom))
+	ch := int(math.Round(float64(ph) / zoom))
+	left, top := salientWindow(m, cw, ch)
+	sx, sy := float64(w)/float64(pw), float64(h)/float64(ph)
+	end := kenBurnsRect{
+		Width:  int(math.Round(float64(w) / zoom)),
+		Height: int(math.Round(float64(h) / zoom)),
+	}
+	end.Left = clampInt(int(math.Round(float64(left)*sx)), 0, w-end.Width)
+	end.Top = clampInt(int(math.Round(float64(top)*sy)), 0, h-end.Height)
+	return &kenBurnsPath{
+		Width:  w,
+		Height: h,
+		Start:  kenBurnsRect{Width: w, Height: h},
+		End:    end,
+	}, nil
+}
+
+// salientWindow top left of the w x h window with highest edge energy,
+// weighted toward center so that flat images zoom into the middle
+func salientWindow(m *image.NRGBA, w, h int) (left, top int) {
+	b := m.Bounds()
+	mw, mh := b.Dx(), b.Dy()
+	if w >= mw || h >= mh || w <= 0 || h <= 0 {
+		return
+	}
+	lum := func(x, y int) float64 {
+		i := m.PixOffset(b.Min.X+x, b.Min.Y+y)
+		a := float64(m.Pix[i+3]) / 255
+		return (0.299*float64(m.Pix[i]) + 0.587*float64(m.Pix[i+1]) + 0.114*float64(m.Pix[i+2])) * a
+	}
+	// summed area table of gradient magnitude
+	sum := make([]float64, (mw+1)*(mh+1))
+	for y := 0; y < mh; y++ {
+		var row float64
+		for x := 0; x < mw; x++ {
+			var g float64
+			if x > 0 && y > 0 {
+				g = math.Abs(lum(x, y)-lum(x-1, y)) + math.Abs(lum(x, y)-lum(x, y-1))
+			}
+			row += g
+			sum[(y+1)*(mw+1)+x+1] = sum[y*(mw+1)+x+1] + row
+		}
+	}
+	left, top = (mw-w)/2, (mh-h)/2
+	var best = -1.0
+	for y := 0; y+h <= mh; y++ {
+		for x := 0; x+w <= mw; x++ {
+			e := sum[(y+h)*(mw+1)+x+w] - sum[y*(mw+1)+x+w] - sum[(y+h)*(mw+1)+x] + sum[y*(mw+1)+x]
+			// slight center bias breaking ties
+			dx := float64(x) - float64(mw-w)/2
+			dy := float64(y) - float64(mh-h)/2
+			e -= math.Sqrt(dx*dx + dy*dy)
+			if e > best {
+				best, left, top = e, x, y
+			}
+		}
+	}
+	return
+}
+
+func clampInt(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
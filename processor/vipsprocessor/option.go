@@ -2,6 +2,7 @@ package vipsprocessor
 
 import (
 	"go.uber.org/zap"
+	"image/color"
 	"strings"
 )
 
@@ -41,6 +42,14 @@ func WithMaxFilterOps(num int) Option {
 	}
 }
 
+func WithMaxFilterArgLength(num int) Option {
+	return func(v *VipsProcessor) {
+		if num > 0 {
+			v.MaxFilterArgLength = num
+		}
+	}
+}
+
 func WithMaxAnimationFrames(num int) Option {
 	return func(v *VipsProcessor) {
 		if num != 0 {
@@ -49,6 +58,103 @@ func WithMaxAnimationFrames(num int) Option {
 	}
 }
 
+func WithMaxAnimationFramesAction(action string) Option {
+	return func(v *VipsProcessor) {
+		switch action {
+		case AnimationFramesTruncate, AnimationFramesStill, AnimationFramesReject:
+			v.MaxAnimationFramesAction = action
+		}
+	}
+}
+
+func WithCMYKInvert(mode string) Option {
+	return func(v *VipsProcessor) {
+		switch mode {
+		case CMYKInvertAuto, CMYKInvertAlways, CMYKInvertNever:
+			v.CMYKInvert = mode
+		}
+	}
+}
+
+func WithFFmpegPath(path string) Option {
+	return func(v *VipsProcessor) {
+		v.FFmpegPath = path
+	}
+}
+
+func WithAllowTruncated(allow bool) Option {
+	return func(v *VipsProcessor) {
+		v.AllowTruncated = allow
+	}
+}
+
+func WithDisablePassthrough(disable bool) Option {
+	return func(v *VipsProcessor) {
+		v.DisablePassthrough = disable
+	}
+}
+
+func WithFallbackFormats(formats ...string) Option {
+	return func(v *VipsProcessor) {
+		for _, raw := range formats {
+			splits := strings.Split(raw, ",")
+			for _, name := range splits {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if typ, ok := imageTypeMap[name]; ok {
+					v.FallbackFormats = append(v.FallbackFormats, typ)
+				}
+			}
+		}
+	}
+}
+
+func WithDefaultFormat(format string) Option {
+	return func(v *VipsProcessor) {
+		if typ, ok := imageTypeMap[strings.ToLower(strings.TrimSpace(format))]; ok {
+			v.DefaultFormat = typ
+		}
+	}
+}
+
+func WithPalette(name string, colors ...string) Option {
+	return func(v *VipsProcessor) {
+		var palette color.Palette
+		for _, raw := range colors {
+			for _, c := range strings.Split(raw, ",") {
+				c = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c), "#"))
+				if c == "" {
+					continue
+				}
+				vc := getColor(nil, c)
+				palette = append(palette, color.RGBA{R: vc.R, G: vc.G, B: vc.B, A: 0xff})
+			}
+		}
+		if name == "" || len(palette) == 0 {
+			return
+		}
+		if v.Palettes == nil {
+			v.Palettes = map[string]color.Palette{}
+		}
+		v.Palettes[name] = palette
+	}
+}
+
+func WithPalettes(palettes string) Option {
+	return func(v *VipsProcessor) {
+		for _, raw := range strings.Split(palettes, ";") {
+			if splits := strings.SplitN(raw, ":", 2); len(splits) == 2 {
+				WithPalette(strings.TrimSpace(splits[0]), splits[1])(v)
+			}
+		}
+	}
+}
+
+func WithPartialAnimation(enabled bool) Option {
+	return func(v *VipsProcessor) {
+		v.PartialAnimation = enabled
+	}
+}
+
 func WithConcurrency(num int) Option {
 	return func(v *VipsProcessor) {
 		if num != 0 {
@@ -81,6 +187,12 @@ func WithMaxCacheMem(num int) Option {
 	}
 }
 
+func WithNoCache(noCache bool) Option {
+	return func(v *VipsProcessor) {
+		v.NoCache = noCache
+	}
+}
+
 func WithLogger(logger *zap.Logger) Option {
 	return func(v *VipsProcessor) {
 		if logger != nil {
@@ -110,3 +222,27 @@ func WithMaxHeight(height int) Option {
 		}
 	}
 }
+
+func WithTwoStepDownscale(ratio float64) Option {
+	return func(v *VipsProcessor) {
+		if ratio > 1 {
+			v.TwoStepDownscale = ratio
+		}
+	}
+}
+
+func WithMaxResultWidth(width int) Option {
+	return func(v *VipsProcessor) {
+		if width > 0 {
+			v.MaxResultWidth = width
+		}
+	}
+}
+
+func WithMaxResultHeight(height int) Option {
+	return func(v *VipsProcessor) {
+		if height > 0 {
+			v.MaxResultHeight = height
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package vipsprocessor
 
 import (
+	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/stretchr/testify/assert"
+	"image/color"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 )
@@ -11,30 +15,144 @@ func TestWithOption(t *testing.T) {
 		vips := New(
 			WithConcurrency(2),
 			WithMaxFilterOps(167),
+			WithMaxFilterArgLength(2048),
 			WithMaxCacheSize(500),
 			WithMaxCacheMem(501),
 			WithMaxCacheFiles(10),
 			WithMaxWidth(999),
 			WithMaxHeight(998),
+			WithMaxResultWidth(500),
+			WithMaxResultHeight(400),
+			WithTwoStepDownscale(4),
 			WithDebug(true),
 			WithMaxAnimationFrames(3),
+			WithMaxAnimationFramesAction("still"),
+			WithFFmpegPath("/usr/bin/ffmpeg"),
+			WithAllowTruncated(true),
+			WithPartialAnimation(true),
 			WithDisableFilters("rgb", "fill, watermark"),
 		)
 		assert.Equal(t, 2, vips.Concurrency)
 		assert.Equal(t, 167, vips.MaxFilterOps)
+		assert.Equal(t, 2048, vips.MaxFilterArgLength)
 		assert.Equal(t, 500, vips.MaxCacheSize)
 		assert.Equal(t, 501, vips.MaxCacheMem)
 		assert.Equal(t, 10, vips.MaxCacheFiles)
 		assert.Equal(t, 999, vips.MaxWidth)
 		assert.Equal(t, 998, vips.MaxHeight)
+		assert.Equal(t, 500, vips.MaxResultWidth)
+		assert.Equal(t, 400, vips.MaxResultHeight)
+		assert.Equal(t, float64(4), vips.TwoStepDownscale)
 		assert.Equal(t, 3, vips.MaxAnimationFrames)
+		assert.Equal(t, AnimationFramesStill, vips.MaxAnimationFramesAction)
+		assert.Equal(t, "/usr/bin/ffmpeg", vips.FFmpegPath)
+		assert.True(t, vips.AllowTruncated)
+		assert.True(t, vips.PartialAnimation)
 		assert.Equal(t, []string{"rgb", "fill", "watermark"}, vips.DisableFilters)
 
 	})
+	t.Run("no cache", func(t *testing.T) {
+		v := New(
+			WithConcurrency(2),
+			WithMaxCacheSize(500),
+			WithMaxCacheMem(501),
+			WithMaxCacheFiles(10),
+			WithNoCache(true),
+		)
+		assert.True(t, v.NoCache)
+		config := v.vipsConfig()
+		assert.Equal(t, 0, config.MaxCacheSize)
+		assert.Equal(t, 0, config.MaxCacheMem)
+		assert.Equal(t, 0, config.MaxCacheFiles)
+		assert.Equal(t, 2, config.ConcurrencyLevel)
+
+		config = New(WithMaxCacheSize(500), WithMaxCacheMem(501)).vipsConfig()
+		assert.Equal(t, 500, config.MaxCacheSize)
+		assert.Equal(t, 501, config.MaxCacheMem)
+	})
 	t.Run("edge options", func(t *testing.T) {
 		vips := New(
 			WithConcurrency(-1),
+			WithMaxAnimationFramesAction("foo"),
 		)
-		assert.Equal(t, runtime.NumCPU(), vips.Concurrency)
+		assert.Equal(t, availableCPU(), vips.Concurrency)
+		assert.Equal(t, AnimationFramesTruncate, vips.MaxAnimationFramesAction)
+	})
+	t.Run("default format", func(t *testing.T) {
+		assert.Equal(t, vips.ImageTypeWEBP, New(WithDefaultFormat("webp")).DefaultFormat)
+		assert.Equal(t, vips.ImageTypeUnknown, New(WithDefaultFormat("foo")).DefaultFormat)
+	})
+	t.Run("palettes", func(t *testing.T) {
+		v := New(
+			WithPalette("mono", "000", "#fff"),
+			WithPalettes("brand: red,00ff00,0000ff; foo:;bar"),
+		)
+		assert.Equal(t, color.Palette{
+			color.RGBA{A: 0xff}, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+		}, v.Palettes["mono"])
+		assert.Equal(t, color.Palette{
+			color.RGBA{R: 0xff, A: 0xff}, color.RGBA{G: 0xff, A: 0xff}, color.RGBA{B: 0xff, A: 0xff},
+		}, v.Palettes["brand"])
+		assert.Len(t, v.Palettes, 2)
+	})
+	t.Run("fallback formats", func(t *testing.T) {
+		v := New(WithFallbackFormats("avif, webp", "foo,JPEG"))
+		assert.Equal(t, []vips.ImageType{
+			vips.ImageTypeAVIF, vips.ImageTypeWEBP, vips.ImageTypeJPEG,
+		}, v.FallbackFormats)
+	})
+}
+
+func TestAvailableCPU(t *testing.T) {
+	write := func(t *testing.T, root string, files map[string]string) {
+		for name, content := range files {
+			name = filepath.Join(root, name)
+			assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+			assert.NoError(t, os.WriteFile(name, []byte(content), 0644))
+		}
+	}
+	tests := []struct {
+		name  string
+		files map[string]string
+		quota float64
+		ok    bool
+	}{
+		{"no cgroup", nil, 0, false},
+		{"v2 quota", map[string]string{"cpu.max": "150000 100000\n"}, 1.5, true},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000\n"}, 0, false},
+		{"v2 invalid", map[string]string{"cpu.max": "foo\n"}, 0, false},
+		{"v1 quota", map[string]string{
+			"cpu/cpu.cfs_quota_us":  "200000\n",
+			"cpu/cpu.cfs_period_us": "100000\n",
+		}, 2, true},
+		{"v1 unlimited", map[string]string{
+			"cpu/cpu.cfs_quota_us":  "-1\n",
+			"cpu/cpu.cfs_period_us": "100000\n",
+		}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			write(t, root, tt.files)
+			quota, ok := cgroupCPUQuota(root)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.quota, quota)
+		})
+	}
+	t.Run("constrained", func(t *testing.T) {
+		defer func(root string) { cgroupRoot = root }(cgroupRoot)
+		cgroupRoot = t.TempDir()
+		write(t, cgroupRoot, map[string]string{"cpu.max": "50000 100000"})
+		assert.Equal(t, 1, availableCPU())
+		assert.Equal(t, 1, New(WithConcurrency(-1)).Concurrency)
+	})
+	t.Run("unconstrained", func(t *testing.T) {
+		defer func(root string) { cgroupRoot = root }(cgroupRoot)
+		cgroupRoot = t.TempDir()
+		n := runtime.NumCPU()
+		if p := runtime.GOMAXPROCS(0); p < n {
+			n = p
+		}
+		assert.Equal(t, n, availableCPU())
 	})
 }
@@ -0,0 +1,81 @@
+package vipsprocessor
+
+import (
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// passthroughTypes source formats that can be responded as-is,
+// excluding SVG that should not be served unsanitized
+var passthroughTypes = map[vips.ImageType]bool{
+	vips.ImageTypeJPEG: true,
+	vips.ImageTypePNG:  true,
+	vips.ImageTypeGIF:  true,
+	vips.ImageTypeWEBP: true,
+}
+
+// isPassthroughParams no transformation by params other than
+// dimensions and format, which are compared against the source
+func isPassthroughParams(p imagorpath.Params) bool {
+	if p.Meta || p.Trim || p.HFlip || p.VFlip ||
+		p.CropLeft > 0 || p.CropTop > 0 || p.CropRight > 0 || p.CropBottom > 0 ||
+		p.PaddingLeft > 0 || p.PaddingTop > 0 || p.PaddingRight > 0 || p.PaddingBottom > 0 {
+		return false
+	}
+	for _, filter := range p.Filters {
+		if filter.Name != "format" {
+			return false
+		}
+	}
+	return true
+}
+
+// passthrough returns source blob unmodified if the output would be
+// of the same format and dimensions, without re-encoding
+func (v *VipsProcessor) passthrough(
+	blob *imagor.Blob, p imagorpath.Params, format vips.ImageType, maxN int,
+) (*imagor.Blob, bool) {
+	if v.DisablePassthrough || !isPassthroughParams(p) {
+		return nil, false
+	}
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return nil, false
+	}
+	typ := vips.DetermineImageType(buf)
+	if !passthroughTypes[typ] || (format != vips.ImageTypeUnknown && format != typ) {
+		return nil, false
+	}
+	if isTruncated(buf) {
+		// best-effort decode of allowed truncated image instead of broken source
+		return nil, false
+	}
+	// header only as pixels are loaded lazily
+	params := vips.NewImportParams()
+	params.NumPages.Set(-1)
+	img, err := vips.LoadImageFromBuffer(buf, params)
+	if err != nil {
+		return nil, false
+	}
+	defer img.Close()
+	w, h := img.Width(), img.PageHeight()
+	if o := img.Orientation(); o > 1 {
+		// rotation by EXIF orientation
+		return nil, false
+	}
+	if (p.Width != 0 && p.Width != w) || (p.Height != 0 && p.Height != h) ||
+		w > v.MaxWidth || h > v.MaxHeight ||
+		(v.MaxResultWidth > 0 && w > v.MaxResultWidth) ||
+		(v.MaxResultHeight > 0 && h > v.MaxResultHeight) ||
+		(maxN > 0 && img.Height()/h > maxN) {
+		return nil, false
+	}
+	f := vips.ImageTypes[typ]
+	return imagor.NewBlobBytesWithMeta(buf, &imagor.Meta{
+		Format:      f,
+		ContentType: imageMimeTypeMap[f],
+		Width:       w,
+		Height:      h,
+	}), true
+}
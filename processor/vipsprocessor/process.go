@@ -8,6 +8,8 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/image/colornames"
 	"image/color"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -21,6 +23,13 @@ func (v *VipsProcessor) process(
 		}
 	}
 	if p.CropBottom-p.CropTop > 0 || p.CropRight-p.CropLeft > 0 {
+		if img.Orientation() > 1 {
+			// crop coordinates of the image as displayed
+			if err := img.AutoRotate(); err != nil {
+				return err
+			}
+		}
+		// crop first within image bounds, then resize to the requested dimensions
 		cropRight := p.CropRight
 		cropBottom := p.CropBottom
 		if w := img.Width(); cropRight > w {
@@ -29,11 +38,13 @@ func (v *VipsProcessor) process(
 		if h := img.PageHeight(); cropBottom > h {
 			cropBottom = h
 		}
-		if err := img.ExtractArea(
-			p.CropLeft, p.CropTop,
-			cropRight-p.CropLeft, cropBottom-p.CropTop,
-		); err != nil {
-			return err
+		if cropRight > p.CropLeft && cropBottom > p.CropTop {
+			if err := img.ExtractArea(
+				p.CropLeft, p.CropTop,
+				cropRight-p.CropLeft, cropBottom-p.CropTop,
+			); err != nil {
+				return err
+			}
 		}
 	}
 	var (
@@ -69,6 +80,12 @@ func (v *VipsProcessor) process(
 					return err
 				}
 			}
+		} else if biasX, biasY, ok := cropBias(p.Filters); ok {
+			if upscale || w < img.Width() || h < img.PageHeight() {
+				if err := v.thumbnailWithBias(img, w, h, biasX, biasY, vips.SizeBoth); err != nil {
+					return err
+				}
+			}
 		} else if upscale || w < img.Width() || h < img.PageHeight() {
 			interest := vips.InterestingCentre
 			if p.Smart {
@@ -97,7 +114,7 @@ func (v *VipsProcessor) process(
 		}
 	}
 	if p.VFlip {
-		if err := img.Flip(vips.DirectionVertical); err != nil {
+		if err := flipVertical(ctx, img); err != nil {
 			return err
 		}
 	}
@@ -134,6 +151,31 @@ func (v *VipsProcessor) process(
 	return nil
 }
 
+// cropBias parses crop_bias(x,y) filter, fractional shift of crop window from center
+// clamped to -1 and 1 for each axis
+func cropBias(filters imagorpath.Filters) (x, y float64, ok bool) {
+	for _, filter := range filters {
+		if filter.Name != "crop_bias" {
+			continue
+		}
+		args := strings.Split(filter.Args, ",")
+		x = parseBias(args[0])
+		if len(args) > 1 {
+			y = parseBias(args[1])
+		}
+		return x, y, true
+	}
+	return
+}
+
+func parseBias(s string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0
+	}
+	return math.Max(-1, math.Min(1, f))
+}
+
 func trim(ctx context.Context, img *vips.ImageRef, pos string, tolerance int) error {
 	if IsAnimated(ctx) {
 		// skip animation support
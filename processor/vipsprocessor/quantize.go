@@ -0,0 +1,197 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+)
+
+const maxQuantizeColors = 256
+
+// perceptual weights of rgb channels for color distance
+var quantizeWeights = [3]int{2, 4, 3}
+
+// quantizeProbe max dimension of the downsized probe sampling colors for the palette
+const quantizeProbe = 256
+
+// quantize reduces image to n colors by median cut, keeping the image full color and alpha.
+// Palette is sampled from a downsized probe, and mapped by vips lookup table
+func quantize(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if len(args) == 0 || IsAnimated(ctx) {
+		// skip animation support
+		return
+	}
+	n, _ := strconv.Atoi(args[0])
+	if n <= 0 {
+		return
+	}
+	if n > maxQuantizeColors {
+		n = maxQuantizeColors
+	}
+	probe, err := img.Copy()
+	if err != nil {
+		return
+	}
+	AddImageRef(ctx, probe)
+	size := img.Width()
+	if img.Height() > size {
+		size = img.Height()
+	}
+	downsized := size > quantizeProbe
+	if downsized {
+		// nearest neighbour sampling colors of the image only
+		if err = probe.Resize(float64(quantizeProbe)/float64(size), vips.KernelNearest); err != nil {
+			return
+		}
+	}
+	var m *image.NRGBA
+	if m, err = toNRGBA(probe); err != nil {
+		return
+	}
+	palette := medianCut(m, n)
+	if palette == nil {
+		if !downsized {
+			// image already within n colors
+			return
+		}
+		palette = distinctColors(m)
+	}
+	if err = toRGB8(img); err != nil {
+		return
+	}
+	return mapRGB(ctx, img, func(c color.NRGBA) color.NRGBA {
+		return nearestColor(palette, c)
+	})
+}
+
+type colorCount struct {
+	rgb [3]uint8
+	n   int
+}
+
+type colorBox []colorCount
+
+// channel of widest weighted range and the range
+func (b colorBox) widest() (ch, width int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, cc := range b {
+			if v := int(cc.rgb[c]); v < lo {
+				lo = v
+			}
+			if v := int(cc.rgb[c]); v > hi {
+				hi = v
+			}
+		}
+		if w := (hi - lo) * quantizeWeights[c]; w > width {
+			ch, width = c, w
+		}
+	}
+	return
+}
+
+func (b colorBox) mean() color.NRGBA {
+	var sum [3]int
+	var total int
+	for _, cc := range b {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(cc.rgb[c]) * cc.n
+		}
+		total += cc.n
+	}
+	return color.NRGBA{
+		R: uint8((sum[0] + total/2) / total),
+		G: uint8((sum[1] + total/2) / total),
+		B: uint8((sum[2] + total/2) / total),
+		A: 0xff,
+	}
+}
+
+// medianCut palette of at most n colors of the image rgb, nil if image already within n colors
+func medianCut(m *image.NRGBA, n int) []color.NRGBA {
+	counts := map[[3]uint8]int{}
+	for i := 0; i+3 < len(m.Pix); i += 4 {
+		counts[[3]uint8{m.Pix[i], m.Pix[i+1], m.Pix[i+2]}]++
+	}
+	if len(counts) <= n {
+		return nil
+	}
+	box := make(colorBox, 0, len(counts))
+	for rgb, cnt := range counts {
+		box = append(box, colorCount{rgb, cnt})
+	}
+	boxes := []colorBox{box}
+	for len(boxes) < n {
+		// split the box of widest range weighted by pixel count
+		split, score := -1, 0
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			_, width := b.widest()
+			var total int
+			for _, cc := range b {
+				total += cc.n
+			}
+			if s := width * total; s > score {
+				split, score = i, s
+			}
+		}
+		if split < 0 {
+			break
+		}
+		b := boxes[split]
+		ch, _ := b.widest()
+		sort.Slice(b, func(i, j int) bool { return b[i].rgb[ch] < b[j].rgb[ch] })
+		var total, acc int
+		for _, cc := range b {
+			total += cc.n
+		}
+		// median by pixel count, keeping both halves non-empty
+		mid := 1
+		for i, cc := range b[:len(b)-1] {
+			if acc += cc.n; acc*2 >= total {
+				mid = i + 1
+				break
+			}
+		}
+		boxes[split] = b[:mid]
+		boxes = append(boxes, b[mid:])
+	}
+	palette := make([]color.NRGBA, len(boxes))
+	for i, b := range boxes {
+		palette[i] = b.mean()
+	}
+	return palette
+}
+
+// distinctColors opaque rgb colors of the image
+func distinctColors(m *image.NRGBA) (colors []color.NRGBA) {
+	seen := map[color.NRGBA]bool{}
+	for i := 0; i+3 < len(m.Pix); i += 4 {
+		c := color.NRGBA{R: m.Pix[i], G: m.Pix[i+1], B: m.Pix[i+2], A: 0xff}
+		if !seen[c] {
+			seen[c] = true
+			colors = append(colors, c)
+		}
+	}
+	return
+}
+
+func nearestColor(palette []color.NRGBA, c color.NRGBA) (nearest color.NRGBA) {
+	best := -1
+	for _, p := range palette {
+		dr := int(p.R) - int(c.R)
+		dg := int(p.G) - int(c.G)
+		db := int(p.B) - int(c.B)
+		d := quantizeWeights[0]*dr*dr + quantizeWeights[1]*dg*dg + quantizeWeights[2]*db*db
+		if best < 0 || d < best {
+			best, nearest = d, p
+		}
+	}
+	return
+}
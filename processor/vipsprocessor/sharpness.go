@@ -0,0 +1,80 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image"
+	"math"
+)
+
+const sharpnessProbeSize = 512
+
+// sharpnessScore sharpness of the processed image, for rejecting blurry images
+type sharpnessScore struct {
+	Sharpness float64 `json:"sharpness"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+}
+
+// laplacianVariance variance of the 3x3 Laplacian of luma, higher for sharper image
+func laplacianVariance(m *image.NRGBA) float64 {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return 0
+	}
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := m.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			luma[y*w+x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		}
+	}
+	var sum, sumSq float64
+	var n int
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+// sharpness computes variance of Laplacian on grayscale of the first frame,
+// downsized such that scores are comparable across image dimensions
+func sharpness(ctx context.Context, img *vips.ImageRef) (*sharpnessScore, error) {
+	w, h := img.Width(), img.PageHeight()
+	probe, err := img.Copy()
+	if err != nil {
+		return nil, err
+	}
+	AddImageRef(ctx, probe)
+	if h < probe.Height() {
+		// first frame of animation
+		if err = probe.ExtractArea(0, 0, w, h); err != nil {
+			return nil, err
+		}
+	}
+	if scale := sharpnessProbeSize / math.Max(float64(w), float64(h)); scale < 1 {
+		if err = probe.Resize(scale, vips.KernelLinear); err != nil {
+			return nil, err
+		}
+	}
+	if err = probe.ToColorSpace(vips.InterpretationBW); err != nil {
+		return nil, err
+	}
+	m, err := toNRGBA(probe)
+	if err != nil {
+		return nil, err
+	}
+	return &sharpnessScore{
+		Sharpness: math.Round(laplacianVariance(m)*100) / 100,
+		Width:     w,
+		Height:    h,
+	}, nil
+}
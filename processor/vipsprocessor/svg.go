@@ -0,0 +1,31 @@
+package vipsprocessor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/cshum/imagor"
+	"strconv"
+)
+
+// svgLQIP wraps image as base64 data URI of a blurred SVG placeholder
+func svgLQIP(buf []byte, meta *imagor.Meta, blur float64) ([]byte, *imagor.Meta) {
+	w, h := meta.Width, meta.Height
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" `+
+		`width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none">`+
+		`<filter id="b" color-interpolation-filters="sRGB">`+
+		`<feGaussianBlur stdDeviation="%s"/>`+
+		`<feComponentTransfer><feFuncA type="discrete" tableValues="1 1"/></feComponentTransfer>`+
+		`</filter>`+
+		`<image filter="url(#b)" x="0" y="0" width="%d" height="%d" preserveAspectRatio="none" `+
+		`href="data:%s;base64,%s"/>`+
+		`</svg>`,
+		w, h, w, h, strconv.FormatFloat(blur, 'f', -1, 64), w, h,
+		meta.ContentType, base64.StdEncoding.EncodeToString(buf))
+	return []byte(svg), &imagor.Meta{
+		Format:      "svg",
+		ContentType: "image/svg+xml",
+		Width:       w,
+		Height:      h,
+		Orientation: meta.Orientation,
+	}
+}
@@ -0,0 +1,103 @@
+package vipsprocessor
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/davidbyttow/govips/v2/vips"
+	"html"
+	"net/url"
+	"strconv"
+)
+
+const (
+	textBoxDefaultSize = 16
+	textBoxMaxSize     = 1000
+)
+
+// textBox renders text wrapped within a bounding box on the image,
+// text_box(text, x, y[, max_width[, color[, size]]]) e.g. text_box(Some%20long%20text,40,40,600,ffffff,24).
+// Lines are wrapped at word boundaries of max_width, which defaults to and is capped at the right edge of the image.
+// Text overflowing the image is clipped
+func textBox(ctx context.Context, img *vips.ImageRef, _ imagor.LoadFunc, args ...string) (err error) {
+	if IsAnimated(ctx) || len(args) < 3 {
+		// skip animation support
+		return
+	}
+	text := args[0]
+	if unescape, e := url.QueryUnescape(text); e == nil {
+		text = unescape
+	}
+	if text == "" {
+		return
+	}
+	w, h := img.Width(), img.Height()
+	x, _ := strconv.Atoi(args[1])
+	y, _ := strconv.Atoi(args[2])
+	x, y = clampInt(x, 0, w-1), clampInt(y, 0, h-1)
+	maxWidth := w - x
+	if len(args) > 3 {
+		if n, _ := strconv.Atoi(args[3]); n > 0 && n < maxWidth {
+			maxWidth = n
+		}
+	}
+	c := &vips.Color{}
+	if len(args) > 4 && args[4] != "" {
+		c = getColor(img, args[4])
+	}
+	size := textBoxDefaultSize
+	if len(args) > 5 {
+		if n, _ := strconv.Atoi(args[5]); n > 0 {
+			size = clampInt(n, 1, textBoxMaxSize)
+		}
+	}
+	params := &vips.LabelParams{
+		// escaped as plain text in place of Pango markup
+		Text:    html.EscapeString(text),
+		Font:    "sans " + strconv.Itoa(size),
+		Width:   vips.Scalar{Value: float64(maxWidth)},
+		OffsetX: vips.Scalar{Value: float64(x)},
+		OffsetY: vips.Scalar{Value: float64(y)},
+		Opacity: 1,
+		Color:   *c,
+	}
+	if img.Bands() < 3 {
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return
+		}
+	}
+	if img.HasAlpha() {
+		// label on colour bands, and the same label opaque on alpha band
+		alpha, err := img.Copy()
+		if err != nil {
+			return err
+		}
+		AddImageRef(ctx, alpha)
+		if err = alpha.ExtractBand(img.Bands()-1, 1); err != nil {
+			return err
+		}
+		if err = img.ExtractBand(0, img.Bands()-1); err != nil {
+			return err
+		}
+		if err = img.Label(params); err != nil {
+			return err
+		}
+		opaque := *params
+		opaque.Color = vips.Color{R: 255, G: 255, B: 255}
+		if err = alpha.Label(&opaque); err != nil {
+			return err
+		}
+		if err = alpha.ExtractBand(0, 1); err != nil {
+			return err
+		}
+		if err = img.BandJoin(alpha); err != nil {
+			return err
+		}
+	} else if err = img.Label(params); err != nil {
+		return
+	}
+	if img.Width() > w || img.Height() > h {
+		// label enlarges image when text overflows
+		return img.ExtractArea(0, 0, w, h)
+	}
+	return
+}
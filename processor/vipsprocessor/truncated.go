@@ -0,0 +1,76 @@
+package vipsprocessor
+
+import (
+	"bytes"
+	"github.com/davidbyttow/govips/v2/vips"
+	"net/http"
+	"strings"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+var pngIEND = []byte("IEND")
+
+// isTruncated detects JPEG and PNG with incomplete data,
+// i.e. JPEG without EOI marker and PNG without IEND chunk.
+// Trailing bytes after EOI or IEND are not considered truncated
+func isTruncated(buf []byte) bool {
+	if bytes.HasPrefix(buf, pngHeader) {
+		return !bytes.Contains(buf[len(pngHeader):], pngIEND)
+	}
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return false
+	}
+	i := 2
+	for i+1 < len(buf) {
+		if buf[i] != 0xFF {
+			// extraneous byte before marker, tolerated as of libjpeg
+			i++
+			continue
+		}
+		marker := buf[i+1]
+		if marker == 0xD9 {
+			return false
+		}
+		if marker == 0xFF || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			// fill byte or standalone marker
+			i++
+			if marker != 0xFF {
+				i++
+			}
+			continue
+		}
+		if i+4 > len(buf) {
+			return true
+		}
+		i += 2 + (int(buf[i+2])<<8 | int(buf[i+3]))
+		if marker != 0xDA {
+			continue
+		}
+		// skip entropy coded data until next non RST marker
+		for i+1 < len(buf) {
+			if buf[i] == 0xFF && buf[i+1] != 0x00 &&
+				(buf[i+1] < 0xD0 || buf[i+1] > 0xD7) {
+				break
+			}
+			i++
+		}
+	}
+	return true
+}
+
+// isNonImage detects zero-length or plain text source such as HTML error page,
+// which is clearly not an image. XML is left to vips for SVG beyond the sniffed header
+func isNonImage(buf []byte) bool {
+	if len(buf) == 0 {
+		return true
+	}
+	if vips.DetermineImageType(buf) != vips.ImageTypeUnknown {
+		return false
+	}
+	if len(buf) > 1 && buf[0] == 'P' && buf[1] >= '1' && buf[1] <= '3' {
+		// ASCII Netpbm loadable by vips
+		return false
+	}
+	contentType := http.DetectContentType(buf)
+	return strings.HasPrefix(contentType, "text/plain") || strings.HasPrefix(contentType, "text/html")
+}
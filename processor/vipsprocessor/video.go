@@ -0,0 +1,78 @@
+package vipsprocessor
+
+import (
+	"bytes"
+	"context"
+	"github.com/cshum/imagor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var errVideoFrame = imagor.NewError("unable to extract video frame", http.StatusNotAcceptable)
+
+var ftypHeader = []byte("ftyp")
+var ebmlHeader = []byte("\x1A\x45\xDF\xA3")
+
+// isVideo detects mp4/mov (ISO base media) and webm/mkv (EBML) containers
+func isVideo(buf []byte) bool {
+	if len(buf) > 12 && bytes.Equal(buf[4:8], ftypHeader) {
+		// exclude still image formats sharing the same container
+		switch string(buf[8:12]) {
+		case "avif", "avis", "heic", "heix", "mif1", "msf1":
+			return false
+		}
+		return true
+	}
+	return bytes.HasPrefix(buf, ebmlHeader)
+}
+
+// videoFrame extracts a frame at position seek seconds as png using ffmpeg
+func (v *VipsProcessor) videoFrame(
+	ctx context.Context, blob *imagor.Blob, seek float64,
+) (*imagor.Blob, error) {
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	// write to temp file as mp4 may not be seekable from pipe
+	file, err := ioutil.TempFile("", "imagor-video-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+	if _, err = file.Write(buf); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if err = file.Close(); err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.FFmpegPath,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", strconv.FormatFloat(seek, 'f', -1, 64),
+		"-i", file.Name(),
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "pipe:1")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		// ffmpeg output logged only, as it may disclose server paths and versions
+		v.Logger.Warn("ffmpeg", zap.String("log", strings.TrimSpace(stderr.String())), zap.Error(err))
+		return nil, errVideoFrame
+	}
+	if stdout.Len() == 0 {
+		// seek beyond duration yields no frame
+		return nil, imagor.ErrNotFound
+	}
+	return imagor.NewBlobBytes(stdout.Bytes()), nil
+}
@@ -2,11 +2,14 @@ package vipsprocessor
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/cshum/imagor"
 	"github.com/cshum/imagor/imagorpath"
 	"github.com/davidbyttow/govips/v2/vips"
 	"go.uber.org/zap"
-	"runtime"
+	"image/color"
+	"math"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -15,60 +18,131 @@ type FilterFunc func(ctx context.Context, img *vips.ImageRef, load imagor.LoadFu
 
 type FilterMap map[string]FilterFunc
 
+const (
+	// AnimationFramesTruncate processes animation up to MaxAnimationFrames
+	AnimationFramesTruncate = "truncate"
+	// AnimationFramesStill processes first frame only if animation exceeds MaxAnimationFrames
+	AnimationFramesStill = "still"
+	// AnimationFramesReject rejects animation that exceeds MaxAnimationFrames
+	AnimationFramesReject = "reject"
+)
+
+const (
+	// CMYKInvertAuto inverts CMYK JPEG values if Adobe APP14 marker present, as decoded by libjpeg
+	CMYKInvertAuto = "auto"
+	// CMYKInvertAlways inverts CMYK JPEG values regardless of Adobe APP14 marker
+	CMYKInvertAlways = "always"
+	// CMYKInvertNever never inverts CMYK JPEG values regardless of Adobe APP14 marker
+	CMYKInvertNever = "never"
+)
+
 type VipsProcessor struct {
-	Filters            FilterMap
-	DisableBlur        bool
-	DisableFilters     []string
-	MaxFilterOps       int
-	Logger             *zap.Logger
-	Concurrency        int
-	MaxCacheFiles      int
-	MaxCacheMem        int
-	MaxCacheSize       int
-	MaxWidth           int
-	MaxHeight          int
-	MaxAnimationFrames int
-	Debug              bool
+	Filters                  FilterMap
+	DisableBlur              bool
+	DisableFilters           []string
+	MaxFilterOps             int
+	MaxFilterArgLength       int
+	Logger                   *zap.Logger
+	Concurrency              int
+	MaxCacheFiles            int
+	MaxCacheMem              int
+	MaxCacheSize             int
+	NoCache                  bool
+	MaxWidth                 int
+	MaxHeight                int
+	MaxResultWidth           int
+	MaxResultHeight          int
+	TwoStepDownscale         float64
+	MaxAnimationFrames       int
+	MaxAnimationFramesAction string
+	FFmpegPath               string
+	AllowTruncated           bool
+	FallbackFormats          []vips.ImageType
+	DefaultFormat            vips.ImageType
+	Palettes                 map[string]color.Palette
+	PartialAnimation         bool
+	DisablePassthrough       bool
+	CMYKInvert               string
+	Debug                    bool
+
+	// exportImage encodes image, replaceable by tests of export failures
+	exportImage func(image *vips.ImageRef, format vips.ImageType, opts exportOptions) ([]byte, *vips.ImageMetadata, error)
 }
 
 func New(options ...Option) *VipsProcessor {
 	v := &VipsProcessor{
-		MaxWidth:           9999,
-		MaxHeight:          9999,
-		MaxFilterOps:       10,
-		Concurrency:        1,
-		MaxAnimationFrames: -1,
-		Logger:             zap.NewNop(),
+		MaxWidth:                 9999,
+		MaxHeight:                9999,
+		MaxFilterOps:             10,
+		Concurrency:              1,
+		MaxAnimationFrames:       -1,
+		MaxAnimationFramesAction: AnimationFramesTruncate,
+		CMYKInvert:               CMYKInvertAuto,
+		Logger:                   zap.NewNop(),
+		exportImage:              export,
 	}
 	v.Filters = FilterMap{
 		"watermark":        v.watermark,
 		"round_corner":     roundCorner,
+		"checker":          checker,
+		"circle":           circle,
 		"rotate":           rotate,
+		"flip":             flip,
+		"flop":             flop,
 		"grayscale":        grayscale,
 		"brightness":       brightness,
 		"background_color": backgroundColor,
 		"contrast":         contrast,
 		"modulate":         modulate,
+		"sepia":            sepia,
 		"hue":              hue,
 		"saturation":       saturation,
 		"rgb":              rgb,
 		"blur":             blur,
 		"sharpen":          sharpen,
+		"pixelate":         pixelate,
 		"strip_icc":        stripIcc,
 		"strip_exif":       stripExif,
 		"trim":             trimFilter,
+		"safe_zone":        safeZone,
+		"map_palette":      v.mapPalette,
+		"gradient_bg":      gradientBg,
+		"min_size":         v.minSize,
+		"pad_reflect":      padReflect,
+		"inset_border":     insetBorder,
+		"replace_color":    replaceColor,
+		"color_matrix":     colorMatrix,
+		"if_larger":        v.ifLarger,
+		"if_smaller":       v.ifSmaller,
+		"animation_bg":     animationBackground,
+		"css":              v.css,
+		"enlarge":          enlarge,
+		"diff":             v.diff,
+		"level_horizon":    levelHorizon,
+		"quantize":         quantize,
+		"depth_blur":       v.depthBlur,
+		"sharpen_mask":     v.sharpenMask,
+		"zoom_blur":        zoomBlur,
+		"hist_overlay":     histOverlay,
+		"opacity":          opacity,
+		"auto_exposure":    autoExposure,
+		"letterbox":        v.letterbox,
+		"border":           border,
+		"collage":          v.collage,
+		"padding":          v.padding,
+		"text_box":         textBox,
 	}
 	for _, option := range options {
 		option(v)
 	}
 	if v.DisableBlur {
-		v.DisableFilters = append(v.DisableFilters, "blur", "sharpen")
+		v.DisableFilters = append(v.DisableFilters, "blur", "sharpen", "depth_blur", "sharpen_mask", "zoom_blur")
 	}
 	for _, name := range v.DisableFilters {
 		delete(v.Filters, name)
 	}
 	if v.Concurrency == -1 {
-		v.Concurrency = runtime.NumCPU()
+		v.Concurrency = availableCPU()
 	}
 	return v
 }
@@ -87,27 +161,33 @@ func (v *VipsProcessor) Startup(_ context.Context) error {
 				v.Logger.Error(domain, zap.String("log", msg))
 			}
 		}, vips.LogLevelDebug)
-		vips.Startup(&vips.Config{
-			ReportLeaks:      true,
-			MaxCacheFiles:    v.MaxCacheFiles,
-			MaxCacheMem:      v.MaxCacheMem,
-			MaxCacheSize:     v.MaxCacheSize,
-			ConcurrencyLevel: v.Concurrency,
-		})
+		config := v.vipsConfig()
+		config.ReportLeaks = true
+		vips.Startup(config)
 	} else {
 		vips.LoggingSettings(func(domain string, level vips.LogLevel, msg string) {
 			v.Logger.Error(domain, zap.String("log", msg))
 		}, vips.LogLevelError)
-		vips.Startup(&vips.Config{
-			MaxCacheFiles:    v.MaxCacheFiles,
-			MaxCacheMem:      v.MaxCacheMem,
-			MaxCacheSize:     v.MaxCacheSize,
-			ConcurrencyLevel: v.Concurrency,
-		})
+		vips.Startup(v.vipsConfig())
+	}
+	if v.NoCache {
+		v.Logger.Info("vips operation cache disabled")
 	}
 	return nil
 }
 
+func (v *VipsProcessor) vipsConfig() *vips.Config {
+	if v.NoCache {
+		return &vips.Config{ConcurrencyLevel: v.Concurrency}
+	}
+	return &vips.Config{
+		MaxCacheFiles:    v.MaxCacheFiles,
+		MaxCacheMem:      v.MaxCacheMem,
+		MaxCacheSize:     v.MaxCacheSize,
+		ConcurrencyLevel: v.Concurrency,
+	}
+}
+
 func (v *VipsProcessor) Shutdown(_ context.Context) error {
 	vips.Shutdown()
 	return nil
@@ -139,12 +219,64 @@ func (v *VipsProcessor) newThumbnail(
 				return img, err
 			}
 		}
+	} else if k := v.twoStepShrink(blob, width, height, crop, size); k > 1 {
+		img, err = v.twoStepThumbnail(blob, k, width, height, crop, size)
 	} else {
 		img, err = vips.LoadThumbnailFromBuffer(buf, width, height, crop, size, nil)
 	}
 	return img, wrapErr(err)
 }
 
+// twoStepShrink integer box shrink factor for two-step downscale if TwoStepDownscale ratio reached,
+// keeping at least twice of the target dimensions for the high quality resize
+func (v *VipsProcessor) twoStepShrink(
+	blob *imagor.Blob, width, height int, crop vips.Interesting, size vips.Size,
+) int {
+	if v.TwoStepDownscale <= 1 || width <= 0 || height <= 0 || size == vips.SizeUp {
+		return 0
+	}
+	sw, sh, err := v.sourceSize(blob)
+	if err != nil {
+		return 0
+	}
+	rw, rh := float64(sw)/float64(width), float64(sh)/float64(height)
+	// fit within by the larger ratio, otherwise both dimensions must cover the target
+	ratio := math.Max(rw, rh)
+	if crop != vips.InterestingNone || size == vips.SizeForce {
+		ratio = math.Min(rw, rh)
+	}
+	if ratio < v.TwoStepDownscale {
+		return 0
+	}
+	return int(ratio / 2)
+}
+
+// twoStepThumbnail shrink by k followed by lanczos thumbnail resize.
+// First step by thumbnail from buffer such that JPEG and WebP shrink-on-load applies,
+// without full decode of the source
+func (v *VipsProcessor) twoStepThumbnail(
+	blob *imagor.Blob, k, width, height int, crop vips.Interesting, size vips.Size,
+) (*vips.ImageRef, error) {
+	sw, sh, err := v.sourceSize(blob)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	img, err := vips.LoadThumbnailFromBuffer(
+		buf, sw/k, sh/k, vips.InterestingNone, vips.SizeDown, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = img.ThumbnailWithSize(width, height, crop, size); err != nil {
+		img.Close()
+		return nil, err
+	}
+	return img, nil
+}
+
 func (v *VipsProcessor) newImage(blob *imagor.Blob, n int) (*vips.ImageRef, error) {
 	if imagor.IsBlobEmpty(blob) {
 		return nil, imagor.ErrNotFound
@@ -162,6 +294,38 @@ func (v *VipsProcessor) newImage(blob *imagor.Blob, n int) (*vips.ImageRef, erro
 	return img, wrapErr(err)
 }
 
+func (v *VipsProcessor) pageN(blob *imagor.Blob) (int, error) {
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	params := vips.NewImportParams()
+	params.NumPages.Set(-1)
+	img, err := vips.LoadImageFromBuffer(buf, params)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	defer img.Close()
+	return img.Height() / img.PageHeight(), nil
+}
+
+func (v *VipsProcessor) sourceSize(blob *imagor.Blob) (int, int, error) {
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return 0, 0, err
+	}
+	// header only as pixels are loaded lazily
+	img, err := vips.LoadImageFromBuffer(buf, nil)
+	if err != nil {
+		return 0, 0, wrapErr(err)
+	}
+	defer img.Close()
+	if o := img.Orientation(); o >= 5 && o <= 8 {
+		return img.PageHeight(), img.Width(), nil
+	}
+	return img.Width(), img.PageHeight(), nil
+}
+
 func (v *VipsProcessor) thumbnail(
 	img *vips.ImageRef, width, height int, crop vips.Interesting, size vips.Size,
 ) error {
@@ -173,12 +337,26 @@ func (v *VipsProcessor) thumbnail(
 
 func (v *VipsProcessor) animatedThumbnailWithCrop(
 	img *vips.ImageRef, w, h int, crop vips.Interesting, size vips.Size,
+) (err error) {
+	var bias float64 = -1
+	if crop == vips.InterestingHigh {
+		bias = 1
+	} else if crop == vips.InterestingCentre || crop == vips.InterestingAttention {
+		bias = 0
+	}
+	return v.thumbnailWithBias(img, w, h, bias, bias, size)
+}
+
+// thumbnailWithBias resizes to cover w x h and crops with window shifted from center
+// by fractional bias of the remaining space, -1 to 1 where -1 aligns to left or top
+// and 1 aligns to right or bottom
+func (v *VipsProcessor) thumbnailWithBias(
+	img *vips.ImageRef, w, h int, biasX, biasY float64, size vips.Size,
 ) (err error) {
 	if size == vips.SizeDown && img.Width() < w && img.PageHeight() < h {
 		return
 	}
 	// use ExtractArea for animated cropping
-	var top, left int
 	if float64(w)/float64(h) > float64(img.Width())/float64(img.PageHeight()) {
 		if err = img.ThumbnailWithSize(w, v.MaxHeight, vips.InterestingNone, size); err != nil {
 			return
@@ -188,13 +366,8 @@ func (v *VipsProcessor) animatedThumbnailWithCrop(
 			return
 		}
 	}
-	if crop == vips.InterestingHigh {
-		left = img.Width() - w
-		top = img.PageHeight() - h
-	} else if crop == vips.InterestingCentre || crop == vips.InterestingAttention {
-		left = (img.Width() - w) / 2
-		top = (img.PageHeight() - h) / 2
-	}
+	left := int(float64(img.Width()-w) * (1 + biasX) / 2)
+	top := int(float64(img.PageHeight()-h) * (1 + biasY) / 2)
 	return img.ExtractArea(left, top, w, h)
 }
 
@@ -202,14 +375,20 @@ func (v *VipsProcessor) Process(
 	ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc,
 ) (*imagor.Blob, error) {
 	var (
-		special   = false
-		upscale   = true
-		stretch   = p.Stretch
-		thumbnail = false
-		img       *vips.ImageRef
-		format    = vips.ImageTypeUnknown
-		maxN      = v.MaxAnimationFrames
-		err       error
+		special     = false
+		auto        = false
+		explicit    = false
+		upscale     = true
+		stretch     = p.Stretch
+		thumbnail   = false
+		img         *vips.ImageRef
+		format      = vips.ImageTypeUnknown
+		maxN        = v.MaxAnimationFrames
+		seek        float64
+		conditional bool
+		canvas      string
+		aspect      bool
+		err         error
 	)
 	ctx = WithInitImageRefs(ctx)
 	defer CloseImageRefs(ctx)
@@ -222,11 +401,22 @@ func (v *VipsProcessor) Process(
 	if maxN == 0 || maxN < -1 {
 		maxN = 1
 	}
-	for _, p := range p.Filters {
-		switch p.Name {
+	for _, filter := range p.Filters {
+		if v.MaxFilterArgLength > 0 {
+			for _, arg := range strings.Split(filter.Args, ",") {
+				if len(arg) > v.MaxFilterArgLength {
+					return nil, imagor.ErrMaxArgLenExceeded
+				}
+			}
+		}
+		switch filter.Name {
 		case "format":
-			if typ, ok := imageTypeMap[p.Args]; ok {
+			if filter.Args == "auto" {
+				// decided by image content after processing
+				auto = true
+			} else if typ, ok := imageTypeMap[filter.Args]; ok {
 				format = typ
+				explicit = true
 				if format != vips.ImageTypeGIF && format != vips.ImageTypeWEBP {
 					// no frames if export format not support animation
 					maxN = 1
@@ -242,17 +432,127 @@ func (v *VipsProcessor) Process(
 		case "no_upscale":
 			upscale = false
 			break
+		case "object_fit":
+			// CSS object-fit equivalent of fit-in, stretch and upscale
+			switch filter.Args {
+			case "cover":
+				p.FitIn = false
+				stretch = false
+				upscale = true
+			case "contain":
+				p.FitIn = true
+				stretch = false
+				upscale = true
+			case "fill":
+				p.FitIn = false
+				stretch = true
+			case "scale-down":
+				p.FitIn = true
+				stretch = false
+				upscale = false
+			}
+			break
 		case "fill", "background_color":
-			if args := strings.Split(p.Args, ","); args[0] == "auto" {
+			if args := strings.Split(filter.Args, ","); args[0] == "auto" {
 				special = true
 			}
 			break
 		case "trim":
 			special = true
 			break
+		case "no_shrink_on_load":
+			// full decode before resize for comparing quality
+			special = true
+			break
+		case "frame":
+			// video frame position in seconds
+			if f, e := strconv.ParseFloat(filter.Args, 64); e == nil && f > 0 {
+				seek = f
+			}
+			break
+		case "if_larger", "if_smaller", "enlarge":
+			conditional = true
+			break
+		case "canvas":
+			canvas = filter.Args
+			break
+		case "aspect_ratio":
+			aspect = true
+			break
+		}
+	}
+	if !auto && format == vips.ImageTypeUnknown && v.DefaultFormat != vips.ImageTypeUnknown {
+		format = v.DefaultFormat
+		if format != vips.ImageTypeGIF && format != vips.ImageTypeWEBP {
+			maxN = 1
+		}
+	}
+	if imagor.IsBlobEmpty(blob) {
+		if canvas == "" {
+			return nil, imagor.ErrNotFound
+		}
+		// generate source for request without source image
+		if blob, err = v.canvas(canvas); err != nil {
+			return nil, err
+		}
+	} else if buf, _ := blob.ReadAll(); isNonImage(buf) {
+		if v.Debug {
+			v.Logger.Debug("non-image", zap.Int("size", len(buf)))
+		}
+		return nil, imagor.ErrUnsupportedFormat
+	} else if v.FFmpegPath != "" && isVideo(buf) {
+		if blob, err = v.videoFrame(ctx, blob, seek); err != nil {
+			return nil, err
+		}
+	} else if !v.AllowTruncated && isTruncated(buf) {
+		return nil, imagor.ErrTruncatedImage
+	}
+	if aspect {
+		// source dimensions from header without processing
+		w, h, err := v.sourceSize(blob)
+		if err != nil {
+			return nil, err
+		}
+		return jsonBlob(newAspectRatio(w, h), w, h), nil
+	}
+	if conditional {
+		// source dimensions for conditional and enlarge filters
+		w, h, err := v.sourceSize(blob)
+		if err != nil {
+			return nil, err
+		}
+		SetSourceSize(ctx, w, h)
+	}
+	if maxN > 1 && v.MaxAnimationFramesAction != AnimationFramesTruncate && blob.SupportsAnimation() {
+		// probe number of frames for action on exceeding max frames
+		if n, err := v.pageN(blob); err != nil {
+			return nil, err
+		} else if n > maxN {
+			if v.MaxAnimationFramesAction == AnimationFramesReject {
+				return nil, imagor.ErrMaxFramesExceeded
+			}
+			maxN = 1
+		}
+	}
+	var invertCMYK bool
+	buf, _ := blob.ReadAll()
+	cmyk, adobe := jpegCMYK(buf)
+	if cmyk && v.CMYKInvert != CMYKInvertAuto {
+		// libjpeg inverts by Adobe APP14 marker, correct the inversion before color conversion
+		invertCMYK = (v.CMYKInvert == CMYKInvertAlways) != adobe
+		special = special || invertCMYK
+	}
+	// CMYK JPEG converted to sRGB instead of passthrough as is
+	if !auto && !cmyk {
+		if out, ok := v.passthrough(blob, p, format, maxN); ok {
+			if v.Debug {
+				v.Logger.Debug("passthrough", zap.Any("meta", out.Meta))
+			}
+			return out, nil
 		}
 	}
-	if !special && p.CropBottom == 0 && p.CropTop == 0 && p.CropLeft == 0 && p.CropRight == 0 {
+	cropped := p.CropBottom > 0 || p.CropTop > 0 || p.CropLeft > 0 || p.CropRight > 0
+	if !special && !cropped {
 		// apply shrink-on-load where possible
 		if p.FitIn {
 			if p.Width > 0 || p.Height > 0 {
@@ -285,7 +585,7 @@ func (v *VipsProcessor) Process(
 				}
 				thumbnail = true
 			}
-		} else {
+		} else if _, _, ok := cropBias(p.Filters); !ok {
 			if p.Width > 0 && p.Height > 0 {
 				interest := vips.InterestingNone
 				if p.Smart {
@@ -332,8 +632,13 @@ func (v *VipsProcessor) Process(
 		}
 	}
 	if !thumbnail {
-		if special {
-			// special ops does not support create by thumbnail
+		if special || cropped {
+			// special ops does not support create by thumbnail,
+			// crop coordinates apply to the source dimensions.
+			// full decode without shrink-on-load hence guarded by source dimensions
+			if w, h, e := v.sourceSize(blob); e == nil && (w > v.MaxWidth || h > v.MaxHeight) {
+				return nil, imagor.ErrMaxSizeExceeded
+			}
 			if img, err = v.newImage(blob, maxN); err != nil {
 				return nil, err
 			}
@@ -347,9 +652,35 @@ func (v *VipsProcessor) Process(
 		}
 	}
 	AddImageRef(ctx, img)
+	if invertCMYK {
+		if err = img.Invert(); err != nil {
+			return nil, wrapErr(err)
+		}
+	}
+	if img.Interpretation() == vips.InterpretationCMYK {
+		// sRGB by embedded or default CMYK profile, such that export and filters are not in CMYK
+		if err = img.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return nil, wrapErr(err)
+		}
+	}
 	var (
-		quality int
-		pageN   = img.Height() / img.PageHeight()
+		quality      int
+		level        = -1
+		speed        = -1
+		alphaQ       = -1
+		scans        int
+		interlace    bool
+		lossless     bool
+		nearLossless bool
+		noGPS        bool
+		comment      string
+		alpha        bool
+		lqip         float64
+		zoom         float64
+		animate      string
+		sharp        bool
+		hashX, hashY int
+		pageN        = img.Height() / img.PageHeight()
 	)
 	if format == vips.ImageTypeUnknown {
 		format = img.Format()
@@ -363,19 +694,206 @@ func (v *VipsProcessor) Process(
 		case "quality":
 			quality, _ = strconv.Atoi(p.Args)
 			break
+		case "compression":
+			// png compression level
+			if c, e := strconv.Atoi(p.Args); e == nil {
+				level = clampInt(c, 0, 9)
+			}
+			break
+		case "speed":
+			// avif encoder speed
+			if s, e := strconv.Atoi(p.Args); e == nil {
+				speed = clampInt(s, 0, 9)
+			}
+			break
 		case "autojpg":
 			format = vips.ImageTypeJPEG
+			explicit = true
+			break
+		case "circle":
+			// transparent outside circle unless flattened by color
+			alpha = p.Args == ""
+			break
+		case "border":
+			// transparent border, alpha kept by png in place of jpeg
+			if args := strings.Split(p.Args, ","); len(args) > 1 && isTransparent(args[len(args)-1]) {
+				alpha = true
+			}
+			break
+		case "padding":
+			// transparent padding, alpha kept by png in place of jpeg
+			if args := strings.Split(p.Args, ","); len(args) < 5 || isTransparent(args[4]) {
+				alpha = true
+			}
+			break
+		case "opacity":
+			// semi-transparent, alpha kept by png in place of jpeg
+			if o, e := strconv.ParseFloat(p.Args, 64); e == nil && o < 100 {
+				alpha = true
+			}
+			break
+		case "alpha_quality":
+			if q, e := strconv.Atoi(p.Args); e == nil {
+				alphaQ = q
+			}
+			break
+		case "progressive_jpeg_scans":
+			scans, _ = strconv.Atoi(p.Args)
+			break
+		case "lossless":
+			// webp only, e.g. logos and flat color graphics
+			lossless = true
+			break
+		case "near_lossless":
+			nearLossless = true
+			break
+		case "progressive":
+			// progressive jpeg or interlaced png, alongside quality
+			interlace = true
+			break
+		case "strip_gps":
+			noGPS = true
+			break
+		case "comment":
+			if c, e := url.QueryUnescape(p.Args); e == nil {
+				comment = c
+			} else {
+				comment = p.Args
+			}
+			break
+		case "animate":
+			animate = p.Args
+			break
+		case "sharpness":
+			sharp = true
+			break
+		case "blurhash":
+			hashX, hashY = parseBlurHash(p.Args)
+			break
+		case "ken_burns":
+			zoom = 1.5
+			if f, e := strconv.ParseFloat(p.Args, 64); e == nil && f > 1 {
+				zoom = math.Min(f, kenBurnsMaxZoom)
+			}
+			break
+		case "svg_lqip":
+			lqip = 1
+			if f, e := strconv.ParseFloat(p.Args, 64); e == nil && f > 0 {
+				lqip = f
+			}
 			break
 		}
 	}
 	if err := v.process(ctx, img, p, load, thumbnail, stretch, upscale); err != nil {
 		return nil, wrapErr(err)
 	}
-	buf, meta, err := export(img, format, quality)
+	if v.PartialAnimation && img.Height() > img.PageHeight() {
+		// best-effort partial animation on process timeout
+		if err := renderFrames(ctx, img); err != nil {
+			return nil, wrapErr(err)
+		}
+	}
+	if v.MaxResultWidth > 0 || v.MaxResultHeight > 0 {
+		// clamp exported dimensions independent of processing dimensions
+		w, h := v.MaxResultWidth, v.MaxResultHeight
+		if w <= 0 {
+			w = v.MaxWidth
+		}
+		if h <= 0 {
+			h = v.MaxHeight
+		}
+		if img.Width() > w || img.PageHeight() > h {
+			if err := img.ThumbnailWithSize(w, h, vips.InterestingNone, vips.SizeDown); err != nil {
+				return nil, wrapErr(err)
+			}
+		}
+	}
+	if animate != "" && !IsAnimated(ctx) &&
+		(!explicit || format == vips.ImageTypeGIF || format == vips.ImageTypeWEBP) {
+		if err := v.animate(ctx, img, load, animate); err != nil {
+			return nil, wrapErr(err)
+		}
+		if IsAnimated(ctx) && format != vips.ImageTypeGIF && format != vips.ImageTypeWEBP {
+			// still image format cannot carry the synthesized frames
+			format = vips.ImageTypeGIF
+		}
+	}
+	if zoom > 0 {
+		// crop path of the processed image instead of image
+		k, err := kenBurns(ctx, img, zoom)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		return jsonBlob(k, k.Width, k.Height), nil
+	}
+	if sharp {
+		// score of the processed image instead of image
+		s, err := sharpness(ctx, img)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		return jsonBlob(s, s.Width, s.Height), nil
+	}
+	if auto {
+		if format, err = autoFormat(ctx, img); err != nil {
+			return nil, wrapErr(err)
+		}
+	} else if alpha && !explicit && !supportsAlpha(format) && img.HasAlpha() {
+		// keep transparency of alpha producing filter instead of flatten on export
+		format = vips.ImageTypePNG
+	}
+	if format == vips.ImageTypeWEBP && alphaQ >= 0 {
+		if err := quantizeAlpha(img, alphaQ); err != nil {
+			return nil, wrapErr(err)
+		}
+	}
+	if scans > 0 && format == vips.ImageTypeJPEG {
+		interlace = true
+	}
+	var dominant, hash string
+	if p.Meta {
+		if dominant, err = dominantColor(ctx, img); err != nil {
+			return nil, wrapErr(err)
+		}
+		if hashX > 0 {
+			// computed only if requested as relatively expensive
+			if hash, err = blurHash(ctx, img, hashX, hashY); err != nil {
+				return nil, wrapErr(err)
+			}
+		}
+	}
+	buf, meta, err := v.exportWithFallback(img, format, exportOptions{
+		quality:      quality,
+		compression:  level,
+		speed:        speed,
+		interlace:    interlace,
+		lossless:     lossless,
+		nearLossless: nearLossless,
+	})
 	if err != nil {
 		return nil, wrapErr(err)
 	}
-	return imagor.NewBlobBytesWithMeta(buf, getMeta(meta)), nil
+	if scans > 0 {
+		buf = jpegScans(buf, scans)
+	}
+	if noGPS {
+		buf = stripGPS(buf)
+	}
+	if comment != "" {
+		buf = addComment(buf, comment)
+	}
+	m := getMeta(meta)
+	if lqip > 0 {
+		buf, m = svgLQIP(buf, m, lqip)
+	}
+	if p.Meta {
+		if src, _ := blob.ReadAll(); len(src) > 0 {
+			m.SourceQuality = jpegQuality(src)
+		}
+		m.DominantColor = dominant
+		m.BlurHash = hash
+	}
+	return imagor.NewBlobBytesWithMeta(buf, m), nil
 }
 
 func getMeta(meta *vips.ImageMetadata) *imagor.Meta {
@@ -394,6 +912,17 @@ func getMeta(meta *vips.ImageMetadata) *imagor.Meta {
 	}
 }
 
+// jsonBlob JSON blob of image dimensions in place of image
+func jsonBlob(v interface{}, width, height int) *imagor.Blob {
+	buf, _ := json.Marshal(v)
+	return imagor.NewBlobBytesWithMeta(buf, &imagor.Meta{
+		Format:      "json",
+		ContentType: "application/json",
+		Width:       width,
+		Height:      height,
+	})
+}
+
 var imageTypeMap = map[string]vips.ImageType{
 	"gif":    vips.ImageTypeGIF,
 	"jpeg":   vips.ImageTypeJPEG,
@@ -425,52 +954,108 @@ var imageMimeTypeMap = map[string]string{
 	"jp2":  "image/jp2",
 }
 
-func export(image *vips.ImageRef, format vips.ImageType, quality int) ([]byte, *vips.ImageMetadata, error) {
+// exportWithFallback exports image in format, falling back to
+// the subsequent formats of FallbackFormats on encode failure
+func (v *VipsProcessor) exportWithFallback(
+	image *vips.ImageRef, format vips.ImageType, opts exportOptions,
+) (buf []byte, meta *vips.ImageMetadata, err error) {
+	if buf, meta, err = v.exportImage(image, format, opts); err == nil {
+		return
+	}
+	for i, f := range v.FallbackFormats {
+		if f != format {
+			continue
+		}
+		for _, fallback := range v.FallbackFormats[i+1:] {
+			if v.Debug {
+				v.Logger.Debug("export-fallback",
+					zap.String("format", vips.ImageTypes[format]),
+					zap.String("fallback", vips.ImageTypes[fallback]),
+					zap.Error(err))
+			}
+			if buf, meta, err = v.exportImage(image, fallback, opts); err == nil {
+				return
+			}
+		}
+		break
+	}
+	return
+}
+
+// exportOptions encoding options of export, applied to the formats supporting them
+type exportOptions struct {
+	// quality default if not positive
+	quality int
+	// compression level 0 to 9 applies to png only, default if negative
+	compression int
+	// speed 0 to 9 applies to avif only, default if negative
+	speed int
+	// interlace applies to jpeg and png only
+	interlace bool
+	// lossless and near-lossless applies to webp only
+	lossless     bool
+	nearLossless bool
+}
+
+// export image in format by encoding options
+func export(
+	image *vips.ImageRef, format vips.ImageType, o exportOptions,
+) ([]byte, *vips.ImageMetadata, error) {
 	switch format {
 	case vips.ImageTypePNG:
 		opts := vips.NewPngExportParams()
+		if o.compression >= 0 {
+			opts.Compression = o.compression
+		}
+		opts.Interlace = o.interlace
 		return image.ExportPng(opts)
 	case vips.ImageTypeWEBP:
 		opts := vips.NewWebpExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
 		}
+		opts.Lossless = o.lossless
+		opts.NearLossless = o.nearLossless
 		return image.ExportWebp(opts)
 	case vips.ImageTypeHEIF:
 		opts := vips.NewHeifExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
 		}
 		return image.ExportHeif(opts)
 	case vips.ImageTypeTIFF:
 		opts := vips.NewTiffExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
 		}
 		return image.ExportTiff(opts)
 	case vips.ImageTypeGIF:
 		opts := vips.NewGifExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
 		}
 		return image.ExportGIF(opts)
 	case vips.ImageTypeAVIF:
 		opts := vips.NewAvifExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
+		}
+		if o.speed >= 0 {
+			opts.Speed = o.speed
 		}
 		return image.ExportAvif(opts)
 	case vips.ImageTypeJP2K:
 		opts := vips.NewJp2kExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
 		}
 		return image.ExportJp2k(opts)
 	default:
 		opts := vips.NewJpegExportParams()
-		if quality > 0 {
-			opts.Quality = quality
+		if o.quality > 0 {
+			opts.Quality = o.quality
 		}
+		opts.Interlace = o.interlace
 		return image.ExportJpeg(opts)
 	}
 }
@@ -486,6 +1071,9 @@ func wrapErr(err error) error {
 	if strings.HasPrefix(msg, "VipsForeignLoad: buffer is not in a known format") {
 		return imagor.ErrUnsupportedFormat
 	}
+	if strings.Contains(msg, "Premature end of") || strings.Contains(msg, "truncated") {
+		return imagor.ErrTruncatedImage
+	}
 	if idx := strings.Index(msg, "Stack:"); idx > -1 {
 		msg = strings.TrimSpace(msg[:idx]) // neglect govips stacks from err msg
 		return imagor.NewError(msg, 406)
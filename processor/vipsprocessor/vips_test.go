@@ -1,22 +1,42 @@
 package vipsprocessor
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/cshum/imagor"
 	"github.com/cshum/imagor/imagorpath"
 	"github.com/cshum/imagor/storage/filestorage"
+	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/image/webp"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -59,6 +79,8 @@ var tests = []struct {
 	{"resize center", "100x100/filters:quality(70):format(jpeg)/gopher.png"},
 	{"resize smart", "100x100/smart/filters:autojpg()/gopher.png"},
 	{"resize top", "200x100/top/filters:quality(70):format(tiff)/gopher.png"},
+	{"resize progressive", "100x100/filters:quality(70):progressive():format(jpeg)/gopher.png"},
+	{"resize interlaced png", "100x100/filters:progressive()/gopher-front.png"},
 	{"resize top", "200x100/right/top/gopher.png"},
 	{"resize bottom", "200x100/bottom/gopher.png"},
 	{"resize bottom", "200x100/left/bottom/gopher.png"},
@@ -86,7 +108,69 @@ var tests = []struct {
 	{"trim upscale", "trim/fit-in/1000x1000/filters:upscale():strip_icc()/find_trim.png"},
 	{"trim tolerance", "trim:50/500x500/filters:stretch()/find_trim.png"},
 	{"trim filter", "/fit-in/100x100/filters:fill(auto):trim(50)/find_trim.png"},
+	{"quantize", "fit-in/300x300/filters:quantize(16)/gopher.png"},
 	{"watermark", "fit-in/500x500/filters:fill(white):watermark(gopher.png,10p,repeat,30,20,20):watermark(gopher.png,repeat,bottom,30,30,30):watermark(gopher-front.png,center,-10p)/gopher.png"},
+	{"object_fit cover", "300x200/filters:object_fit(cover)/gopher.png"},
+	{"object_fit contain", "300x200/filters:object_fit(contain):fill(white)/gopher.png"},
+	{"object_fit fill", "300x200/filters:object_fit(fill)/gopher.png"},
+	{"object_fit scale-down", "fit-in/500x500/filters:object_fit(scale-down)/gopher-front.png"},
+	{"safe_zone crop", "400x300/filters:safe_zone()/gopher.png"},
+	{"safe_zone overlay", "400x300/filters:safe_zone(80,overlay,red)/gopher.png"},
+	{"safe_zone overlay animated", "filters:safe_zone(80,overlay)/dancing-banana.gif"},
+	{"gradient_bg vertical", "fit-in/300x300/filters:gradient_bg(ff0000,0000ff,vertical)/gopher-front.png"},
+	{"gradient_bg horizontal", "fit-in/300x300/filters:gradient_bg(yellow,green,horizontal):format(jpeg)/gopher-front.png"},
+	{"auto_exposure", "fit-in/300x300/filters:auto_exposure()/gopher.png"},
+	{"auto_exposure target", "200x200/filters:auto_exposure(60,2)/demo1.jpg"},
+	{"auto_exposure animated", "filters:auto_exposure()/dancing-banana.gif"},
+	{"gradient_bg animated", "filters:gradient_bg(white,black)/dancing-banana.gif"},
+	{"animate blur", "fit-in/100x100/filters:animate(blur,0,10,5)/demo1.jpg"},
+	{"webp lossless", "fit-in/300x300/filters:format(webp):lossless()/gopher-front.png"},
+	{"webp near lossless", "fit-in/300x300/filters:format(webp):quality(60):near_lossless()/gopher-front.png"},
+	{"webp lossless animated", "filters:format(webp):lossless()/dancing-banana.gif"},
+	{"animate brightness webp", "fit-in/100x100/filters:animate(brightness,-50,50,4):format(webp)/gopher-front.png"},
+	{"animate animated", "filters:animate(blur,0,10,5)/dancing-banana.gif"},
+	{"min_size", "fit-in/500x500/filters:min_size(500,500)/gopher-front.png"},
+	{"min_size color", "fit-in/500x500/filters:min_size(400,600,ff0000):format(jpeg)/gopher-front.png"},
+	{"min_size animated", "filters:min_size(200,200,white)/dancing-banana.gif"},
+	{"color_matrix sepia", "fit-in/300x300/filters:color_matrix(0.393,0.769,0.189,0.349,0.686,0.168,0.272,0.534,0.131)/gopher-front.png"},
+	{"css", "fit-in/300x300/filters:css(brightness(1.1)%20contrast(120%25)%20saturate(0.9)%20hue-rotate(90deg))/gopher-front.png"},
+	{"css sepia", "fit-in/300x300/filters:css(sepia())/gopher-front.png"},
+	{"sepia", "fit-in/300x300/filters:sepia()/gopher-front.png"},
+	{"sepia intensity", "fit-in/300x300/filters:sepia(50)/gopher-front.png"},
+	{"zoom_blur", "fit-in/300x300/filters:zoom_blur(20)/gopher-front.png"},
+	{"pixelate", "fit-in/300x300/filters:pixelate(12)/gopher-front.png"},
+	{"pixelate region", "200x200/filters:pixelate(10,50,40,80,60)/demo1.jpg"},
+	{"pixelate animated", "filters:pixelate(10)/dancing-banana.gif"},
+	{"opacity", "fit-in/300x300/filters:opacity(50)/gopher-front.png"},
+	{"opacity jpeg", "200x200/filters:opacity(30)/demo1.jpg"},
+	{"opacity animated", "filters:opacity(60)/dancing-banana.gif"},
+	{"letterbox", "fit-in/300x300/filters:letterbox(16,9)/demo1.jpg"},
+	{"letterbox blur", "fit-in/300x300/filters:letterbox(16,9,blur)/gopher-front.png"},
+	{"letterbox color", "filters:letterbox(1,1,ff0000)/find_trim.png"},
+	{"letterbox animated", "filters:letterbox(1,1,blur)/dancing-banana.gif"},
+	{"border", "fit-in/300x300/filters:border(10,ff0000)/demo1.jpg"},
+	{"border xy", "fit-in/300x300/filters:border(10,20,00ff00)/gopher-front.png"},
+	{"border transparent", "fit-in/300x300/filters:border(10,none)/demo1.jpg"},
+	{"border animated", "filters:border(5,blue)/dancing-banana.gif"},
+	{"collage", "fit-in/300x300/filters:collage(2x2,10,0,0,100,100,100,0,100,100,0,100,100,100,100,100,100,100)/gopher-front.png"},
+	{"collage animated", "filters:collage(2x1,0,0,0,50,50,50,50,50,50)/dancing-banana.gif"},
+	{"padding", "fit-in/200x200/filters:padding(10,20,30,40)/demo1.jpg"},
+	{"padding color", "fit-in/200x200/filters:padding(10,20,30,40,ff0000)/gopher-front.png"},
+	{"padding animated", "filters:padding(5,5,5,5,white)/dancing-banana.gif"},
+	{"text box", "fit-in/300x300/filters:text_box(Some%20long%20text%20wrapped%20in%20the%20box,20,20,150,ffffff,24)/gopher-front.png"},
+	{"text box alpha", "fit-in/300x300/filters:text_box(Hello%20gopher,10,200,0,red,32)/gopher.png"},
+	{"checker", "fit-in/300x300/filters:checker()/gopher-front.png"},
+	{"checker tile colors", "fit-in/300x300/filters:checker(16,black,ff00ff):format(jpeg)/gopher-front.png"},
+	{"checker animated", "filters:checker(4)/dancing-banana.gif"},
+	{"circle", "200x200/filters:circle()/demo1.jpg"},
+	{"circle flatten", "200x200/filters:circle(white)/demo1.jpg"},
+	{"circle animated", "filters:circle()/dancing-banana.gif"},
+	{"hist_overlay", "fit-in/300x300/filters:hist_overlay()/demo1.jpg"},
+	{"hist_overlay top", "fit-in/300x300/filters:hist_overlay(top)/gopher-front.png"},
+	{"hist_overlay animated", "filters:hist_overlay()/dancing-banana.gif"},
+	{"inset_border", "300x200/filters:inset_border(10,ff0000):format(jpeg)/gopher.png"},
+	{"inset_border animated", "100x100/filters:inset_border(5,white)/dancing-banana.gif"},
+	{"zoom_blur center", "fit-in/300x300/filters:zoom_blur(30,0.2,0.8):format(jpeg)/gopher.png"},
 
 	{"original no animate", "filters:fill(white):format(jpeg)/dancing-banana.gif"},
 	{"original animated", "dancing-banana.gif"},
@@ -109,6 +193,43 @@ var tests = []struct {
 	{"padding with watermark double animated", "200x0/20x20:100x20/filters:fill(yellow):watermark(dancing-banana.gif,-10,-10,0,50,50):watermark(dancing-banana.gif,-30,10,0,50,50)/nyan-cat.gif"},
 }
 
+var metaTests = []struct {
+	name   string
+	path   string
+	width  int
+	height int
+}{
+	{"object_fit cover", "404x1000/filters:object_fit(cover)/gopher-front.png", 404, 1000},
+	{"object_fit contain", "404x1000/filters:object_fit(contain)/gopher-front.png", 404, 518},
+	{"object_fit fill", "404x1000/filters:object_fit(fill)/gopher-front.png", 404, 1000},
+	{"object_fit scale-down", "404x1000/filters:object_fit(scale-down)/gopher-front.png", 202, 259},
+	{"safe_zone crop", "400x300/filters:safe_zone()/gopher.png", 360, 270},
+	{"safe_zone crop percent", "400x300/filters:safe_zone(50,crop)/gopher.png", 200, 150},
+	{"safe_zone overlay", "400x300/filters:safe_zone(80,overlay)/gopher.png", 400, 300},
+	{"safe_zone crop animated", "filters:safe_zone(50)/dancing-banana.gif", 61, 64},
+	{"min_size", "fit-in/500x500/filters:min_size(500,500)/gopher-front.png", 500, 500},
+	{"min_size upscale", "500x500/filters:upscale():min_size(300,300)/gopher-front.png", 500, 500},
+	{"min_size partial", "fit-in/500x500/filters:min_size(100,400,white)/gopher-front.png", 202, 400},
+	{"min_size animated", "filters:min_size(200,200)/dancing-banana.gif", 200, 200},
+	{"if_larger applied", "fit-in/400x400/filters:if_larger(1000,safe_zone,50)/gopher.png", 147, 200},
+	{"if_larger skipped", "fit-in/400x400/filters:if_larger(1000,safe_zone,50)/gopher-front.png", 202, 259},
+	{"if_larger height", "fit-in/400x400/filters:if_larger(2000x2000,safe_zone,50)/gopher.png", 147, 200},
+	{"if_larger height skipped", "fit-in/400x400/filters:if_larger(2000x3000,safe_zone,50)/gopher.png", 294, 400},
+	{"if_smaller applied", "fit-in/400x400/filters:if_smaller(1000,safe_zone,50)/gopher-front.png", 101, 130},
+	{"if_smaller skipped", "fit-in/400x400/filters:if_smaller(1000,safe_zone,50)/gopher.png", 294, 400},
+	{"if nested", "filters:if_larger(100,if_smaller,300x300,safe_zone,50)/gopher-front.png", 101, 130},
+	{"canvas", "filters:canvas(400,300,cccccc)/", 400, 300},
+	{"canvas fit-in", "fit-in/200x200/filters:canvas(400,300,cccccc)/", 200, 150},
+	{"canvas ignored with source", "fit-in/400x400/filters:canvas(100,100)/gopher-front.png", 202, 259},
+	{"crop with resize", "10x20:210x220/100x50/gopher.png", 100, 50},
+	{"crop with fit-in", "0x0:400x200/fit-in/100x100/gopher.png", 100, 50},
+	{"crop with width", "0x0:400x200/200x0/gopher.png", 200, 100},
+	{"crop with stretch", "0x0:400x200/stretch/100x100/gopher.png", 100, 100},
+	{"crop with fit-in no upscale", "0x0:100x50/fit-in/400x400/gopher.png", 100, 50},
+	{"crop with upscale", "0x0:100x50/fit-in/400x400/filters:upscale()/gopher.png", 400, 200},
+	{"crop beyond bounds", "1034x2024:5000x5000/fit-in/300x300/gopher.png", 300, 100},
+}
+
 func TestVipsProcessor(t *testing.T) {
 	resultDir := filepath.Join(testDataDir, "result", getEnvironment())
 	app := imagor.New(
@@ -148,4 +269,3588 @@ func TestVipsProcessor(t *testing.T) {
 			}
 		})
 	}
+	for _, tt := range metaTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(
+				http.MethodGet, fmt.Sprintf("/unsafe/meta/%s", tt.path), nil))
+			assert.Equal(t, 200, w.Code)
+			var meta imagor.Meta
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &meta))
+			assert.Equal(t, tt.width, meta.Width)
+			assert.Equal(t, tt.height, meta.Height)
+		})
+	}
+}
+
+func TestMaxAnimationFramesAction(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "nyan-cat.gif"))
+	t.Run("within max frames", func(t *testing.T) {
+		out, err := New(
+			WithMaxAnimationFrames(20),
+			WithMaxAnimationFramesAction(AnimationFramesReject),
+		).Process(ctx, blob, imagorpath.Params{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "gif", out.Meta.Format)
+	})
+	t.Run("reject", func(t *testing.T) {
+		_, err := New(
+			WithMaxAnimationFrames(10),
+			WithMaxAnimationFramesAction(AnimationFramesReject),
+		).Process(ctx, blob, imagorpath.Params{}, nil)
+		assert.Equal(t, imagor.ErrMaxFramesExceeded, err)
+	})
+	t.Run("still", func(t *testing.T) {
+		out, err := New(
+			WithMaxAnimationFrames(10),
+			WithMaxAnimationFramesAction(AnimationFramesStill),
+		).Process(ctx, blob, imagorpath.Params{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "gif", out.Meta.Format)
+		assert.Equal(t, 198, out.Meta.Height)
+	})
+}
+
+func TestVideoFrame(t *testing.T) {
+	assert.True(t, isVideo([]byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00")))
+	assert.True(t, isVideo([]byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01")))
+	assert.False(t, isVideo([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00")))
+	assert.False(t, isVideo([]byte("\x47\x49\x46\x38\x39\x61")))
+
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "video.mp4")
+	require.NoError(t, exec.Command(ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "testsrc=duration=2:size=160x120:rate=10",
+		"-pix_fmt", "yuv420p", file).Run())
+	blob := imagor.NewBlobFilePath(file)
+
+	t.Run("first frame", func(t *testing.T) {
+		out, err := New(WithFFmpegPath(ffmpeg)).Process(
+			ctx, blob, imagorpath.Params{Width: 80}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "png", out.Meta.Format)
+		assert.Equal(t, 80, out.Meta.Width)
+		assert.Equal(t, 60, out.Meta.Height)
+	})
+	t.Run("frame at position", func(t *testing.T) {
+		out, err := New(WithFFmpegPath(ffmpeg)).Process(
+			ctx, blob, imagorpath.Params{Filters: imagorpath.Filters{
+				{Name: "frame", Args: "1.5"},
+				{Name: "format", Args: "jpeg"},
+			}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", out.Meta.Format)
+		assert.Equal(t, 160, out.Meta.Width)
+	})
+	t.Run("broken", func(t *testing.T) {
+		_, err := New(WithFFmpegPath(ffmpeg)).Process(ctx,
+			imagor.NewBlobBytes([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00broken")), imagorpath.Params{}, nil)
+		assert.Equal(t, errVideoFrame, err)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		_, err := New().Process(ctx, blob, imagorpath.Params{}, nil)
+		assert.Error(t, err)
+	})
+}
+
+func countJpegScans(buf []byte) (n int) {
+	for i := 2; i+4 <= len(buf) && buf[i] == 0xFF; {
+		marker := buf[i+1]
+		if marker == 0xD9 {
+			break
+		}
+		i += 2 + (int(buf[i+2])<<8 | int(buf[i+3]))
+		if marker == 0xDA {
+			n++
+			for i+1 < len(buf) && (buf[i] != 0xFF || buf[i+1] == 0x00 ||
+				(buf[i+1] >= 0xD0 && buf[i+1] <= 0xD7)) {
+				i++
+			}
+		}
+	}
+	return
+}
+
+func TestProgressiveJpegScans(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+	process := func(t *testing.T, scans string) []byte {
+		out, err := New().Process(ctx, blob, imagorpath.Params{
+			Width: 200,
+			Filters: imagorpath.Filters{
+				{Name: "progressive_jpeg_scans", Args: scans},
+			},
+		}, nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := jpeg.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		assert.Equal(t, 200, img.Bounds().Dx())
+		return buf
+	}
+	full := process(t, "100")
+	total := countJpegScans(full)
+	assert.Greater(t, total, 3)
+	for _, n := range []int{1, 2, 3} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			buf := process(t, strconv.Itoa(n))
+			assert.Equal(t, n, countJpegScans(buf))
+			assert.Less(t, len(buf), len(full))
+		})
+	}
+	assert.Equal(t, []byte("foo"), jpegScans([]byte("foo"), 1))
+}
+
+func TestProgressive(t *testing.T) {
+	ctx := context.Background()
+	// start of frame marker of progressive jpeg
+	sof2 := []byte{0xff, 0xc2}
+	tests := []struct {
+		name  string
+		path  string
+		check func(t *testing.T, buf []byte)
+	}{
+		{"jpeg", "100x100/filters:progressive()/demo1.jpg", func(t *testing.T, buf []byte) {
+			assert.True(t, bytes.Contains(buf, sof2))
+		}},
+		{"jpeg with quality", "100x100/filters:quality(50):progressive()/demo1.jpg", func(t *testing.T, buf []byte) {
+			assert.True(t, bytes.Contains(buf, sof2))
+		}},
+		{"jpeg baseline", "100x100/filters:quality(50)/demo1.jpg", func(t *testing.T, buf []byte) {
+			assert.False(t, bytes.Contains(buf, sof2))
+		}},
+		{"png", "100x100/filters:progressive()/gopher-front.png", func(t *testing.T, buf []byte) {
+			// interlace method byte of IHDR
+			assert.Equal(t, byte(1), buf[28])
+		}},
+		{"png non interlaced", "100x100/gopher-front.png", func(t *testing.T, buf []byte) {
+			assert.Equal(t, byte(0), buf[28])
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, path.Base(tt.path)))
+			out, err := New().Process(ctx, blob, imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			_, _, err = image.Decode(bytes.NewReader(buf))
+			require.NoError(t, err)
+			tt.check(t, buf)
+		})
+	}
+	t.Run("quality applies", func(t *testing.T) {
+		size := func(path string) int {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+			out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+			require.NoError(t, err)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			return len(buf)
+		}
+		assert.Less(t,
+			size("filters:quality(10):progressive()/demo1.jpg"),
+			size("filters:quality(95):progressive()/demo1.jpg"))
+	})
+}
+
+func TestTruncatedImage(t *testing.T) {
+	ctx := context.Background()
+	jpg, err := ioutil.ReadFile(filepath.Join(testDataDir, "demo1.jpg"))
+	require.NoError(t, err)
+	png, err := ioutil.ReadFile(filepath.Join(testDataDir, "gopher-front.png"))
+	require.NoError(t, err)
+	// progressive jpeg cut after first scans without EOI marker
+	partialJpg := jpegScans(jpg, 3)
+	partialJpg = partialJpg[:len(partialJpg)-2]
+	// trailing bytes after EOI, e.g. appended by some cameras and editors
+	trailingJpg := append(append([]byte{}, jpg...), "\x00\x00trailing\xFF\xD8\xFF"...)
+
+	assert.False(t, isTruncated(jpg))
+	assert.False(t, isTruncated(trailingJpg))
+	assert.False(t, isTruncated(png))
+	assert.True(t, isTruncated(partialJpg))
+	assert.True(t, isTruncated(jpg[:len(jpg)/2]))
+	assert.True(t, isTruncated(png[:len(png)/2]))
+	assert.False(t, isTruncated([]byte("foo")))
+
+	t.Run("reject truncated", func(t *testing.T) {
+		for _, buf := range [][]byte{partialJpg, jpg[:len(jpg)/2], png[:len(png)/2]} {
+			_, err := New().Process(ctx, imagor.NewBlobBytes(buf), imagorpath.Params{}, nil)
+			assert.Equal(t, imagor.ErrTruncatedImage, err)
+		}
+	})
+	t.Run("trailing bytes after EOI", func(t *testing.T) {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(trailingJpg), imagorpath.Params{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", out.Meta.Format)
+	})
+	t.Run("allow truncated", func(t *testing.T) {
+		out, err := New(WithAllowTruncated(true)).Process(
+			ctx, imagor.NewBlobBytes(partialJpg), imagorpath.Params{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", out.Meta.Format)
+		assert.Equal(t, 200, out.Meta.Width)
+	})
+}
+
+func TestNonImage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.jpg")
+	require.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+	text := filepath.Join(dir, "text.jpg")
+	require.NoError(t, ioutil.WriteFile(text, []byte("<html><body>404 page not found</body></html>"), 0644))
+
+	for _, blob := range []*imagor.Blob{
+		imagor.NewBlobFilePath(empty),
+		imagor.NewBlobFilePath(text),
+		imagor.NewBlobBytes([]byte("hello world")),
+	} {
+		require.False(t, imagor.IsBlobEmpty(blob))
+		_, err := New().Process(ctx, blob, imagorpath.Parse("fit-in/100x100/image.jpg"), nil)
+		assert.Equal(t, imagor.ErrUnsupportedFormat, err)
+	}
+
+	assert.True(t, isNonImage(nil))
+	assert.True(t, isNonImage([]byte("{\"message\":\"not found\"}")))
+	assert.False(t, isNonImage([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`)))
+	assert.False(t, isNonImage([]byte("P2\n2 1\n255\n0 255\n")))
+	for _, file := range []string{"demo1.jpg", "gopher-front.png", "dancing-banana.gif"} {
+		buf, err := ioutil.ReadFile(filepath.Join(testDataDir, file))
+		require.NoError(t, err)
+		assert.False(t, isNonImage(buf), file)
+	}
+}
+
+func TestWebpLossless(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{R: 0xff, G: 0x80, A: 0xff}
+			if (x/16+y/16)%2 == 0 {
+				c = color.NRGBA{B: 0xff, A: 0xff}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(t *testing.T, blob *imagor.Blob, path string) (*imagor.Blob, []byte) {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		return out, res
+	}
+	t.Run("lossless", func(t *testing.T) {
+		_, res := process(t, imagor.NewBlobBytes(buf.Bytes()), "filters:format(webp):quality(50):lossless()/image.png")
+		assert.True(t, bytes.Contains(res, []byte("VP8L")), "lossless bitstream")
+		img, err := webp.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				require.Equal(t, src.NRGBAAt(x, y), color.NRGBAModel.Convert(img.At(x, y)), "exact pixels")
+			}
+		}
+	})
+	t.Run("near lossless", func(t *testing.T) {
+		_, res := process(t, imagor.NewBlobBytes(buf.Bytes()), "filters:format(webp):quality(60):near_lossless()/image.png")
+		assert.True(t, bytes.Contains(res, []byte("VP8L")), "lossless bitstream with preprocessing")
+	})
+	t.Run("lossy by default", func(t *testing.T) {
+		_, res := process(t, imagor.NewBlobBytes(buf.Bytes()), "filters:format(webp):quality(50)/image.png")
+		assert.False(t, bytes.Contains(res, []byte("VP8L")))
+	})
+	t.Run("ignored for other formats", func(t *testing.T) {
+		out, res := process(t, imagor.NewBlobBytes(buf.Bytes()), "filters:format(jpeg):lossless()/image.png")
+		assert.Equal(t, "jpeg", out.Meta.Format)
+		_, err := jpeg.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+	})
+	t.Run("animated", func(t *testing.T) {
+		banana := imagor.NewBlobFilePath(filepath.Join(testDataDir, "dancing-banana.gif"))
+		out, res := process(t, banana, "filters:format(webp):lossless()/dancing-banana.gif")
+		assert.Equal(t, "webp", out.Meta.Format)
+		assert.True(t, bytes.Contains(res, []byte("ANMF")), "animated")
+		assert.True(t, bytes.Contains(res, []byte("VP8L")), "lossless frames")
+		params := vips.NewImportParams()
+		params.NumPages.Set(-1)
+		img, err := vips.LoadImageFromBuffer(res, params)
+		require.NoError(t, err)
+		defer img.Close()
+		srcBuf, err := banana.ReadAll()
+		require.NoError(t, err)
+		srcImg, err := vips.LoadImageFromBuffer(srcBuf, params)
+		require.NoError(t, err)
+		defer srcImg.Close()
+		assert.Equal(t, srcImg.Height()/srcImg.PageHeight(), img.Height()/img.PageHeight())
+	})
+}
+
+func TestExportFallback(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	params := imagorpath.Params{Filters: imagorpath.Filters{{Name: "format", Args: "avif"}}}
+	disabled := map[vips.ImageType]bool{vips.ImageTypeAVIF: true}
+	exportImage := func(
+		image *vips.ImageRef, format vips.ImageType, opts exportOptions,
+	) ([]byte, *vips.ImageMetadata, error) {
+		if disabled[format] {
+			return nil, nil, errors.New("encoder not available")
+		}
+		return export(image, format, opts)
+	}
+
+	v := New()
+	v.exportImage = exportImage
+	_, err := v.Process(ctx, blob, params, nil)
+	assert.Error(t, err)
+
+	v = New(WithFallbackFormats("avif,webp,jpeg"))
+	v.exportImage = exportImage
+	out, err := v.Process(ctx, blob, params, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "webp", out.Meta.Format)
+	assert.Equal(t, "image/webp", out.Meta.ContentType)
+
+	disabled[vips.ImageTypeWEBP] = true
+	out, err = v.Process(ctx, blob, params, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", out.Meta.Format)
+	assert.Equal(t, "image/jpeg", out.Meta.ContentType)
+
+	disabled[vips.ImageTypeJPEG] = true
+	_, err = v.Process(ctx, blob, params, nil)
+	assert.Error(t, err)
+}
+
+func TestDefaultFormat(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	v := New(WithDefaultFormat("webp"))
+	out, err := v.Process(ctx, blob, imagorpath.Params{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "webp", out.Meta.Format)
+	assert.Equal(t, "image/webp", out.Meta.ContentType)
+
+	out, err = v.Process(ctx, blob, imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "format", Args: "png"}},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "png", out.Meta.Format)
+
+	out, err = New().Process(ctx, blob, imagorpath.Params{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "png", out.Meta.Format)
+
+	out, err = New(WithDefaultFormat("jpeg")).Process(ctx,
+		imagor.NewBlobFilePath(filepath.Join(testDataDir, "dancing-banana.gif")),
+		imagorpath.Params{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", out.Meta.Format)
+	assert.Equal(t, 128, out.Meta.Height)
+}
+
+func TestMapPalette(t *testing.T) {
+	ctx := context.Background()
+	palette := color.Palette{
+		color.RGBA{A: 0xff},
+		color.RGBA{R: 0xff, A: 0xff},
+		color.RGBA{G: 0xff, A: 0xff},
+		color.RGBA{B: 0xff, A: 0xff},
+		color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+	gradient := image.NewNRGBA(image.Rect(0, 0, 256, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 256; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x), G: uint8(255 - x), B: uint8(y * 8), A: 0xff,
+			})
+		}
+	}
+	var w bytes.Buffer
+	require.NoError(t, png.Encode(&w, gradient))
+	v := New(WithPalette("brand", "000,f00,0f0,00f,fff"))
+	for _, args := range []string{"brand", "brand,dither"} {
+		t.Run(args, func(t *testing.T) {
+			out, err := v.Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+				Filters: imagorpath.Filters{
+					{Name: "map_palette", Args: args},
+					{Name: "format", Args: "png"},
+				},
+			}, nil)
+			require.NoError(t, err)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(buf))
+			require.NoError(t, err)
+			assert.Equal(t, gradient.Bounds(), img.Bounds())
+			colors := map[color.RGBA]bool{}
+			for y := 0; y < 32; y++ {
+				for x := 0; x < 256; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					colors[color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff}] = true
+				}
+			}
+			assert.Greater(t, len(colors), 1)
+			for c := range colors {
+				assert.Contains(t, palette, c)
+			}
+		})
+	}
+}
+
+func exifIFD0Tags(buf []byte) (tags []uint16) {
+	tiff := exifTIFF(buf)
+	if len(tiff) < 8 {
+		return
+	}
+	bo := binary.ByteOrder(binary.BigEndian)
+	if string(tiff[:2]) == "II" {
+		bo = binary.LittleEndian
+	}
+	ifd := int(bo.Uint32(tiff[4:]))
+	for i := 0; i < int(bo.Uint16(tiff[ifd:])); i++ {
+		tags = append(tags, bo.Uint16(tiff[ifd+2+i*12:]))
+	}
+	return
+}
+
+func TestStripGPS(t *testing.T) {
+	// IFD0 of Make and GPS pointer, GPS IFD of GPSLatitudeRef
+	var tiff bytes.Buffer
+	be := binary.BigEndian
+	tiff.WriteString("MM\x00\x2a")
+	_ = binary.Write(&tiff, be, uint32(8))
+	_ = binary.Write(&tiff, be, []uint16{2, 0x010F, 2})
+	_ = binary.Write(&tiff, be, []uint32{7, 38})
+	_ = binary.Write(&tiff, be, []uint16{0x8825, 4})
+	_ = binary.Write(&tiff, be, []uint32{1, 46, 0})
+	tiff.WriteString("Gopher\x00\x00")
+	_ = binary.Write(&tiff, be, []uint16{1, 0x0001, 2})
+	_ = binary.Write(&tiff, be, uint32(2))
+	tiff.WriteString("N\x00\x00\x00")
+	_ = binary.Write(&tiff, be, uint32(0))
+
+	var img bytes.Buffer
+	require.NoError(t, jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil))
+	app1 := append(append([]byte{}, exifHeader...), tiff.Bytes()...)
+	src := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	src = append(src, img.Bytes()[2:]...)
+	assert.Equal(t, []uint16{0x010F, exifGPSTag}, exifIFD0Tags(src))
+
+	stripped := stripGPS(append([]byte{}, src...))
+	assert.Equal(t, []uint16{0x010F}, exifIFD0Tags(stripped))
+	assert.True(t, bytes.Contains(exifTIFF(stripped), []byte("Gopher")))
+	assert.False(t, bytes.Contains(exifTIFF(stripped), []byte("N\x00\x00\x00")))
+	assert.Equal(t, []byte("foo"), stripGPS([]byte("foo")))
+
+	ctx := context.Background()
+	for _, format := range []string{"jpeg", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(src), imagorpath.Params{
+				Filters: imagorpath.Filters{{Name: "format", Args: format}},
+			}, nil)
+			require.NoError(t, err)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			assert.Contains(t, exifIFD0Tags(buf), uint16(exifGPSTag))
+
+			out, err = New().Process(ctx, imagor.NewBlobBytes(src), imagorpath.Params{
+				Filters: imagorpath.Filters{
+					{Name: "format", Args: format},
+					{Name: "strip_gps"},
+				},
+			}, nil)
+			require.NoError(t, err)
+			buf, err = out.ReadAll()
+			require.NoError(t, err)
+			tags := exifIFD0Tags(buf)
+			assert.Contains(t, tags, uint16(0x010F))
+			assert.NotContains(t, tags, uint16(exifGPSTag))
+		})
+	}
+}
+
+func TestSourceQuality(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for _, quality := range []int{10, 50, 75, 90, 100} {
+		t.Run(strconv.Itoa(quality), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality}))
+			assert.Equal(t, quality, jpegQuality(buf.Bytes()))
+
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{
+				Meta: true,
+				Filters: imagorpath.Filters{
+					{Name: "quality", Args: "30"},
+				},
+			}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, quality, out.Meta.SourceQuality)
+		})
+	}
+	assert.Equal(t, 0, jpegQuality([]byte("foo")))
+
+	out, err := New().Process(ctx, imagor.NewBlobFilePath(
+		filepath.Join(testDataDir, "gopher-front.png")), imagorpath.Params{Meta: true}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, out.Meta.SourceQuality)
+}
+
+// vips concurrency is process-wide and applied once by Startup, no per-request concurrency.
+// Benchmarks small, large and mixed workloads of the concurrency by BENCH_VIPS_CONCURRENCY,
+// compared by separate runs as vips cannot be restarted within the same process e.g.
+// BENCH_VIPS_CONCURRENCY=1 go test -run='^$' -bench=Process -cpu=1,4
+// BENCH_VIPS_CONCURRENCY=4 go test -run='^$' -bench=Process -cpu=1,4
+func BenchmarkProcess(b *testing.B) {
+	ctx := context.Background()
+	concurrency := 1
+	if n, err := strconv.Atoi(os.Getenv("BENCH_VIPS_CONCURRENCY")); err == nil && n != 0 {
+		concurrency = n
+	}
+	// no shutdown as vips cannot be started again
+	require.NoError(b, New(WithConcurrency(concurrency)).Startup(ctx))
+	b.Logf("vips concurrency %d", concurrency)
+
+	// JPEG of shrink-on-load, generated as no large JPEG in testdata
+	src := image.NewNRGBA(image.Rect(0, 0, 4000, 3000))
+	for y := 0; y < 3000; y++ {
+		for x := 0; x < 4000; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+	var w bytes.Buffer
+	require.NoError(b, jpeg.Encode(&w, src, &jpeg.Options{Quality: 90}))
+	largeJPEG := w.Bytes()
+	small, err := ioutil.ReadFile(filepath.Join(testDataDir, "gopher-front.png"))
+	require.NoError(b, err)
+	large, err := ioutil.ReadFile(filepath.Join(testDataDir, "gopher.png"))
+	require.NoError(b, err)
+
+	type job struct {
+		buf    []byte
+		params imagorpath.Params
+	}
+	var (
+		smallJob      = job{small, imagorpath.Params{Width: 50, Height: 50}}
+		largeJob      = job{large, imagorpath.Params{Width: 800, Height: 800}}
+		downscaleJob  = job{large, imagorpath.Params{Width: 100, Height: 100, FitIn: true}}
+		jpegJob       = job{largeJPEG, imagorpath.Params{Width: 100, Height: 100, FitIn: true}}
+		mixedWorkload = []job{smallJob, smallJob, smallJob, smallJob, smallJob, smallJob, smallJob, smallJob, smallJob, largeJob}
+	)
+	for _, bb := range []struct {
+		name    string
+		jobs    []job
+		options []Option
+	}{
+		{"small", []job{smallJob}, nil},
+		{"large", []job{largeJob}, nil},
+		{"mixed 9 small 1 large", mixedWorkload, nil},
+		{"large to small", []job{downscaleJob}, nil},
+		{"large to small two-step", []job{downscaleJob}, []Option{WithTwoStepDownscale(4)}},
+		{"large jpeg to small", []job{jpegJob}, nil},
+		{"large jpeg to small two-step", []job{jpegJob}, []Option{WithTwoStepDownscale(4)}},
+	} {
+		v := New(bb.options...)
+		jobs := bb.jobs
+		b.Run(bb.name, func(b *testing.B) {
+			var cnt uint64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					j := jobs[int(atomic.AddUint64(&cnt, 1))%len(jobs)]
+					if _, err := v.Process(ctx, imagor.NewBlobBytes(j.buf), j.params, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// countdownCtx deadline exceeded after n checks of Err
+type countdownCtx struct {
+	context.Context
+	n int
+}
+
+func (c *countdownCtx) Err() error {
+	if c.n--; c.n < 0 {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// expiredCtx of deadline already passed but not yet reported by Err
+type expiredCtx struct {
+	context.Context
+}
+
+func (c expiredCtx) Deadline() (time.Time, bool) {
+	return time.Now(), true
+}
+
+func TestPartialAnimation(t *testing.T) {
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "nyan-cat.gif"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	t.Run("partial frames", func(t *testing.T) {
+		out, err := New(WithPartialAnimation(true)).Process(
+			expiredCtx{ctx}, blob, imagorpath.Params{Width: 200}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "gif", out.Meta.Format)
+		n, err := New().pageN(out)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n, "first frame rendered")
+	})
+	t.Run("all frames", func(t *testing.T) {
+		out, err := New(WithPartialAnimation(true)).Process(
+			ctx, blob, imagorpath.Params{Width: 200}, nil)
+		require.NoError(t, err)
+		n, err := New().pageN(out)
+		require.NoError(t, err)
+		assert.Equal(t, 12, n)
+	})
+	t.Run("no frames", func(t *testing.T) {
+		_, err := New(WithPartialAnimation(true)).Process(
+			&countdownCtx{ctx, 0}, blob, imagorpath.Params{Width: 200}, nil)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		out, err := New().Process(
+			&countdownCtx{ctx, 5}, blob, imagorpath.Params{Width: 200}, nil)
+		require.NoError(t, err)
+		n, err := New().pageN(out)
+		require.NoError(t, err)
+		assert.Equal(t, 12, n)
+	})
+}
+
+func TestReplaceColor(t *testing.T) {
+	ctx := context.Background()
+	var (
+		white = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+		red   = color.NRGBA{R: 0xff, A: 0xff}
+		near  = color.NRGBA{R: 0xf0, G: 0x10, B: 0x10, A: 0xff}
+		far   = color.NRGBA{R: 0xc0, A: 0xff}
+		green = color.NRGBA{G: 0xff, A: 0xff}
+	)
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(src, src.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(10, 10, 30, 30), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(40, 10, 50, 20), image.NewUniform(near), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(40, 40, 50, 50), image.NewUniform(far), image.Point{}, draw.Src)
+	var w bytes.Buffer
+	require.NoError(t, png.Encode(&w, src))
+
+	out, err := New().Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "replace_color", Args: "ff0000,00ff00,30"}},
+	}, nil)
+	require.NoError(t, err)
+	buf, err := out.ReadAll()
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(buf))
+	require.NoError(t, err)
+	at := func(x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	assert.Equal(t, green, at(10, 10))
+	assert.Equal(t, green, at(29, 29))
+	assert.Equal(t, green, at(45, 15))
+	assert.Equal(t, far, at(45, 45))
+	assert.Equal(t, white, at(9, 9))
+	assert.Equal(t, white, at(30, 30))
+	assert.Equal(t, white, at(60, 60))
+}
+
+func TestSvgLQIP(t *testing.T) {
+	ctx := context.Background()
+	out, err := New().Process(ctx,
+		imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png")),
+		imagorpath.Params{Width: 20, Filters: imagorpath.Filters{{Name: "svg_lqip", Args: "2"}}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "svg", out.Meta.Format)
+	assert.Equal(t, "image/svg+xml", out.Meta.ContentType)
+	assert.Equal(t, 20, out.Meta.Width)
+	assert.Equal(t, 26, out.Meta.Height)
+	buf, err := out.ReadAll()
+	require.NoError(t, err)
+
+	var svg struct {
+		XMLName xml.Name `xml:"svg"`
+		Width   int      `xml:"width,attr"`
+		Height  int      `xml:"height,attr"`
+		ViewBox string   `xml:"viewBox,attr"`
+		Blur    struct {
+			StdDeviation string `xml:"stdDeviation,attr"`
+		} `xml:"filter>feGaussianBlur"`
+		Image struct {
+			Href string `xml:"href,attr"`
+		} `xml:"image"`
+	}
+	require.NoError(t, xml.Unmarshal(buf, &svg))
+	assert.Equal(t, 20, svg.Width)
+	assert.Equal(t, 26, svg.Height)
+	assert.Equal(t, "0 0 20 26", svg.ViewBox)
+	assert.Equal(t, "2", svg.Blur.StdDeviation)
+	require.True(t, strings.HasPrefix(svg.Image.Href, "data:image/png;base64,"))
+	raster, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(svg.Image.Href, "data:image/png;base64,"))
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(raster))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 26), img.Bounds())
+}
+
+func TestColorMatrix(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(src, image.Rect(0, 0, 8, 16), image.NewUniform(color.NRGBA{R: 200, G: 100, B: 50, A: 0xff}), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(8, 0, 16, 16), image.NewUniform(color.NRGBA{R: 10, G: 20, B: 30, A: 0x80}), image.Point{}, draw.Src)
+	var w bytes.Buffer
+	require.NoError(t, png.Encode(&w, src))
+	process := func(args string) (image.Image, error) {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "color_matrix", Args: args}},
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		return png.Decode(bytes.NewReader(buf))
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	t.Run("identity", func(t *testing.T) {
+		img, err := process("1,0,0,0,1,0,0,0,1")
+		require.NoError(t, err)
+		assert.Equal(t, color.NRGBA{R: 200, G: 100, B: 50, A: 0xff}, at(img, 2, 2))
+		assert.Equal(t, color.NRGBA{R: 10, G: 20, B: 30, A: 0x80}, at(img, 12, 12))
+	})
+	t.Run("swap and offset", func(t *testing.T) {
+		// swap red and blue, offset green by 20, double blue with clipping
+		img, err := process("0,0,1,0,0,1,0,20,2,0,0,0")
+		require.NoError(t, err)
+		assert.Equal(t, color.NRGBA{R: 50, G: 120, B: 255, A: 0xff}, at(img, 2, 2))
+		assert.Equal(t, color.NRGBA{R: 30, G: 40, B: 20, A: 0x80}, at(img, 12, 12))
+	})
+	t.Run("16-bit image", func(t *testing.T) {
+		src := image.NewNRGBA64(image.Rect(0, 0, 8, 8))
+		draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA64{R: 0x1234, G: 0x5678, B: 0x9abc, A: 0xffff}), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, src))
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "color_matrix", Args: "1,0,0,0,0,1,0,20,0,0,1,0"}},
+		}, nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		c := color.NRGBA64Model.Convert(img.At(4, 4)).(color.NRGBA64)
+		assert.InDelta(t, 0x1234, int(c.R), 257, "not clipped")
+		assert.InDelta(t, 0x5678+20*257, int(c.G), 257, "offset of 8-bit scale")
+		assert.InDelta(t, 0x9abc, int(c.B), 257, "not clipped")
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := process("1,0,0,0,1,0,0,0")
+		assert.Equal(t, http.StatusBadRequest, imagor.WrapError(err).(imagor.Error).Code)
+		_, err = process("1,0,0,0,1,0,0,0,a")
+		assert.Equal(t, http.StatusBadRequest, imagor.WrapError(err).(imagor.Error).Code)
+	})
+}
+
+func TestCanvas(t *testing.T) {
+	ctx := context.Background()
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	t.Run("solid color", func(t *testing.T) {
+		out, err := New().Process(ctx, nil, imagorpath.Params{
+			Filters: imagorpath.Filters{
+				{Name: "canvas", Args: "40,30,cccccc"},
+				{Name: "format", Args: "png"},
+			},
+		}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 40, out.Meta.Width)
+		assert.Equal(t, 30, out.Meta.Height)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 40, 30), img.Bounds())
+		grey := color.NRGBA{R: 0xcc, G: 0xcc, B: 0xcc, A: 0xff}
+		assert.Equal(t, grey, at(img, 0, 0))
+		assert.Equal(t, grey, at(img, 20, 15))
+		assert.Equal(t, grey, at(img, 39, 29))
+	})
+	t.Run("transparent", func(t *testing.T) {
+		out, err := New().Process(ctx, nil, imagorpath.Params{
+			Filters: imagorpath.Filters{
+				{Name: "canvas", Args: "20,20,none"},
+				{Name: "format", Args: "png"},
+			},
+		}, nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		assert.Equal(t, uint8(0), at(img, 10, 10).A)
+	})
+	t.Run("invalid size", func(t *testing.T) {
+		_, err := New(WithMaxWidth(100)).Process(ctx, nil, imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "canvas", Args: "400,300"}},
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, imagor.WrapError(err).(imagor.Error).Code)
+	})
+	t.Run("no canvas", func(t *testing.T) {
+		_, err := New().Process(ctx, nil, imagorpath.Params{}, nil)
+		assert.Equal(t, imagor.ErrNotFound, err)
+	})
+}
+
+func TestWatermarkOrientation(t *testing.T) {
+	// IFD0 of Orientation 6, displayed rotated 90 degrees clockwise
+	var tiff bytes.Buffer
+	be := binary.BigEndian
+	tiff.WriteString("MM\x00\x2a")
+	_ = binary.Write(&tiff, be, uint32(8))
+	_ = binary.Write(&tiff, be, []uint16{1, 0x0112, 3})
+	_ = binary.Write(&tiff, be, uint32(1))
+	_ = binary.Write(&tiff, be, []uint16{6, 0})
+	_ = binary.Write(&tiff, be, uint32(0))
+
+	base := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(base, base.Bounds(), image.White, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, base, &jpeg.Options{Quality: 100}))
+	app1 := append(append([]byte{}, exifHeader...), tiff.Bytes()...)
+	src := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	src = append(src, buf.Bytes()[2:]...)
+
+	mark := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(mark, mark.Bounds(), image.NewUniform(color.RGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	buf.Reset()
+	require.NoError(t, png.Encode(&buf, mark))
+	load := func(string) (*imagor.Blob, error) {
+		return imagor.NewBlobBytes(buf.Bytes()), nil
+	}
+	isRed := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return r>>8 > 0xe0 && g>>8 < 0x20 && b>>8 < 0x20
+	}
+
+	for _, tt := range []struct {
+		x, y   string
+		px, py int
+	}{
+		{"right", "bottom", 19, 39},
+		{"left", "bottom", 0, 39},
+		{"right", "top", 19, 0},
+		{"0", "0", 0, 0},
+	} {
+		t.Run(tt.x+"-"+tt.y, func(t *testing.T) {
+			ctx := WithInitImageRefs(context.Background())
+			defer CloseImageRefs(ctx)
+			// loaded without auto rotate as of special ops
+			img, err := vips.NewImageFromBuffer(src)
+			require.NoError(t, err)
+			AddImageRef(ctx, img)
+			require.Equal(t, 6, img.Orientation())
+			require.NoError(t, New().watermark(ctx, img, load, "mark.png", tt.x, tt.y))
+			assert.Equal(t, 20, img.Width())
+			assert.Equal(t, 40, img.PageHeight())
+			out, _, err := img.ExportPng(vips.NewPngExportParams())
+			require.NoError(t, err)
+			m, err := png.Decode(bytes.NewReader(out))
+			require.NoError(t, err)
+			assert.True(t, isRed(m.At(tt.px, tt.py)))
+			assert.False(t, isRed(m.At(19-tt.px, 39-tt.py)))
+		})
+	}
+}
+
+func TestAlphaQuality(t *testing.T) {
+	assert.Equal(t, 2, webpAlphaLevels(0))
+	assert.Equal(t, 12, webpAlphaLevels(50))
+	assert.Equal(t, 16, webpAlphaLevels(70))
+	assert.Equal(t, 256, webpAlphaLevels(100))
+
+	// soft transparency of horizontal alpha gradient
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		draw.Draw(src, image.Rect(x, 0, x+1, 64),
+			image.NewUniform(color.NRGBA{R: 0xff, A: uint8(x * 4)}), image.Point{}, draw.Src)
+	}
+	var w bytes.Buffer
+	require.NoError(t, png.Encode(&w, src))
+	ctx := context.Background()
+	process := func(filters ...imagorpath.Filter) []byte {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+			Filters: append(imagorpath.Filters{{Name: "format", Args: "webp"}}, filters...),
+		}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "webp", out.Meta.Format)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		return buf
+	}
+	// distinct alpha values and max alpha deviation from source
+	fidelity := func(buf []byte) (levels, maxDiff int) {
+		img, err := webp.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		seen := map[uint8]bool{}
+		for x := 0; x < 64; x++ {
+			a := color.NRGBAModel.Convert(img.At(x, 32)).(color.NRGBA).A
+			seen[a] = true
+			if d := int(a) - x*4; d > maxDiff {
+				maxDiff = d
+			} else if -d > maxDiff {
+				maxDiff = -d
+			}
+		}
+		return len(seen), maxDiff
+	}
+	original := process()
+	assert.Equal(t, original, process(imagorpath.Filter{Name: "alpha_quality", Args: "100"}))
+	levels, diff := fidelity(original)
+	assert.Equal(t, 64, levels)
+	assert.Equal(t, 0, diff)
+
+	low := process(imagorpath.Filter{Name: "alpha_quality", Args: "0"})
+	levels, diff = fidelity(low)
+	assert.LessOrEqual(t, levels, 2)
+	assert.LessOrEqual(t, diff, 128)
+
+	mid := process(imagorpath.Filter{Name: "alpha_quality", Args: "50"})
+	levels, diff = fidelity(mid)
+	assert.LessOrEqual(t, levels, 12)
+	assert.Greater(t, levels, 2)
+	assert.LessOrEqual(t, diff, 13)
+
+	assert.Less(t, len(low), len(mid))
+	assert.Less(t, len(mid), len(original))
+
+	// not applicable to other formats
+	out, err := New().Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "format", Args: "png"}, {Name: "alpha_quality", Args: "0"}},
+	}, nil)
+	require.NoError(t, err)
+	buf, err := out.ReadAll()
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(buf))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(100), color.NRGBAModel.Convert(img.At(25, 32)).(color.NRGBA).A)
+}
+
+func TestMaxFilterArgLength(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	long := "https://example.com/" + strings.Repeat("a", 100) + ".png"
+	v := New(WithMaxFilterArgLength(100))
+	t.Run("exceeded", func(t *testing.T) {
+		_, err := v.Process(ctx, blob, imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "watermark", Args: long + ",0,0"}},
+		}, nil)
+		assert.Equal(t, imagor.ErrMaxArgLenExceeded, err)
+		_, err = v.Process(ctx, blob, imagorpath.Params{
+			Filters: imagorpath.Filters{
+				{Name: "grayscale"},
+				{Name: "fill", Args: "white," + strings.Repeat("1", 101)},
+			},
+		}, nil)
+		assert.Equal(t, imagor.ErrMaxArgLenExceeded, err)
+	})
+	t.Run("within limit", func(t *testing.T) {
+		out, err := v.Process(ctx, blob, imagorpath.Params{
+			Filters: imagorpath.Filters{
+				{Name: "fill", Args: strings.Repeat("f", 6)},
+				{Name: "rotate", Args: strings.Repeat("0", 100)},
+			},
+		}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 202, out.Meta.Width)
+	})
+	t.Run("unlimited by default", func(t *testing.T) {
+		_, err := New().Process(ctx, blob, imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "rotate", Args: strings.Repeat("0", 200)}},
+		}, nil)
+		require.NoError(t, err)
+	})
+}
+
+func TestCropMaxSize(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+	v := New(WithMaxWidth(100), WithMaxHeight(100))
+	_, err := v.Process(ctx, blob, imagorpath.Parse("0x0:50x50/demo1.jpg"), nil)
+	assert.Equal(t, imagor.ErrMaxSizeExceeded, err, "crop source exceeds max size")
+	_, err = v.Process(ctx, blob, imagorpath.Parse("filters:no_shrink_on_load()/demo1.jpg"), nil)
+	assert.Equal(t, imagor.ErrMaxSizeExceeded, err, "full decode source exceeds max size")
+
+	out, err := v.Process(ctx, blob, imagorpath.Parse("demo1.jpg"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 100, out.Meta.Width, "shrink on load within max size")
+	out, err = New().Process(ctx, blob, imagorpath.Parse("0x0:50x50/demo1.jpg"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 50, out.Meta.Width)
+}
+
+func TestCropBias(t *testing.T) {
+	// red and green encode x and y position
+	src := image.NewNRGBA(image.Rect(0, 0, 120, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 120; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 2), G: uint8(y * 2), A: 0xff})
+		}
+	}
+	var w bytes.Buffer
+	require.NoError(t, png.Encode(&w, src))
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name          string
+		width, height int
+		args          string
+		left, top     int
+	}{
+		{"center", 60, 120, "0,0", 30, 0},
+		{"horizontal bias", 60, 120, "0.2,-0.1", 36, 0},
+		{"horizontal left", 60, 120, "-1", 0, 0},
+		{"horizontal right", 60, 120, "1,1", 60, 0},
+		{"horizontal clamped", 60, 120, "-5", 0, 0},
+		{"vertical bias", 120, 60, "0.2,-0.1", 0, 27},
+		{"vertical bottom", 120, 60, "0,1", 0, 60},
+		{"vertical top", 120, 60, "0,-1", 0, 0},
+		{"invalid", 120, 60, "foo,NaN", 0, 30},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+				Width:  tt.width,
+				Height: tt.height,
+				Filters: imagorpath.Filters{
+					{Name: "crop_bias", Args: tt.args},
+					{Name: "format", Args: "png"},
+				},
+			}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.width, out.Meta.Width)
+			assert.Equal(t, tt.height, out.Meta.Height)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(buf))
+			require.NoError(t, err)
+			c := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+			assert.InDelta(t, tt.left*2, int(c.R), 1)
+			assert.InDelta(t, tt.top*2, int(c.G), 1)
+		})
+	}
+}
+
+func TestAnimationBackground(t *testing.T) {
+	// animation of blue square moving on transparent background
+	palette := color.Palette{color.RGBA{}, color.RGBA{B: 0xff, A: 0xff}}
+	src := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 30, 30), palette)
+		for y := 0; y < 10; y++ {
+			for x := i * 10; x < i*10+10; x++ {
+				frame.SetColorIndex(x, y, 1)
+			}
+		}
+		src.Image = append(src.Image, frame)
+		src.Delay = append(src.Delay, 10)
+		src.Disposal = append(src.Disposal, gif.DisposalBackground)
+	}
+	var w bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&w, src))
+	ctx := context.Background()
+
+	// animated WebP with per-frame transparency
+	out, err := New().Process(ctx, imagor.NewBlobBytes(w.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "format", Args: "webp"}},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "webp", out.Meta.Format)
+	webpBuf, err := out.ReadAll()
+	require.NoError(t, err)
+
+	// composited frames as displayed
+	frames := func(filters ...imagorpath.Filter) []*image.RGBA {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(webpBuf), imagorpath.Params{
+			Filters: append(filters, imagorpath.Filter{Name: "format", Args: "gif"}),
+		}, nil)
+		require.NoError(t, err)
+		require.Equal(t, "gif", out.Meta.Format)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		g, err := gif.DecodeAll(bytes.NewReader(buf))
+		require.NoError(t, err)
+		canvas := image.NewRGBA(image.Rect(0, 0, 30, 30))
+		var res []*image.RGBA
+		for _, frame := range g.Image {
+			draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			res = append(res, image.NewRGBA(canvas.Bounds()))
+			draw.Draw(res[len(res)-1], canvas.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+		return res
+	}
+	at := func(img image.Image, x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	var (
+		red  = color.RGBA{R: 0xff, A: 0xff}
+		blue = color.RGBA{B: 0xff, A: 0xff}
+	)
+	t.Run("transparent", func(t *testing.T) {
+		res := frames()
+		require.Len(t, res, 3)
+		assert.Equal(t, uint8(0), at(res[0], 25, 25).A)
+	})
+	t.Run("uniform background", func(t *testing.T) {
+		res := frames(imagorpath.Filter{Name: "animation_bg", Args: "ff0000"})
+		require.Len(t, res, 3)
+		for i, frame := range res {
+			assert.Equal(t, blue, at(frame, i*10+5, 5), "frame "+strconv.Itoa(i))
+			assert.Equal(t, red, at(frame, 25, 25), "frame "+strconv.Itoa(i))
+			for j := 0; j < 3; j++ {
+				if j != i {
+					// no trace of square of other frames
+					assert.Equal(t, red, at(frame, j*10+5, 5), "frame "+strconv.Itoa(i))
+				}
+			}
+		}
+	})
+	t.Run("auto color of first frame", func(t *testing.T) {
+		res := frames(imagorpath.Filter{Name: "animation_bg", Args: "auto"})
+		require.Len(t, res, 3)
+		// top-left of first frame is blue
+		for i, frame := range res {
+			assert.Equal(t, blue, at(frame, 25, 25), "frame "+strconv.Itoa(i))
+		}
+	})
+}
+
+func TestParseCSSFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		css     string
+		filters imagorpath.Filters
+	}{
+		{"representative", "brightness(1.1) contrast(1.2) saturate(0.9)", imagorpath.Filters{
+			{Name: "modulate", Args: "10,0,0"},
+			{Name: "contrast", Args: "9.168"},
+			{Name: "saturation", Args: "-10"},
+		}},
+		{"url escaped percentage", "brightness(90%25)%20saturate(150%25)", imagorpath.Filters{
+			{Name: "modulate", Args: "-10,0,0"},
+			{Name: "saturation", Args: "50"},
+		}},
+		{"defaults", "grayscale() contrast() blur()", imagorpath.Filters{
+			{Name: "saturation", Args: "-100"},
+			{Name: "contrast", Args: "0"},
+			{Name: "blur", Args: "0"},
+		}},
+		{"units", "blur(5px) hue-rotate(0.25turn) hue-rotate(-90deg) hue-rotate(100grad)", imagorpath.Filters{
+			{Name: "blur", Args: "5"},
+			{Name: "hue", Args: "90"},
+			{Name: "hue", Args: "-90"},
+			{Name: "hue", Args: "90"},
+		}},
+		{"color matrix", "sepia(1) invert(0.5) grayscale(0)", imagorpath.Filters{
+			{Name: "color_matrix", Args: "0.393,0.769,0.189,0.349,0.686,0.168,0.272,0.534,0.131"},
+			{Name: "color_matrix", Args: "0,0,0,127.5,0,0,0,127.5,0,0,0,127.5"},
+			{Name: "saturation", Args: "0"},
+		}},
+		{"empty", " ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := parseCSSFilter(tt.css)
+			require.NoError(t, err)
+			assert.Equal(t, tt.filters, filters)
+		})
+	}
+	for _, css := range []string{
+		"opacity(0.5)",
+		"brightness(1.1) drop-shadow(1px 1px red)",
+		"brightness(1.1) foo",
+		"url(filter.svg)",
+	} {
+		t.Run(css, func(t *testing.T) {
+			_, err := parseCSSFilter(css)
+			assert.Equal(t, http.StatusBadRequest, imagor.WrapError(err).(imagor.Error).Code)
+		})
+	}
+	t.Run("process error", func(t *testing.T) {
+		_, err := New().Process(context.Background(),
+			imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png")),
+			imagorpath.Params{Filters: imagorpath.Filters{{Name: "css", Args: "opacity(0.5)"}}}, nil)
+		assert.Equal(t, http.StatusBadRequest, imagor.WrapError(err).(imagor.Error).Code)
+	})
+}
+
+func TestEnlarge(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	process := func(path string) image.Image {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		return img
+	}
+	// mean gradient magnitude of luminance as sharpness measure
+	sharpness := func(img image.Image) float64 {
+		b := img.Bounds()
+		lum := func(x, y int) float64 {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			return 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(bl>>8)
+		}
+		var sum float64
+		for y := b.Min.Y; y < b.Max.Y-1; y++ {
+			for x := b.Min.X; x < b.Max.X-1; x++ {
+				sum += math.Abs(lum(x+1, y)-lum(x, y)) + math.Abs(lum(x, y+1)-lum(x, y))
+			}
+		}
+		return sum / float64(b.Dx()*b.Dy())
+	}
+	t.Run("upscale", func(t *testing.T) {
+		plain := process("fit-in/606x777/filters:upscale():format(png)/gopher-front.png")
+		enlarged := process("fit-in/606x777/filters:upscale():enlarge():format(png)/gopher-front.png")
+		stronger := process("fit-in/606x777/filters:upscale():enlarge(2):format(png)/gopher-front.png")
+		assert.Equal(t, plain.Bounds(), enlarged.Bounds())
+		assert.Equal(t, 606, enlarged.Bounds().Dx())
+		assert.Greater(t, sharpness(enlarged), sharpness(plain))
+		assert.Greater(t, sharpness(stronger), sharpness(enlarged))
+	})
+	t.Run("downscale unaffected", func(t *testing.T) {
+		plain := process("fit-in/100x100/filters:format(png)/gopher-front.png")
+		enlarged := process("fit-in/100x100/filters:enlarge():format(png)/gopher-front.png")
+		assert.Equal(t, plain, enlarged)
+	})
+}
+
+func TestNoShrinkOnLoad(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+	process := func(path string) image.Image {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		return img
+	}
+	for _, path := range []string{
+		"fit-in/100x100/filters:format(png)/demo1.jpg",
+		"100x100/filters:format(png)/demo1.jpg",
+		"stretch/100x50/filters:format(png)/demo1.jpg",
+	} {
+		t.Run(path, func(t *testing.T) {
+			shrunk := process(path)
+			full := process(strings.Replace(path, "filters:", "filters:no_shrink_on_load():", 1))
+			require.Equal(t, shrunk.Bounds(), full.Bounds())
+			// shrink-on-load decodes jpeg at reduced scale, resulting slightly different pixels
+			assert.NotEqual(t, shrunk, full)
+			b := full.Bounds()
+			var diff float64
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r1, g1, b1, _ := shrunk.At(x, y).RGBA()
+					r2, g2, b2, _ := full.At(x, y).RGBA()
+					diff += math.Abs(float64(r1>>8)-float64(r2>>8)) +
+						math.Abs(float64(g1>>8)-float64(g2>>8)) +
+						math.Abs(float64(b1>>8)-float64(b2>>8))
+				}
+			}
+			assert.Less(t, diff/float64(b.Dx()*b.Dy()*3), 8.0)
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	encode := func(m image.Image) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, m))
+		return buf.Bytes()
+	}
+	base := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(base, base.Bounds(), image.NewUniform(color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}), image.Point{}, draw.Src)
+	altered := image.NewNRGBA(base.Bounds())
+	copy(altered.Pix, base.Pix)
+	draw.Draw(altered, image.Rect(20, 20, 40, 40), image.NewUniform(color.NRGBA{R: 0x90, G: 0x80, B: 0x80, A: 0xff}), image.Point{}, draw.Src)
+	srcBuf := encode(base)
+	files := map[string][]byte{
+		"same.png":    srcBuf,
+		"altered.png": encode(altered),
+	}
+	load := func(image string) (*imagor.Blob, error) {
+		if buf, ok := files[image]; ok {
+			return imagor.NewBlobBytes(buf), nil
+		}
+		return nil, imagor.ErrNotFound
+	}
+	process := func(args string) image.Image {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(srcBuf), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "diff", Args: args}},
+		}, load)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		return img
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	black := color.NRGBA{A: 0xff}
+
+	t.Run("identical", func(t *testing.T) {
+		img := process("same.png")
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				require.Equal(t, black, at(img, x, y))
+			}
+		}
+	})
+	t.Run("altered", func(t *testing.T) {
+		img := process("altered.png")
+		assert.Equal(t, color.NRGBA{R: 0x10, A: 0xff}, at(img, 30, 30))
+		assert.Equal(t, black, at(img, 10, 10))
+		assert.Equal(t, black, at(img, 50, 50))
+	})
+	t.Run("amplified", func(t *testing.T) {
+		img := process("altered.png,8")
+		assert.Equal(t, color.NRGBA{R: 0x80, A: 0xff}, at(img, 30, 30))
+		assert.Equal(t, black, at(img, 10, 10))
+	})
+	t.Run("not found", func(t *testing.T) {
+		_, err := New().Process(ctx, imagor.NewBlobBytes(srcBuf), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "diff", Args: "missing.png"}},
+		}, load)
+		assert.Equal(t, imagor.ErrNotFound, err)
+	})
+}
+
+// tiltedHorizon sky and ground image with horizon rising to the right by deg
+func tiltedHorizon(w, h int, deg float64) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	t := math.Tan(deg * math.Pi / 180)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 0x90, G: 0xc0, B: 0xf0, A: 0xff}
+			if float64(y) > float64(h)/2-(float64(x)-float64(w)/2)*t {
+				c = color.NRGBA{R: 0x30, G: 0x60, B: 0x20, A: 0xff}
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+func TestHorizonAngle(t *testing.T) {
+	for _, tt := range []struct {
+		deg, maxAngle, expected float64
+	}{
+		{0, 10, 0},
+		{3, 10, 3},
+		{-5, 10, -5},
+		{8, 10, 8},
+		{20, 10, 10},
+		{5, 0, 0},
+	} {
+		angle := horizonAngle(tiltedHorizon(300, 200, tt.deg), tt.maxAngle)
+		assert.InDelta(t, tt.expected, angle, 0.2)
+	}
+	assert.Equal(t, 0.0, horizonAngle(image.NewNRGBA(image.Rect(0, 0, 100, 100)), 10))
+}
+
+func TestLevelHorizon(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, tiltedHorizon(600, 400, 6)))
+	for _, tt := range []struct {
+		args     string
+		residual float64
+	}{
+		{"", 0},
+		{"3", 3},
+		{"10,black", 0},
+	} {
+		t.Run(tt.args, func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{
+				Filters: imagorpath.Filters{{Name: "level_horizon", Args: tt.args}},
+			}, nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(res))
+			require.NoError(t, err)
+			assert.Equal(t, image.Rect(0, 0, 600, 400), img.Bounds())
+			m := image.NewNRGBA(img.Bounds())
+			draw.Draw(m, m.Bounds(), img, image.Point{}, draw.Src)
+			assert.InDelta(t, tt.residual, horizonAngle(m, 10), 0.5)
+		})
+	}
+}
+
+func TestWatermarkStacked(t *testing.T) {
+	ctx := context.Background()
+	encode := func(w, h int, c color.Color) []byte {
+		m := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(m, m.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, m))
+		return buf.Bytes()
+	}
+	var (
+		white = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+		red   = color.NRGBA{R: 0xff, A: 0xff}
+		blue  = color.NRGBA{B: 0xff, A: 0xff}
+	)
+	files := map[string][]byte{
+		"red.png":  encode(20, 20, red),
+		"blue.png": encode(20, 20, blue),
+	}
+	load := func(image string) (*imagor.Blob, error) {
+		return imagor.NewBlobBytes(files[image]), nil
+	}
+	src := encode(100, 100, white)
+	process := func(filters ...imagorpath.Filter) image.Image {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(src), imagorpath.Params{
+			Filters: append(filters, imagorpath.Filter{Name: "format", Args: "png"}),
+		}, load)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		return img
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+
+	t.Run("different positions", func(t *testing.T) {
+		img := process(
+			imagorpath.Filter{Name: "watermark", Args: "red.png,left,top"},
+			imagorpath.Filter{Name: "watermark", Args: "blue.png,right,bottom"},
+		)
+		assert.Equal(t, image.Rect(0, 0, 100, 100), img.Bounds())
+		assert.Equal(t, red, at(img, 10, 10))
+		assert.Equal(t, blue, at(img, 90, 90))
+		assert.Equal(t, white, at(img, 50, 50))
+		assert.Equal(t, white, at(img, 90, 10))
+		assert.Equal(t, white, at(img, 10, 90))
+	})
+	t.Run("overlapping in order", func(t *testing.T) {
+		img := process(
+			imagorpath.Filter{Name: "watermark", Args: "red.png,10,10"},
+			imagorpath.Filter{Name: "watermark", Args: "blue.png,20,20"},
+		)
+		assert.Equal(t, red, at(img, 15, 15))
+		assert.Equal(t, blue, at(img, 25, 25))
+		assert.Equal(t, blue, at(img, 35, 35))
+		assert.Equal(t, white, at(img, 45, 45))
+	})
+	t.Run("image only", func(t *testing.T) {
+		img := process(
+			imagorpath.Filter{Name: "watermark", Args: "red.png"},
+			imagorpath.Filter{Name: "watermark", Args: "blue.png,50,50"},
+		)
+		assert.Equal(t, red, at(img, 10, 10))
+		assert.Equal(t, blue, at(img, 60, 60))
+	})
+}
+
+func TestQuantize(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 2), G: uint8(y * 2), B: uint8(x + y), A: uint8(255 - y)})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	distinct := func(img image.Image) int {
+		colors := map[[3]uint32]bool{}
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				colors[[3]uint32{uint32(c.R), uint32(c.G), uint32(c.B)}] = true
+			}
+		}
+		return len(colors)
+	}
+	for _, n := range []int{2, 16, 64, 256} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{
+				Filters: imagorpath.Filters{{Name: "quantize", Args: strconv.Itoa(n)}},
+			}, nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(res))
+			require.NoError(t, err)
+			assert.Equal(t, src.Bounds(), img.Bounds())
+			assert.LessOrEqual(t, distinct(img), n)
+			assert.Greater(t, distinct(img), n/2)
+			// alpha kept
+			_, _, _, a := img.At(0, 127).RGBA()
+			assert.Equal(t, uint32(128), a>>8)
+		})
+	}
+	t.Run("within target", func(t *testing.T) {
+		assert.Nil(t, medianCut(src, 128*128))
+	})
+}
+
+func TestDepthBlur(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{A: 0xff}
+			if (x+y)%2 == 0 {
+				c = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	// mask of different size, left half sharp and right half blurred
+	mask := image.NewGray(image.Rect(0, 0, 32, 32))
+	draw.Draw(mask, image.Rect(0, 0, 16, 32), image.White, image.Point{}, draw.Src)
+	var srcBuf, maskBuf bytes.Buffer
+	require.NoError(t, png.Encode(&srcBuf, src))
+	require.NoError(t, png.Encode(&maskBuf, mask))
+	load := func(image string) (*imagor.Blob, error) {
+		if image == "mask.png" {
+			return imagor.NewBlobBytes(maskBuf.Bytes()), nil
+		}
+		return nil, imagor.ErrNotFound
+	}
+	out, err := New().Process(ctx, imagor.NewBlobBytes(srcBuf.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "depth_blur", Args: "mask.png,10"}},
+	}, load)
+	require.NoError(t, err)
+	buf, err := out.ReadAll()
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(buf))
+	require.NoError(t, err)
+	assert.Equal(t, src.Bounds(), img.Bounds())
+	lum := func(x, y int) float64 {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return float64(r >> 8)
+	}
+	for y := 4; y < 60; y += 7 {
+		for x := 4; x < 20; x++ {
+			// sharp region untouched
+			require.Equal(t, float64(src.Pix[src.PixOffset(x, y)]), lum(x, y))
+		}
+		for x := 44; x < 60; x++ {
+			// blurred region of checkerboard towards gray
+			require.InDelta(t, 128, lum(x, y), 24)
+		}
+	}
+
+	_, err = New().Process(ctx, imagor.NewBlobBytes(srcBuf.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "depth_blur", Args: "missing.png,10"}},
+	}, load)
+	assert.Equal(t, imagor.ErrNotFound, err)
+}
+
+func TestSharpenMask(t *testing.T) {
+	ctx := context.Background()
+	// vertical stripes of mid tones for sharpening to take effect
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{R: 0x50, G: 0x50, B: 0x50, A: 0xff}
+			if x/4%2 == 0 {
+				c = color.NRGBA{R: 0xb0, G: 0xb0, B: 0xb0, A: 0xff}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	// mask of different size, left half sharpened and right half untouched
+	mask := image.NewGray(image.Rect(0, 0, 16, 16))
+	draw.Draw(mask, image.Rect(0, 0, 8, 16), image.White, image.Point{}, draw.Src)
+	var srcBuf, maskBuf bytes.Buffer
+	require.NoError(t, png.Encode(&srcBuf, src))
+	require.NoError(t, png.Encode(&maskBuf, mask))
+	load := func(image string) (*imagor.Blob, error) {
+		if image == "mask.png" {
+			return imagor.NewBlobBytes(maskBuf.Bytes()), nil
+		}
+		return nil, imagor.ErrNotFound
+	}
+	process := func(args string) image.Image {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(srcBuf.Bytes()), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "sharpen_mask", Args: args}},
+		}, load)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		return img
+	}
+	lum := func(img image.Image, x, y int) uint8 {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return uint8(r >> 8)
+	}
+	img := process("mask.png,2")
+	assert.Equal(t, src.Bounds(), img.Bounds())
+	var sharpened int
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 28; x++ {
+			if lum(img, x, y) != src.NRGBAAt(x, y).R {
+				sharpened++
+			}
+		}
+		for x := 36; x < 64; x++ {
+			// unmasked region untouched
+			require.Equal(t, src.NRGBAAt(x, y).R, lum(img, x, y))
+		}
+	}
+	assert.Greater(t, sharpened, 0, "masked region sharpened")
+	// edges overshoot beyond the original tones
+	assert.Greater(t, lum(img, 3, 16), uint8(0xb0))
+	assert.Less(t, lum(img, 4, 16), uint8(0x50))
+
+	_, err := New().Process(ctx, imagor.NewBlobBytes(srcBuf.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "sharpen_mask", Args: "missing.png,2"}},
+	}, load)
+	assert.Equal(t, imagor.ErrNotFound, err)
+
+	out, err := New(WithDisableBlur(true)).Process(ctx, imagor.NewBlobBytes(srcBuf.Bytes()), imagorpath.Params{
+		Filters: imagorpath.Filters{{Name: "sharpen_mask", Args: "mask.png,2"}},
+	}, load)
+	require.NoError(t, err)
+	buf, err := out.ReadAll()
+	require.NoError(t, err)
+	img, err = png.Decode(bytes.NewReader(buf))
+	require.NoError(t, err)
+	assert.Equal(t, src.NRGBAAt(3, 16).R, lum(img, 3, 16), "disabled with blur")
+}
+
+func TestAutoFormat(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		file   string
+		path   string
+		format string
+	}{
+		{"photo", "demo1.jpg", "fit-in/300x300/filters:format(auto)/demo1.jpg", "jpeg"},
+		{"photo with alpha", "demo1.jpg", "fit-in/300x300/filters:round_corner(40):format(auto)/demo1.jpg", "webp"},
+		{"flat graphic with alpha", "gopher.png", "fit-in/300x300/filters:format(auto)/gopher.png", "png"},
+		{"flat graphic", "gopher.png", "fit-in/300x300/filters:fill(white):format(auto)/gopher.png", "png"},
+		{"animated", "dancing-banana.gif", "filters:format(auto)/dancing-banana.gif", "webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, tt.file))
+			out, err := New(WithDefaultFormat("avif")).Process(ctx, blob, imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, out.Meta.Format)
+			assert.Equal(t, "image/"+tt.format, out.Meta.ContentType)
+		})
+	}
+	t.Run("classify", func(t *testing.T) {
+		flat := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		draw.Draw(flat, image.Rect(16, 16, 48, 48), image.NewUniform(color.NRGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+		assert.True(t, isFlatGraphic(flat))
+		assert.True(t, hasTransparency(flat))
+		photo := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				photo.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8(x * y), A: 0xff})
+			}
+		}
+		assert.False(t, isFlatGraphic(photo))
+		assert.False(t, hasTransparency(photo))
+	})
+}
+
+func TestWithMaxResultDimensions(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		options []Option
+		path    string
+		width   int
+		height  int
+	}{
+		{"within", []Option{WithMaxResultWidth(300), WithMaxResultHeight(300)}, "200x100/gopher.png", 200, 100},
+		{"clamped", []Option{WithMaxResultWidth(300), WithMaxResultHeight(300)}, "1000x500/gopher.png", 300, 150},
+		{"clamped height", []Option{WithMaxResultHeight(200)}, "600x800/gopher.png", 150, 200},
+		{"clamped width", []Option{WithMaxResultWidth(100)}, "1000x1000/gopher.png", 100, 100},
+		{"animated", []Option{WithMaxResultHeight(64)}, "120x128/dancing-banana.gif", 60, 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, filepath.Base(tt.path)))
+			out, err := New(tt.options...).Process(ctx, blob, imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.width, out.Meta.Width)
+			assert.Equal(t, tt.height, out.Meta.Height)
+		})
+	}
+}
+
+func TestKenBurns(t *testing.T) {
+	ctx := context.Background()
+	// flat background with detailed region at bottom right
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{R: 0x80, G: 0xa0, B: 0xc0, A: 0xff}), image.Point{}, draw.Src)
+	for y := 200; y < 280; y++ {
+		for x := 280; x < 380; x++ {
+			if (x/4+y/4)%2 == 0 {
+				src.SetNRGBA(x, y, color.NRGBA{A: 0xff})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(path string) kenBurnsPath {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", out.Meta.ContentType)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		var k kenBurnsPath
+		require.NoError(t, json.Unmarshal(res, &k))
+		return k
+	}
+	within := func(t *testing.T, k kenBurnsPath, r kenBurnsRect) {
+		assert.GreaterOrEqual(t, r.Left, 0)
+		assert.GreaterOrEqual(t, r.Top, 0)
+		assert.Greater(t, r.Width, 0)
+		assert.Greater(t, r.Height, 0)
+		assert.LessOrEqual(t, r.Left+r.Width, k.Width)
+		assert.LessOrEqual(t, r.Top+r.Height, k.Height)
+	}
+	for _, tt := range []struct {
+		path          string
+		width, height int
+		zoom          float64
+	}{
+		{"filters:ken_burns()/image.png", 400, 300, 1.5},
+		{"filters:ken_burns(2)/image.png", 400, 300, 2},
+		{"filters:ken_burns(10)/image.png", 400, 300, 4},
+		{"200x150/filters:ken_burns(2)/image.png", 200, 150, 2},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			k := process(tt.path)
+			assert.Equal(t, tt.width, k.Width)
+			assert.Equal(t, tt.height, k.Height)
+			assert.Equal(t, kenBurnsRect{Width: tt.width, Height: tt.height}, k.Start)
+			within(t, k, k.Start)
+			within(t, k, k.End)
+			assert.NotEqual(t, k.Start, k.End)
+			assert.InDelta(t, float64(tt.width)/tt.zoom, float64(k.End.Width), 1)
+			assert.InDelta(t, float64(tt.height)/tt.zoom, float64(k.End.Height), 1)
+			// zoomed toward the detailed region
+			assert.Greater(t, k.End.Left+k.End.Width/2, tt.width/2)
+			assert.Greater(t, k.End.Top+k.End.Height/2, tt.height/2)
+		})
+	}
+}
+
+func TestPadReflect(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 6), G: uint8(y * 8), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(args string) *image.NRGBA {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "pad_reflect", Args: args}},
+		}, nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		m := image.NewNRGBA(img.Bounds())
+		draw.Draw(m, m.Bounds(), img, image.Point{}, draw.Src)
+		return m
+	}
+	t.Run("all sides", func(t *testing.T) {
+		m := process("10")
+		require.Equal(t, image.Rect(0, 0, 60, 50), m.Bounds())
+		for y := 0; y < 30; y++ {
+			for x := 0; x < 40; x++ {
+				// original image at the center
+				require.Equal(t, src.NRGBAAt(x, y), m.NRGBAAt(x+10, y+10))
+			}
+		}
+		for i := 0; i < 10; i++ {
+			for y := 0; y < 30; y++ {
+				// left and right mirrors the edge columns
+				require.Equal(t, src.NRGBAAt(i, y), m.NRGBAAt(9-i, y+10))
+				require.Equal(t, src.NRGBAAt(39-i, y), m.NRGBAAt(50+i, y+10))
+			}
+			for x := 0; x < 40; x++ {
+				// top and bottom mirrors the edge rows
+				require.Equal(t, src.NRGBAAt(x, i), m.NRGBAAt(x+10, 9-i))
+				require.Equal(t, src.NRGBAAt(x, 29-i), m.NRGBAAt(x+10, 40+i))
+			}
+		}
+	})
+	t.Run("each side", func(t *testing.T) {
+		m := process("5,0,0,3")
+		require.Equal(t, image.Rect(0, 0, 45, 33), m.Bounds())
+		assert.Equal(t, src.NRGBAAt(0, 0), m.NRGBAAt(5, 0))
+		assert.Equal(t, src.NRGBAAt(4, 7), m.NRGBAAt(0, 7))
+		assert.Equal(t, src.NRGBAAt(10, 27), m.NRGBAAt(15, 32))
+	})
+	t.Run("capped by image dimensions", func(t *testing.T) {
+		m := process("100")
+		assert.Equal(t, image.Rect(0, 0, 120, 90), m.Bounds())
+	})
+	t.Run("no padding", func(t *testing.T) {
+		m := process("0")
+		assert.Equal(t, src.Bounds(), m.Bounds())
+	})
+}
+
+func TestAspectRatio(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		file   string
+		result aspectRatio
+	}{
+		{"gopher.png", aspectRatio{1634, 2224, 0.7347, "portrait"}},
+		{"find_trim.png", aspectRatio{512, 320, 1.6, "landscape"}},
+		{"nyan-cat.gif", aspectRatio{500, 198, 2.5253, "landscape"}},
+		{"demo1.jpg", aspectRatio{200, 200, 1, "square"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, tt.file))
+			// source dimensions regardless of transformations
+			out, err := New().Process(ctx, blob, imagorpath.Parse("fit-in/100x100/filters:aspect_ratio()/"+tt.file), nil)
+			require.NoError(t, err)
+			assert.Equal(t, "application/json", out.Meta.ContentType)
+			assert.Equal(t, tt.result.Width, out.Meta.Width)
+			assert.Equal(t, tt.result.Height, out.Meta.Height)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			var res aspectRatio
+			require.NoError(t, json.Unmarshal(buf, &res))
+			assert.Equal(t, tt.result, res)
+		})
+	}
+	assert.Equal(t, &aspectRatio{Orientation: "square"}, newAspectRatio(0, 0))
+}
+
+func TestSepia(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 0x40, A: uint8(255 - x*4)})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(blob *imagor.Blob, args string) (*image.NRGBA, error) {
+		out, err := New().Process(ctx, blob, imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "sepia", Args: args}},
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		m := image.NewNRGBA(img.Bounds())
+		draw.Draw(m, m.Bounds(), img, image.Point{}, draw.Src)
+		return m, nil
+	}
+	full, err := process(imagor.NewBlobBytes(buf.Bytes()), "")
+	require.NoError(t, err)
+	half, err := process(imagor.NewBlobBytes(buf.Bytes()), "50")
+	require.NoError(t, err)
+	none, err := process(imagor.NewBlobBytes(buf.Bytes()), "0")
+	require.NoError(t, err)
+	sep := func(c color.NRGBA) [3]float64 {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+		return [3]float64{
+			math.Min(0.393*r+0.769*g+0.189*b, 255),
+			math.Min(0.349*r+0.686*g+0.168*b, 255),
+			math.Min(0.272*r+0.534*g+0.131*b, 255),
+		}
+	}
+	for y := 0; y < 32; y += 3 {
+		for x := 0; x < 32; x += 3 {
+			c := src.NRGBAAt(x, y)
+			s := sep(c)
+			f, h := full.NRGBAAt(x, y), half.NRGBAAt(x, y)
+			assert.InDelta(t, s[0], float64(f.R), 1)
+			assert.InDelta(t, s[1], float64(f.G), 1)
+			assert.InDelta(t, s[2], float64(f.B), 1)
+			// linear interpolation with the original
+			assert.InDelta(t, (float64(c.R)+s[0])/2, float64(h.R), 1)
+			assert.InDelta(t, (float64(c.G)+s[1])/2, float64(h.G), 1)
+			assert.InDelta(t, (float64(c.B)+s[2])/2, float64(h.B), 1)
+			// alpha untouched
+			assert.Equal(t, c.A, f.A)
+			assert.Equal(t, c.A, h.A)
+			assert.Equal(t, c, none.NRGBAAt(x, y))
+		}
+	}
+	t.Run("grayscale image", func(t *testing.T) {
+		gray := image.NewGray(image.Rect(0, 0, 8, 8))
+		for i := range gray.Pix {
+			gray.Pix[i] = 0x80
+		}
+		var grayBuf bytes.Buffer
+		require.NoError(t, png.Encode(&grayBuf, gray))
+		m, err := process(imagor.NewBlobBytes(grayBuf.Bytes()), "")
+		require.NoError(t, err)
+		s := sep(color.NRGBA{R: 0x80, G: 0x80, B: 0x80})
+		c := m.NRGBAAt(4, 4)
+		assert.InDelta(t, s[0], float64(c.R), 2)
+		assert.InDelta(t, s[1], float64(c.G), 2)
+		assert.InDelta(t, s[2], float64(c.B), 2)
+		assert.Equal(t, uint8(0xff), c.A)
+	})
+	t.Run("grayscale alpha image", func(t *testing.T) {
+		gray := image.NewGray(image.Rect(0, 0, 8, 8))
+		for i := range gray.Pix {
+			gray.Pix[i] = 0x80
+		}
+		var grayBuf bytes.Buffer
+		require.NoError(t, png.Encode(&grayBuf, gray))
+		// two bands gray and alpha png
+		ga, err := vips.NewImageFromBuffer(grayBuf.Bytes())
+		require.NoError(t, err)
+		defer ga.Close()
+		require.NoError(t, ga.AddAlpha())
+		require.Equal(t, 2, ga.Bands())
+		gaBuf, _, err := ga.ExportPng(vips.NewPngExportParams())
+		require.NoError(t, err)
+		m, err := process(imagor.NewBlobBytes(gaBuf), "")
+		require.NoError(t, err)
+		c := m.NRGBAAt(4, 4)
+		assert.Greater(t, c.R, c.B, "warm tone")
+		assert.Equal(t, uint8(0xff), c.A)
+	})
+}
+
+func TestWithTwoStepDownscale(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher.png"))
+	t.Run("shrink factor", func(t *testing.T) {
+		v := New(WithTwoStepDownscale(4))
+		// 1634x2224 source
+		assert.Equal(t, 11, v.twoStepShrink(blob, 100, 100, vips.InterestingNone, vips.SizeDown))
+		assert.Equal(t, 8, v.twoStepShrink(blob, 100, 100, vips.InterestingCentre, vips.SizeBoth))
+		assert.Equal(t, 8, v.twoStepShrink(blob, 100, 100, vips.InterestingNone, vips.SizeForce))
+		assert.Equal(t, 0, v.twoStepShrink(blob, 800, 800, vips.InterestingNone, vips.SizeDown))
+		assert.Equal(t, 0, New().twoStepShrink(blob, 100, 100, vips.InterestingNone, vips.SizeDown))
+	})
+	for _, path := range []string{
+		"fit-in/100x100/filters:format(png)/gopher.png",
+		"100x100/filters:format(png)/gopher.png",
+		"smart/100x100/filters:format(png)/gopher.png",
+		"stretch/100x50/filters:format(png)/gopher.png",
+		"120x0/filters:format(png)/gopher.png",
+	} {
+		t.Run(path, func(t *testing.T) {
+			process := func(v *VipsProcessor) image.Image {
+				out, err := v.Process(ctx, blob, imagorpath.Parse(path), nil)
+				require.NoError(t, err)
+				buf, err := out.ReadAll()
+				require.NoError(t, err)
+				img, err := png.Decode(bytes.NewReader(buf))
+				require.NoError(t, err)
+				return img
+			}
+			direct := process(New())
+			twoStep := process(New(WithTwoStepDownscale(4)))
+			require.Equal(t, direct.Bounds(), twoStep.Bounds())
+			// same image of comparable quality
+			b := direct.Bounds()
+			var diff float64
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r1, g1, b1, a1 := direct.At(x, y).RGBA()
+					r2, g2, b2, a2 := twoStep.At(x, y).RGBA()
+					diff += math.Abs(float64(r1)-float64(r2)) + math.Abs(float64(g1)-float64(g2)) +
+						math.Abs(float64(b1)-float64(b2)) + math.Abs(float64(a1)-float64(a2))
+				}
+			}
+			assert.Less(t, diff/float64(b.Dx()*b.Dy()*4)/257, float64(8))
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	process := func(path string) []byte {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		return buf
+	}
+	none := process("filters:compression(0):format(png)/gopher-front.png")
+	best := process("filters:compression(9):format(png)/gopher-front.png")
+	assert.Greater(t, len(none), len(best))
+	assert.Equal(t, best, process("filters:compression(20):format(png)/gopher-front.png"))
+	assert.Equal(t, none, process("filters:compression(-5):format(png)/gopher-front.png"))
+	// lossless regardless of level
+	img1, err := png.Decode(bytes.NewReader(none))
+	require.NoError(t, err)
+	img2, err := png.Decode(bytes.NewReader(best))
+	require.NoError(t, err)
+	assert.Equal(t, img1.Bounds(), img2.Bounds())
+	assert.Equal(t, img1.At(100, 100), img2.At(100, 100))
+	// ignored for other formats
+	assert.Equal(t,
+		process("filters:format(jpeg)/gopher-front.png"),
+		process("filters:compression(0):format(jpeg)/gopher-front.png"))
+}
+
+func TestZoomBlur(t *testing.T) {
+	ctx := context.Background()
+	// white square on black off the top left center
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(src, src.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(48, 48, 56, 56), image.White, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(args string) *image.NRGBA {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{
+			Filters: imagorpath.Filters{{Name: "zoom_blur", Args: args}},
+		}, nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		m := image.NewNRGBA(img.Bounds())
+		draw.Draw(m, m.Bounds(), img, image.Point{}, draw.Src)
+		return m
+	}
+	m := process("50,0.25,0.25")
+	require.Equal(t, src.Bounds(), m.Bounds())
+	lum := func(x, y int) uint8 {
+		return m.NRGBAAt(x, y).R
+	}
+	// smeared radially outward from center
+	assert.Greater(t, lum(60, 60), uint8(0))
+	assert.Greater(t, lum(62, 62), uint8(0))
+	assert.Less(t, lum(52, 52), uint8(0xff))
+	// not toward center nor sideways
+	assert.Equal(t, uint8(0), lum(40, 40))
+	assert.Equal(t, uint8(0), lum(60, 30))
+	assert.Equal(t, uint8(0), lum(30, 60))
+	// center untouched
+	assert.Equal(t, uint8(0), lum(16, 16))
+
+	// opposite center smears the other way
+	m = process("50,1,1")
+	assert.Greater(t, lum(40, 40), uint8(0))
+
+	assert.Equal(t, src, process("0,0.25,0.25"))
+}
+
+func TestFlipFlop(t *testing.T) {
+	ctx := context.Background()
+	// 4x3 black image of single white pixel at (1, 0)
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	draw.Draw(src, src.Bounds(), image.Black, image.Point{}, draw.Src)
+	src.SetNRGBA(1, 0, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	tests := []struct {
+		name    string
+		filters imagorpath.Filters
+		bounds  image.Rectangle
+		white   image.Point
+	}{
+		{"flip", imagorpath.Filters{{Name: "flip"}}, image.Rect(0, 0, 4, 3), image.Pt(1, 2)},
+		{"flop", imagorpath.Filters{{Name: "flop"}}, image.Rect(0, 0, 4, 3), image.Pt(2, 0)},
+		{"flip flop", imagorpath.Filters{{Name: "flip"}, {Name: "flop"}}, image.Rect(0, 0, 4, 3), image.Pt(2, 2)},
+		{"flip flip", imagorpath.Filters{{Name: "flip"}, {Name: "flip"}}, image.Rect(0, 0, 4, 3), image.Pt(1, 0)},
+		// counter clockwise rotation maps (x, y) to (y, w-1-x)
+		{"rotate flop", imagorpath.Filters{{Name: "rotate", Args: "90"}, {Name: "flop"}}, image.Rect(0, 0, 3, 4), image.Pt(2, 2)},
+		{"flop rotate", imagorpath.Filters{{Name: "flop"}, {Name: "rotate", Args: "90"}}, image.Rect(0, 0, 3, 4), image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Params{Filters: tt.filters}, nil)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, out.Meta.Orientation, 1)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(res))
+			require.NoError(t, err)
+			require.Equal(t, tt.bounds, img.Bounds())
+			for y := 0; y < tt.bounds.Dy(); y++ {
+				for x := 0; x < tt.bounds.Dx(); x++ {
+					r, _, _, _ := img.At(x, y).RGBA()
+					if image.Pt(x, y) == tt.white {
+						assert.Equal(t, uint32(0xffff), r)
+					} else {
+						assert.Equal(t, uint32(0), r)
+					}
+				}
+			}
+		})
+	}
+	t.Run("animated", func(t *testing.T) {
+		// frames of white top row then white left column
+		palette := color.Palette{color.Black, color.White}
+		anim := &gif.GIF{}
+		for i := 0; i < 2; i++ {
+			frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+			for j := 0; j < 4; j++ {
+				if i == 0 {
+					frame.SetColorIndex(j, 0, 1)
+				} else {
+					frame.SetColorIndex(0, j, 1)
+				}
+			}
+			anim.Image = append(anim.Image, frame)
+			anim.Delay = append(anim.Delay, 10)
+		}
+		var gifBuf bytes.Buffer
+		require.NoError(t, gif.EncodeAll(&gifBuf, anim))
+		for _, flt := range []string{"flip", "flop"} {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(gifBuf.Bytes()), imagorpath.Params{
+				Filters: imagorpath.Filters{{Name: flt}},
+			}, nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			g, err := gif.DecodeAll(bytes.NewReader(res))
+			require.NoError(t, err)
+			require.Len(t, g.Image, 2)
+			// frames composited as displayed, in case of optimized sub-frames
+			var frames []*image.RGBA
+			canvas := image.NewRGBA(image.Rect(0, 0, 4, 4))
+			for _, frame := range g.Image {
+				draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+				frames = append(frames, image.NewRGBA(canvas.Bounds()))
+				draw.Draw(frames[len(frames)-1], canvas.Bounds(), canvas, image.Point{}, draw.Src)
+			}
+			white := func(frame int, x, y int) bool {
+				r, _, _, _ := frames[frame].At(x, y).RGBA()
+				return r > 0x8000
+			}
+			for j := 0; j < 4; j++ {
+				if flt == "flip" {
+					// each frame flipped in place, order kept
+					assert.True(t, white(0, j, 3))
+					assert.False(t, white(0, j, 0))
+					assert.True(t, white(1, 0, j))
+				} else {
+					assert.True(t, white(0, j, 0))
+					assert.True(t, white(1, 3, j))
+					assert.False(t, white(1, 0, j))
+				}
+			}
+		}
+	})
+}
+
+func TestSpeed(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	var speeds []int
+	v := New()
+	v.exportImage = func(
+		image *vips.ImageRef, format vips.ImageType, opts exportOptions,
+	) ([]byte, *vips.ImageMetadata, error) {
+		if format == vips.ImageTypeAVIF {
+			speeds = append(speeds, opts.speed)
+			// encoder availability independent
+			format = vips.ImageTypePNG
+		}
+		return export(image, format, opts)
+	}
+	for _, path := range []string{
+		"filters:format(avif)/gopher-front.png",
+		"filters:speed(8):format(avif)/gopher-front.png",
+		"filters:speed(20):format(avif)/gopher-front.png",
+		"filters:speed(-3):format(avif)/gopher-front.png",
+		"filters:speed(foo):format(avif)/gopher-front.png",
+	} {
+		_, err := v.Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int{-1, 8, 9, 0, -1}, speeds)
+
+	process := func(path string) []byte {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		return buf
+	}
+	// ignored for other formats
+	assert.Equal(t,
+		process("filters:format(webp)/gopher-front.png"),
+		process("filters:speed(0):format(webp)/gopher-front.png"))
+}
+
+func TestInsetBorder(t *testing.T) {
+	ctx := context.Background()
+	green := color.NRGBA{G: 0xff, A: 0xff}
+	src := image.NewNRGBA(image.Rect(0, 0, 80, 60))
+	draw.Draw(src, src.Bounds(), image.NewUniform(green), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	tests := []struct {
+		path   string
+		width  int
+		height int
+		border int
+		color  color.NRGBA
+	}{
+		{"filters:inset_border(3,ff0000)/image.png", 80, 60, 3, color.NRGBA{R: 0xff, A: 0xff}},
+		{"40x30/filters:inset_border(2,blue)/image.png", 40, 30, 2, color.NRGBA{B: 0xff, A: 0xff}},
+		{"fit-in/40x40/filters:inset_border(1)/image.png", 40, 30, 1, color.NRGBA{A: 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(res))
+			require.NoError(t, err)
+			require.Equal(t, image.Rect(0, 0, tt.width, tt.height), img.Bounds())
+			at := func(x, y int) color.NRGBA {
+				return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			}
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					edge := x < tt.border || y < tt.border ||
+						x >= tt.width-tt.border || y >= tt.height-tt.border
+					if edge {
+						require.Equal(t, tt.color, at(x, y), "edge pixel")
+					} else {
+						require.Equal(t, green, at(x, y), "inner pixel")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAnimate(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+	process := func(t *testing.T, path string) *imagor.Blob {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		return out
+	}
+	// mean absolute difference of adjacent pixels, less for more blurred
+	sharpness := func(m image.Image) (sum float64) {
+		b := m.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X + 1; x < b.Max.X; x++ {
+				r1, _, _, _ := m.At(x-1, y).RGBA()
+				r2, _, _, _ := m.At(x, y).RGBA()
+				sum += math.Abs(float64(r1) - float64(r2))
+			}
+		}
+		return sum / float64(b.Dx()*b.Dy())
+	}
+
+	t.Run("gif", func(t *testing.T) {
+		out := process(t, "100x100/filters:animate(blur,0,16,5)/demo1.jpg")
+		assert.Equal(t, "gif", out.Meta.Format)
+		assert.Equal(t, 100, out.Meta.Height, "page height")
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		g, err := gif.DecodeAll(bytes.NewReader(buf))
+		require.NoError(t, err)
+		require.Len(t, g.Image, 5)
+		for i := 1; i < len(g.Image); i++ {
+			assert.Less(t, sharpness(g.Image[i]), sharpness(g.Image[i-1]), fmt.Sprintf("frame %d blurred more", i))
+		}
+	})
+	t.Run("webp", func(t *testing.T) {
+		out := process(t, "100x100/filters:animate(brightness,-50,50,3):format(webp)/demo1.jpg")
+		assert.Equal(t, "webp", out.Meta.Format)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		params := vips.NewImportParams()
+		params.NumPages.Set(-1)
+		img, err := vips.LoadImageFromBuffer(buf, params)
+		require.NoError(t, err)
+		defer img.Close()
+		assert.Equal(t, 3, img.Height()/img.PageHeight())
+	})
+	t.Run("still format", func(t *testing.T) {
+		out := process(t, "100x100/filters:animate(blur,0,16,5):format(jpeg)/demo1.jpg")
+		assert.Equal(t, "jpeg", out.Meta.Format)
+		assert.Equal(t, 100, out.Meta.Height)
+	})
+	t.Run("max frames", func(t *testing.T) {
+		out, err := New(WithMaxAnimationFrames(3)).Process(ctx, blob,
+			imagorpath.Parse("100x100/filters:animate(blur,0,16,10)/demo1.jpg"), nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		g, err := gif.DecodeAll(bytes.NewReader(buf))
+		require.NoError(t, err)
+		assert.Len(t, g.Image, 3)
+	})
+	t.Run("max size exceeded", func(t *testing.T) {
+		_, err := New(WithMaxWidth(300), WithMaxHeight(300)).Process(ctx, blob,
+			imagorpath.Parse("100x100/filters:animate(blur,0,16,10)/demo1.jpg"), nil)
+		assert.Equal(t, imagor.ErrMaxSizeExceeded, err)
+	})
+	for _, path := range []string{
+		"100x100/filters:animate(blur,0,16)/demo1.jpg",
+		"100x100/filters:animate(unknown,0,16,5)/demo1.jpg",
+		"100x100/filters:animate(blur,a,b,5)/demo1.jpg",
+	} {
+		out := process(t, path)
+		assert.Equal(t, "jpeg", out.Meta.Format, path)
+	}
+}
+
+func TestPixelate(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 4), A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(t *testing.T, path string) image.Image {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		require.Equal(t, src.Bounds(), img.Bounds())
+		return img
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	// pixels of each block are uniform across the region
+	assertBlocks := func(t *testing.T, img image.Image, x0, y0, x1, y1, size int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				bx, by := x0+(x-x0)/size*size, y0+(y-y0)/size*size
+				require.Equal(t, at(img, bx, by), at(img, x, y), fmt.Sprintf("pixel %d,%d", x, y))
+			}
+		}
+	}
+	t.Run("whole image", func(t *testing.T) {
+		img := process(t, "filters:pixelate(8)/image.png")
+		assertBlocks(t, img, 0, 0, 64, 64, 8)
+		assert.NotEqual(t, at(img, 0, 0), at(img, 8, 0))
+		assert.NotEqual(t, at(img, 0, 0), at(img, 0, 8))
+	})
+	t.Run("region", func(t *testing.T) {
+		img := process(t, "filters:pixelate(8,16,16,32,32)/image.png")
+		assertBlocks(t, img, 16, 16, 48, 48, 8)
+		for _, p := range []image.Point{{0, 0}, {15, 20}, {20, 15}, {48, 48}, {63, 30}} {
+			assert.Equal(t, src.NRGBAAt(p.X, p.Y), at(img, p.X, p.Y), "outside region untouched")
+		}
+	})
+	t.Run("region clamped", func(t *testing.T) {
+		img := process(t, "filters:pixelate(8,48,48,100,100)/image.png")
+		assertBlocks(t, img, 48, 48, 64, 64, 8)
+		assert.Equal(t, src.NRGBAAt(47, 47), at(img, 47, 47))
+	})
+	t.Run("block size at least 1", func(t *testing.T) {
+		for _, path := range []string{"filters:pixelate(0)/image.png", "filters:pixelate(-5)/image.png"} {
+			img := process(t, path)
+			assert.Equal(t, src.NRGBAAt(5, 9), at(img, 5, 9))
+		}
+	})
+	t.Run("animated", func(t *testing.T) {
+		// frames of black left and white right, second frame inverted
+		palette := color.Palette{color.Black, color.White}
+		anim := &gif.GIF{}
+		for i := 0; i < 2; i++ {
+			frame := image.NewPaletted(image.Rect(0, 0, 16, 12), palette)
+			for y := 0; y < 12; y++ {
+				for x := 0; x < 16; x++ {
+					frame.SetColorIndex(x, y, uint8((x/8+i)%2))
+				}
+			}
+			anim.Image = append(anim.Image, frame)
+			anim.Delay = append(anim.Delay, 10)
+		}
+		var gifBuf bytes.Buffer
+		require.NoError(t, gif.EncodeAll(&gifBuf, anim))
+		frames := func(t *testing.T, path string) (frames []*image.RGBA) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(gifBuf.Bytes()), imagorpath.Parse(path), nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			g, err := gif.DecodeAll(bytes.NewReader(res))
+			require.NoError(t, err)
+			require.Len(t, g.Image, 2)
+			// frames composited as displayed, in case of optimized sub-frames
+			canvas := image.NewRGBA(image.Rect(0, 0, 16, 12))
+			for _, frame := range g.Image {
+				draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+				frames = append(frames, image.NewRGBA(canvas.Bounds()))
+				draw.Draw(frames[len(frames)-1], canvas.Bounds(), canvas, image.Point{}, draw.Src)
+			}
+			return
+		}
+		white := func(frame *image.RGBA, x, y int) bool {
+			r, _, _, _ := frame.At(x, y).RGBA()
+			return r > 0x8000
+		}
+		fs := frames(t, "filters:pixelate(8)/image.gif")
+		for y := 0; y < 12; y++ {
+			// blocks not bleeding across frames
+			assert.False(t, white(fs[0], 2, y), fmt.Sprintf("frame 0 row %d", y))
+			assert.True(t, white(fs[0], 12, y), fmt.Sprintf("frame 0 row %d", y))
+			assert.True(t, white(fs[1], 2, y), fmt.Sprintf("frame 1 row %d", y))
+			assert.False(t, white(fs[1], 12, y), fmt.Sprintf("frame 1 row %d", y))
+		}
+		fs = frames(t, "filters:pixelate(16,0,0,16,12)/image.gif")
+		for i, frame := range fs {
+			// single block region of every frame
+			for y := 0; y < 12; y++ {
+				assert.Equal(t, white(frame, 0, 0), white(frame, 15, y), fmt.Sprintf("frame %d row %d", i, y))
+			}
+		}
+	})
+}
+
+func TestOpacity(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		path   string
+		format string
+		alpha  bool
+	}{
+		{"jpeg source keeps alpha by png", "50x50/filters:opacity(40)/demo1.jpg", "png", true},
+		{"png source", "50x50/filters:opacity(40)/gopher-front.png", "png", true},
+		{"explicit jpeg", "50x50/filters:opacity(40):format(jpeg)/demo1.jpg", "jpeg", false},
+		{"opaque", "50x50/filters:opacity(100)/demo1.jpg", "jpeg", false},
+		{"animated", "filters:opacity(40)/dancing-banana.gif", "gif", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, path.Base(tt.path)))
+			out, err := New().Process(ctx, blob, imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, out.Meta.Format)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := vips.NewImageFromBuffer(buf)
+			require.NoError(t, err)
+			defer img.Close()
+			assert.Equal(t, tt.alpha, img.HasAlpha())
+		})
+	}
+	t.Run("alpha scaled", func(t *testing.T) {
+		src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+		src.SetNRGBA(0, 0, color.NRGBA{G: 0xff, A: 100})
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, src))
+		for _, tt := range []struct {
+			amount       string
+			opaque, semi uint8
+		}{
+			{"50", 128, 50},
+			{"0", 0, 0},
+			{"-10", 0, 0},
+		} {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()),
+				imagorpath.Parse("filters:opacity("+tt.amount+")/image.png"), nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(res))
+			require.NoError(t, err)
+			c := color.NRGBAModel.Convert(img.At(1, 1)).(color.NRGBA)
+			assert.InDelta(t, tt.opaque, c.A, 1, tt.amount)
+			if c.A > 0 {
+				assert.Equal(t, uint8(0xff), c.R, "color untouched")
+			}
+			c = color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+			assert.InDelta(t, tt.semi, c.A, 1, tt.amount)
+		}
+	})
+}
+
+func TestSharpness(t *testing.T) {
+	ctx := context.Background()
+	score := func(t *testing.T, file, path string) sharpnessScore {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, file))
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", out.Meta.ContentType)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		var s sharpnessScore
+		require.NoError(t, json.Unmarshal(buf, &s))
+		return s
+	}
+	for _, file := range []string{"demo1.jpg", "gopher.png", "dancing-banana.gif"} {
+		t.Run(file, func(t *testing.T) {
+			sharp := score(t, file, "filters:sharpness()/"+file)
+			assert.Greater(t, sharp.Sharpness, 0.0)
+			blurred := score(t, file, "filters:blur(4):sharpness()/"+file)
+			assert.Less(t, blurred.Sharpness, sharp.Sharpness, "blurred scores lower")
+			more := score(t, file, "filters:blur(10):sharpness()/"+file)
+			assert.Less(t, more.Sharpness, blurred.Sharpness, "more blurred scores even lower")
+		})
+	}
+	s := score(t, "demo1.jpg", "fit-in/100x100/filters:sharpness()/demo1.jpg")
+	assert.Equal(t, 100, s.Width)
+	assert.Equal(t, 100, s.Height)
+
+	flat := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.NRGBA{R: 80, G: 80, B: 80, A: 0xff}), image.Point{}, draw.Src)
+	assert.Equal(t, 0.0, laplacianVariance(flat))
+	checker := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			}
+		}
+	}
+	assert.Greater(t, laplacianVariance(checker), laplacianVariance(flat))
+	assert.Equal(t, 0.0, laplacianVariance(image.NewNRGBA(image.Rect(0, 0, 2, 2))))
+}
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 32, 32))))
+	black := color.NRGBA{A: 0xff}
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	gray := color.NRGBA{R: 0xcc, G: 0xcc, B: 0xcc, A: 0xff}
+	tests := []struct {
+		path string
+		size int
+		c1   color.NRGBA
+		c2   color.NRGBA
+	}{
+		{"filters:checker()/image.png", 8, white, gray},
+		{"filters:checker(4,black,white)/image.png", 4, black, white},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			res, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(res))
+			require.NoError(t, err)
+			require.Equal(t, image.Rect(0, 0, 32, 32), img.Bounds())
+			for y := tt.size / 2; y < 32; y += tt.size {
+				for x := tt.size / 2; x < 32; x += tt.size {
+					expected := tt.c1
+					if (x/tt.size+y/tt.size)%2 == 1 {
+						expected = tt.c2
+					}
+					require.Equal(t, expected, color.NRGBAModel.Convert(img.At(x, y)), "tile center")
+				}
+			}
+		})
+	}
+}
+
+func TestCircle(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		path    string
+		options []Option
+		format  string
+		alpha   bool
+	}{
+		{"jpeg source keeps alpha by png", "200x200/filters:circle()/demo1.jpg", nil, "png", true},
+		{"jpeg default format keeps alpha by png", "200x200/filters:circle()/gopher-front.png", []Option{WithDefaultFormat("jpeg")}, "png", true},
+		{"flatten background", "200x200/filters:circle(white)/demo1.jpg", nil, "jpeg", false},
+		{"explicit jpeg", "200x200/filters:circle():format(jpeg)/demo1.jpg", nil, "jpeg", false},
+		{"explicit webp", "200x200/filters:circle():format(webp)/demo1.jpg", nil, "webp", true},
+		{"png source", "200x200/filters:circle()/gopher-front.png", nil, "png", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, path.Base(tt.path)))
+			out, err := New(tt.options...).Process(ctx, blob, imagorpath.Parse(tt.path), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, out.Meta.Format)
+			assert.Equal(t, 200, out.Meta.Width)
+			assert.Equal(t, 200, out.Meta.Height)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			img, err := vips.NewImageFromBuffer(buf)
+			require.NoError(t, err)
+			defer img.Close()
+			assert.Equal(t, tt.alpha, img.HasAlpha())
+			if tt.format != "png" {
+				return
+			}
+			m, err := png.Decode(bytes.NewReader(buf))
+			require.NoError(t, err)
+			_, _, _, a := m.At(0, 0).RGBA()
+			assert.Equal(t, uint32(0), a, "transparent outside circle")
+			_, _, _, a = m.At(100, 100).RGBA()
+			assert.Equal(t, uint32(0xffff), a, "opaque inside circle")
+		})
+	}
+}
+
+// meanLuminance mean Rec. 601 luma of the opaque pixels, 0 to 255. Not ok if no opaque pixel
+func meanLuminance(m *image.NRGBA) (mean float64, ok bool) {
+	var sum, n int
+	for i, count := range luminanceHistogram(m, 256) {
+		sum += i * count
+		n += count
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return float64(sum) / float64(n), true
+}
+
+func TestAutoExposure(t *testing.T) {
+	ctx := context.Background()
+	// gray gradient within luminance range
+	fixture := func(from, to int) []byte {
+		src := image.NewNRGBA(image.Rect(0, 0, 100, 50))
+		for y := 0; y < 50; y++ {
+			for x := 0; x < 100; x++ {
+				v := uint8(from + x*(to-from)/99)
+				src.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 0xff})
+			}
+		}
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, src))
+		return buf.Bytes()
+	}
+	mean := func(t *testing.T, buf []byte, path string) float64 {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		m := image.NewNRGBA(img.Bounds())
+		draw.Draw(m, m.Bounds(), img, image.Point{}, draw.Src)
+		l, ok := meanLuminance(m)
+		require.True(t, ok)
+		return l
+	}
+	tests := []struct {
+		name     string
+		from, to int
+		path     string
+		target   float64
+		delta    float64
+	}{
+		// adjustments capped before reaching target
+		{"dark", 0, 80, "filters:auto_exposure()/image.png", 127.5, 20},
+		{"bright", 175, 255, "filters:auto_exposure()/image.png", 127.5, 50},
+		{"dark to target", 0, 80, "filters:auto_exposure(30)/image.png", 76.5, 10},
+		{"bright to target", 175, 255, "filters:auto_exposure(70)/image.png", 178.5, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := fixture(tt.from, tt.to)
+			before := mean(t, buf, "image.png")
+			after := mean(t, buf, tt.path)
+			assert.Less(t, math.Abs(after-tt.target), math.Abs(before-tt.target), "toward target")
+			assert.InDelta(t, tt.target, after, tt.delta)
+		})
+	}
+	t.Run("capped", func(t *testing.T) {
+		buf := fixture(5, 5)
+		assert.InDelta(t, 255*math.Pow(5.0/255, 1/autoExposureMaxGamma), mean(t, buf, "filters:auto_exposure()/image.png"), 1)
+		assert.InDelta(t, 255*math.Pow(5.0/255, 1/1.5), mean(t, buf, "filters:auto_exposure(50,1.5)/image.png"), 1)
+	})
+	t.Run("well exposed untouched", func(t *testing.T) {
+		buf := fixture(0, 255)
+		assert.InDelta(t, mean(t, buf, "image.png"), mean(t, buf, "filters:auto_exposure()/image.png"), 1)
+	})
+
+	assert.Equal(t, 1.0, exposureGamma(0.5, 0.5, 2.5))
+	assert.Equal(t, 1/2.5, exposureGamma(0, 0.5, 2.5))
+	assert.Equal(t, 2.5, exposureGamma(1, 0.5, 2.5))
+	assert.Less(t, exposureGamma(0.3, 0.5, 2.5), 1.0)
+	assert.Greater(t, exposureGamma(0.7, 0.5, 2.5), 1.0)
+	_, ok := meanLuminance(image.NewNRGBA(image.Rect(0, 0, 2, 2)))
+	assert.False(t, ok, "no opaque pixel")
+}
+
+func TestHistOverlay(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			v := uint8(x * 255 / 199)
+			src.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(path string) *image.NRGBA {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		m := image.NewNRGBA(img.Bounds())
+		draw.Draw(m, m.Bounds(), img, image.Point{}, draw.Src)
+		return m
+	}
+	changed := func(a, b *image.NRGBA, y int) bool {
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			if a.NRGBAAt(x, y) != b.NRGBAAt(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+	t.Run("histogram", func(t *testing.T) {
+		m := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+		for x, v := range []uint8{0, 100, 150, 255} {
+			for y := 0; y < 3; y++ {
+				m.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 0xff})
+			}
+		}
+		m.SetNRGBA(0, 0, color.NRGBA{})
+		assert.Equal(t, []int{2, 3, 3, 3}, luminanceHistogram(m, 4))
+		assert.Equal(t, []int{0, 0}, luminanceHistogram(image.NewNRGBA(image.Rect(0, 0, 4, 4)), 2))
+	})
+	t.Run("height scales with width", func(t *testing.T) {
+		assert.Equal(t, 20, histOverlayHeight(100, 100))
+		assert.Equal(t, 40, histOverlayHeight(200, 100))
+		assert.Equal(t, 50, histOverlayHeight(400, 100))
+		assert.Equal(t, 1, histOverlayHeight(2, 1))
+	})
+	for _, tt := range []struct {
+		name  string
+		path  string
+		width int
+		top   bool
+	}{
+		{"bottom", "filters:hist_overlay()/image.png", 200, false},
+		{"top", "filters:hist_overlay(top)/image.png", 200, true},
+		{"scaled", "100x50/filters:hist_overlay()/image.png", 100, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := process(strings.Replace(tt.path, "hist_overlay", "strip_exif", 1))
+			m := process(tt.path)
+			require.Equal(t, plain.Bounds(), m.Bounds())
+			h := m.Bounds().Dy()
+			oh := histOverlayHeight(tt.width, h)
+			for y := 0; y < h; y++ {
+				inside := y >= h-oh
+				if tt.top {
+					inside = y < oh
+				}
+				assert.Equal(t, inside, changed(plain, m, y), fmt.Sprintf("row %d", y))
+			}
+		})
+	}
+}
+
+// readComment reads back comment of JPEG COM marker, PNG text chunk or WebP XMP description
+func readComment(t *testing.T, buf []byte) string {
+	switch {
+	case buf[0] == 0xFF && buf[1] == 0xD8:
+		for i := 2; i+4 <= len(buf) && buf[i] == 0xFF && buf[i+1] != 0xDA; {
+			end := i + 2 + int(binary.BigEndian.Uint16(buf[i+2:]))
+			if buf[i+1] == 0xFE {
+				return string(buf[i+4 : end])
+			}
+			i = end
+		}
+	case bytes.HasPrefix(buf, pngHeader):
+		for i := 8; i+8 <= len(buf); {
+			size := int(binary.BigEndian.Uint32(buf[i:]))
+			data := buf[i+8 : i+8+size]
+			require.Equal(t, crc32.ChecksumIEEE(buf[i+4:i+8+size]), binary.BigEndian.Uint32(buf[i+8+size:]))
+			switch string(buf[i+4 : i+8]) {
+			case "tEXt":
+				if k := "Comment\x00"; bytes.HasPrefix(data, []byte(k)) {
+					return string(data[len(k):])
+				}
+			case "iTXt":
+				if k := "Comment\x00\x00\x00\x00\x00"; bytes.HasPrefix(data, []byte(k)) {
+					return string(data[len(k):])
+				}
+			}
+			i += 12 + size
+		}
+	case string(buf[8:12]) == "WEBP":
+		require.Equal(t, len(buf)-8, int(binary.LittleEndian.Uint32(buf[4:])), "riff size")
+		require.Equal(t, "VP8X", string(buf[12:16]))
+		require.True(t, buf[20]&webpFlagXMP != 0)
+		for i := 12; i+8 <= len(buf); {
+			size := int(binary.LittleEndian.Uint32(buf[i+4:]))
+			if string(buf[i:i+4]) == "XMP " {
+				var x struct {
+					Description string `xml:"RDF>Description>description>Alt>li"`
+				}
+				require.NoError(t, xml.Unmarshal(buf[i+8:i+8+size], &x))
+				return x.Description
+			}
+			i += 8 + size + size%2
+		}
+	}
+	return ""
+}
+
+func TestComment(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		path    string
+		format  string
+		comment string
+	}{
+		{"jpeg", "fit-in/100x100/filters:comment(Photo%20by%20imagor):format(jpeg)/demo1.jpg", "jpeg", "Photo by imagor"},
+		{"jpeg exif", "fit-in/100x100/filters:comment(hello,world)/demo4.jpg", "jpeg", "hello,world"},
+		{"png", "fit-in/100x100/filters:comment(%C2%A9%20gopher%20%3C3)/gopher-front.png", "png", "© gopher <3"},
+		{"png ascii", "fit-in/100x100/filters:comment(abc)/gopher-front.png", "png", "abc"},
+		{"webp lossy", "fit-in/100x100/filters:comment(%C2%A9%20a%20%26%20b):format(webp)/demo1.jpg", "webp", "© a & b"},
+		{"webp alpha", "fit-in/100x100/filters:comment(alpha):format(webp)/gopher-front.png", "webp", "alpha"},
+		{"webp animated", "filters:comment(anim):format(webp)/dancing-banana.gif", "webp", "anim"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := imagorpath.Parse(tt.path)
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, p.Image))
+			out, err := New().Process(ctx, blob, p, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, out.Meta.Format)
+			buf, err := out.ReadAll()
+			require.NoError(t, err)
+			assert.Equal(t, tt.comment, readComment(t, buf))
+			// still a valid image
+			img, err := vips.NewImageFromBuffer(buf)
+			require.NoError(t, err)
+			defer img.Close()
+			assert.Equal(t, out.Meta.Width, img.Width())
+		})
+	}
+	t.Run("go image decode", func(t *testing.T) {
+		src := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+		var jpg, png8 bytes.Buffer
+		require.NoError(t, jpeg.Encode(&jpg, src, nil))
+		require.NoError(t, png.Encode(&png8, src))
+		for _, buf := range [][]byte{jpg.Bytes(), png8.Bytes()} {
+			buf = addComment(buf, "foo")
+			assert.Equal(t, "foo", readComment(t, buf))
+			_, _, err := image.Decode(bytes.NewReader(buf))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []byte("GIF89a"), addComment([]byte("GIF89a"), "foo"))
+	})
+}
+
+func TestDominantColor(t *testing.T) {
+	ctx := context.Background()
+	meta := func(t *testing.T, blob *imagor.Blob, path string) *imagor.Meta {
+		out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		return out.Meta
+	}
+	pngBlob := func(m image.Image) *imagor.Blob {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, m))
+		return imagor.NewBlobBytes(buf.Bytes())
+	}
+	t.Run("solid", func(t *testing.T) {
+		m := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+		draw.Draw(m, m.Bounds(), image.NewUniform(color.NRGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+		assert.Equal(t, "#ff0000", meta(t, pngBlob(m), "meta/image.png").DominantColor)
+	})
+	t.Run("transparent pixels not contributing", func(t *testing.T) {
+		m := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+		draw.Draw(m, image.Rect(0, 0, 10, 20), image.NewUniform(color.NRGBA{G: 0xff, A: 0xff}), image.Point{}, draw.Src)
+		draw.Draw(m, image.Rect(10, 0, 20, 20), image.NewUniform(color.NRGBA{B: 0xff}), image.Point{}, draw.Src)
+		assert.Equal(t, "#00ff00", meta(t, pngBlob(m), "meta/image.png").DominantColor)
+	})
+	t.Run("fully transparent", func(t *testing.T) {
+		m := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+		draw.Draw(m, m.Bounds(), image.NewUniform(color.NRGBA{R: 0xff}), image.Point{}, draw.Src)
+		assert.Equal(t, "#000000", meta(t, pngBlob(m), "meta/image.png").DominantColor)
+	})
+	for _, file := range []string{"demo1.jpg", "gopher.png", "dancing-banana.gif"} {
+		t.Run(file, func(t *testing.T) {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, file))
+			assert.Regexp(t, "^#[0-9a-f]{6}$", meta(t, blob, "meta/fit-in/100x100/"+file).DominantColor)
+		})
+	}
+	t.Run("not meta", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+		assert.Empty(t, meta(t, blob, "fit-in/100x100/demo1.jpg").DominantColor)
+	})
+}
+
+func TestLetterbox(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		path          string
+		width, height int
+	}{
+		{"landscape letterbox color", "filters:letterbox(1,1,ffffff)/find_trim.png", 512, 512},
+		{"landscape letterbox blur", "filters:letterbox(1,1,blur)/find_trim.png", 512, 512},
+		{"landscape pillarbox", "filters:letterbox(21,9,ffffff)/find_trim.png", 747, 320},
+		{"landscape same ratio", "filters:letterbox(16,10)/find_trim.png", 512, 320},
+		{"portrait pillarbox color", "fit-in/200x200/filters:letterbox(16,9,ffffff)/gopher.png", 0, 0},
+		{"portrait pillarbox blur", "fit-in/200x200/filters:letterbox(16,9,blur)/gopher.png", 0, 0},
+		{"portrait letterbox", "fit-in/200x200/filters:letterbox(1,4)/gopher.png", 0, 0},
+		{"fractional ratio", "fit-in/200x200/filters:letterbox(2.35,1)/gopher.png", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := imagorpath.Parse(tt.path)
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, p.Image))
+			// same path without letterbox
+			srcPath := strings.Replace(tt.path, "filters:letterbox("+p.Filters[0].Args+")/", "", 1)
+			src, err := New().Process(ctx, blob, imagorpath.Parse(srcPath), nil)
+			require.NoError(t, err)
+			out, err := New().Process(ctx, blob, p, nil)
+			require.NoError(t, err)
+			args := strings.Split(p.Filters[0].Args, ",")
+			rw, _ := strconv.ParseFloat(args[0], 64)
+			rh, _ := strconv.ParseFloat(args[1], 64)
+			assert.InDelta(t, rw/rh, float64(out.Meta.Width)/float64(out.Meta.Height), 0.01, "target aspect ratio")
+			assert.True(t, out.Meta.Width >= src.Meta.Width && out.Meta.Height >= src.Meta.Height, "image not cropped")
+			assert.True(t, out.Meta.Width == src.Meta.Width || out.Meta.Height == src.Meta.Height, "bars on one axis only")
+			if tt.width > 0 {
+				assert.Equal(t, tt.width, out.Meta.Width)
+				assert.Equal(t, tt.height, out.Meta.Height)
+			}
+		})
+	}
+	t.Run("bar color", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "find_trim.png"))
+		out, err := New().Process(ctx, blob, imagorpath.Parse("filters:letterbox(1,1,ff0000):format(png)/find_trim.png"), nil)
+		require.NoError(t, err)
+		buf, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(buf))
+		require.NoError(t, err)
+		c := color.NRGBAModel.Convert(img.At(256, 0)).(color.NRGBA)
+		assert.Equal(t, color.NRGBA{R: 0xff, A: 0xff}, c)
+	})
+	t.Run("invalid ratio ignored", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "find_trim.png"))
+		for _, path := range []string{
+			"filters:letterbox(0,9)/find_trim.png",
+			"filters:letterbox(16)/find_trim.png",
+			"filters:letterbox(a,b)/find_trim.png",
+		} {
+			out, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+			require.NoError(t, err)
+			assert.Equal(t, 512, out.Meta.Width)
+			assert.Equal(t, 320, out.Meta.Height)
+		}
+	})
+	t.Run("max size exceeded", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "find_trim.png"))
+		for _, path := range []string{
+			"filters:letterbox(1000,1)/find_trim.png",
+			"filters:letterbox(1,1000)/find_trim.png",
+		} {
+			_, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+			assert.Equal(t, imagor.ErrMaxSizeExceeded, err, path)
+		}
+	})
+}
+
+func TestBlurHash(t *testing.T) {
+	ctx := context.Background()
+	solid := func(c color.NRGBA) *image.NRGBA {
+		m := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(m, m.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		return m
+	}
+	decode83 := func(s string) (v int) {
+		for _, c := range s {
+			v = v*83 + strings.IndexRune(base83Chars, c)
+		}
+		return
+	}
+	t.Run("solid black", func(t *testing.T) {
+		assert.Equal(t, "L00000"+strings.Repeat("fQ", 11), encodeBlurHash(solid(color.NRGBA{A: 0xff}), 4, 3))
+	})
+	t.Run("solid color as dc", func(t *testing.T) {
+		hash := encodeBlurHash(solid(color.NRGBA{R: 0x12, G: 0x80, B: 0xfe, A: 0xff}), 4, 3)
+		assert.Equal(t, 0x1280fe, decode83(hash[2:6]))
+	})
+	t.Run("components", func(t *testing.T) {
+		m := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+		for y := 0; y < 10; y++ {
+			for x := 0; x < 20; x++ {
+				m.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 12), G: uint8(y * 25), B: 100, A: 0xff})
+			}
+		}
+		for _, c := range [][2]int{{1, 1}, {4, 3}, {9, 9}, {3, 7}} {
+			hash := encodeBlurHash(m, c[0], c[1])
+			assert.Len(t, hash, 4+2*c[0]*c[1])
+			assert.Equal(t, (c[0]-1)+(c[1]-1)*9, decode83(hash[:1]))
+		}
+	})
+	t.Run("parse args", func(t *testing.T) {
+		for args, xy := range map[string][2]int{
+			"":     {4, 3},
+			"5,6":  {5, 6},
+			"0,12": {1, 9},
+			"a,2":  {4, 2},
+			"7":    {7, 3},
+		} {
+			x, y := parseBlurHash(args)
+			assert.Equal(t, xy, [2]int{x, y}, args)
+		}
+	})
+	t.Run("meta", func(t *testing.T) {
+		for _, file := range []string{"demo1.jpg", "gopher.png", "dancing-banana.gif"} {
+			blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, file))
+			out, err := New().Process(ctx, blob, imagorpath.Parse("meta/fit-in/100x100/filters:blurhash(5,4)/"+file), nil)
+			require.NoError(t, err)
+			assert.Len(t, out.Meta.BlurHash, 4+2*5*4, file)
+
+			out, err = New().Process(ctx, blob, imagorpath.Parse("meta/fit-in/100x100/"+file), nil)
+			require.NoError(t, err)
+			assert.Empty(t, out.Meta.BlurHash, "not computed unless requested")
+		}
+	})
+}
+
+func TestBorder(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	// left half opaque green, right half transparent
+	draw.Draw(src, image.Rect(0, 0, 20, 30), image.NewUniform(color.NRGBA{G: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(t *testing.T, path string) (image.Image, *imagor.Meta) {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		return img, out.Meta
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	t.Run("width and color", func(t *testing.T) {
+		img, meta := process(t, "filters:border(5,ff0000)/image.png")
+		assert.Equal(t, 50, meta.Width)
+		assert.Equal(t, 40, meta.Height)
+		assert.Equal(t, image.Rect(0, 0, 50, 40), img.Bounds())
+		for _, p := range []image.Point{{0, 0}, {49, 39}, {4, 20}, {25, 4}, {45, 35}} {
+			assert.Equal(t, color.NRGBA{R: 0xff, A: 0xff}, at(img, p.X, p.Y), fmt.Sprintf("border %v", p))
+		}
+		assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, at(img, 10, 10))
+		assert.Equal(t, uint8(0), at(img, 35, 20).A, "image transparency kept within border")
+	})
+	t.Run("width x and y", func(t *testing.T) {
+		img, meta := process(t, "filters:border(2,6,000000)/image.png")
+		assert.Equal(t, 44, meta.Width)
+		assert.Equal(t, 42, meta.Height)
+		assert.Equal(t, color.NRGBA{A: 0xff}, at(img, 1, 20))
+		assert.Equal(t, color.NRGBA{A: 0xff}, at(img, 10, 5))
+		assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, at(img, 2, 6))
+	})
+	t.Run("transparent", func(t *testing.T) {
+		img, meta := process(t, "filters:border(5,transparent)/image.png")
+		assert.Equal(t, 50, meta.Width)
+		assert.Equal(t, uint8(0), at(img, 0, 0).A)
+		assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, at(img, 10, 10))
+	})
+	t.Run("transparent border of jpeg", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+		out, err := New().Process(ctx, blob, imagorpath.Parse("fit-in/100x100/filters:border(10,none)/demo1.jpg"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "png", out.Meta.Format)
+		assert.Equal(t, 120, out.Meta.Width)
+		assert.Equal(t, 120, out.Meta.Height)
+	})
+	t.Run("after resize", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+		out, err := New().Process(ctx, blob, imagorpath.Parse("meta/50x50/filters:border(3)/demo1.jpg"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, 56, out.Meta.Width)
+		assert.Equal(t, 56, out.Meta.Height)
+	})
+	t.Run("no border", func(t *testing.T) {
+		for _, path := range []string{"filters:border(0)/image.png", "filters:border(-5,red)/image.png", "filters:border(a)/image.png"} {
+			_, meta := process(t, path)
+			assert.Equal(t, 40, meta.Width)
+			assert.Equal(t, 30, meta.Height)
+		}
+	})
+}
+
+func TestPassthrough(t *testing.T) {
+	ctx := context.Background()
+	process := func(t *testing.T, v *VipsProcessor, file, path string) (src, res []byte, meta *imagor.Meta) {
+		src, err := ioutil.ReadFile(filepath.Join(testDataDir, file))
+		require.NoError(t, err)
+		out, err := v.Process(ctx, imagor.NewBlobBytes(src), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err = out.ReadAll()
+		require.NoError(t, err)
+		return src, res, out.Meta
+	}
+	t.Run("byte identical", func(t *testing.T) {
+		for _, tt := range []struct {
+			file, path, format string
+			width, height      int
+		}{
+			{"demo1.jpg", "demo1.jpg", "jpeg", 200, 200},
+			{"demo1.jpg", "200x200/demo1.jpg", "jpeg", 200, 200},
+			{"demo1.jpg", "fit-in/200x0/demo1.jpg", "jpeg", 200, 200},
+			{"demo1.jpg", "filters:format(jpeg)/demo1.jpg", "jpeg", 200, 200},
+			{"find_trim.png", "512x320/find_trim.png", "png", 512, 320},
+			{"find_trim.png", "0x320/find_trim.png", "png", 512, 320},
+			{"dancing-banana.gif", "dancing-banana.gif", "gif", 121, 128},
+		} {
+			src, res, meta := process(t, New(), tt.file, tt.path)
+			assert.True(t, bytes.Equal(src, res), tt.path)
+			assert.Equal(t, tt.format, meta.Format, tt.path)
+			assert.Equal(t, imageMimeTypeMap[tt.format], meta.ContentType, tt.path)
+			assert.Equal(t, tt.width, meta.Width, tt.path)
+			assert.Equal(t, tt.height, meta.Height, tt.path)
+		}
+	})
+	t.Run("transformed", func(t *testing.T) {
+		for _, path := range []string{
+			"100x100/demo1.jpg",
+			"200x100/demo1.jpg",
+			"fit-in/100x100/demo1.jpg",
+			"filters:format(png)/demo1.jpg",
+			"filters:quality(50)/demo1.jpg",
+			"filters:grayscale()/demo1.jpg",
+			"-200x200/demo1.jpg",
+			"10x10:100x100/demo1.jpg",
+			"fit-in/200x200/10x10/demo1.jpg",
+			"trim/demo1.jpg",
+		} {
+			src, res, _ := process(t, New(), "demo1.jpg", path)
+			assert.False(t, bytes.Equal(src, res), path)
+		}
+	})
+	t.Run("default format", func(t *testing.T) {
+		src, res, meta := process(t, New(WithDefaultFormat("webp")), "demo1.jpg", "demo1.jpg")
+		assert.False(t, bytes.Equal(src, res))
+		assert.Equal(t, "webp", meta.Format)
+	})
+	t.Run("max animation frames", func(t *testing.T) {
+		src, res, _ := process(t, New(WithMaxAnimationFrames(2)), "dancing-banana.gif", "dancing-banana.gif")
+		assert.False(t, bytes.Equal(src, res))
+	})
+	t.Run("max result dimensions", func(t *testing.T) {
+		src, res, _ := process(t, New(WithMaxResultWidth(100)), "demo1.jpg", "demo1.jpg")
+		assert.False(t, bytes.Equal(src, res))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		src, res, _ := process(t, New(WithDisablePassthrough(true)), "demo1.jpg", "demo1.jpg")
+		assert.False(t, bytes.Equal(src, res))
+	})
+	t.Run("svg not passed through", func(t *testing.T) {
+		svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10" fill="red"/></svg>`)
+		out, err := New().Process(ctx, imagor.NewBlobBytes(svg), imagorpath.Parse("image.svg"), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		assert.False(t, bytes.Equal(svg, res))
+		assert.NotEqual(t, "svg", out.Meta.Format)
+	})
+}
+
+func TestCollage(t *testing.T) {
+	ctx := context.Background()
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	green := color.NRGBA{G: 0xff, A: 0xff}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	black := color.NRGBA{A: 0xff}
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	// quadrants of red, green, blue and black
+	quadrants := func(alpha bool) []byte {
+		src := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+		for i, c := range []color.NRGBA{red, green, blue, black} {
+			r := image.Rect(i%2*20, i/2*20, i%2*20+20, i/2*20+20)
+			if alpha && i == 3 {
+				c = color.NRGBA{}
+			}
+			draw.Draw(src, r, image.NewUniform(c), image.Point{}, draw.Src)
+		}
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, src))
+		return buf.Bytes()
+	}
+	process := func(t *testing.T, buf []byte, path string) image.Image {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		return img
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	t.Run("2x2 panel placement", func(t *testing.T) {
+		// quadrants in reverse order
+		img := process(t, quadrants(false), "filters:collage(2x2,4,20,20,20,20,0,20,20,20,20,0,20,20,0,0,20,20)/image.png")
+		require.Equal(t, image.Rect(0, 0, 44, 44), img.Bounds())
+		assert.Equal(t, black, at(img, 10, 10), "top left")
+		assert.Equal(t, blue, at(img, 34, 10), "top right")
+		assert.Equal(t, green, at(img, 10, 34), "bottom left")
+		assert.Equal(t, red, at(img, 34, 34), "bottom right")
+		for _, p := range []image.Point{{21, 10}, {10, 22}, {22, 22}, {22, 43}} {
+			assert.Equal(t, white, at(img, p.X, p.Y), fmt.Sprintf("spacing %v", p))
+		}
+	})
+	t.Run("panels of first region dimensions", func(t *testing.T) {
+		// second region 40x20 cropped to fill 20x20 panel
+		img := process(t, quadrants(false), "filters:collage(2x1,0,0,0,20,20,0,20,40,20)/image.png")
+		require.Equal(t, image.Rect(0, 0, 40, 20), img.Bounds())
+		assert.Equal(t, red, at(img, 10, 10))
+		c := at(img, 30, 10)
+		assert.True(t, c == blue || c == black, "center crop of blue and black")
+	})
+	t.Run("transparent spacing and empty panels", func(t *testing.T) {
+		img := process(t, quadrants(true), "filters:collage(2x2,2,0,0,20,20,20,0,20,20,0,20,20,20)/image.png")
+		require.Equal(t, image.Rect(0, 0, 42, 42), img.Bounds())
+		assert.Equal(t, red, at(img, 10, 10))
+		assert.Equal(t, green, at(img, 32, 10))
+		assert.Equal(t, blue, at(img, 10, 32))
+		assert.Equal(t, uint8(0), at(img, 21, 10).A, "spacing")
+		assert.Equal(t, uint8(0), at(img, 32, 32).A, "empty panel")
+	})
+	t.Run("regions beyond layout ignored", func(t *testing.T) {
+		img := process(t, quadrants(false), "filters:collage(3x1,0,0,0,20,20,20,0,20,20,0,20,20,20,20,20,20,20)/image.png")
+		require.Equal(t, image.Rect(0, 0, 60, 20), img.Bounds())
+		assert.Equal(t, red, at(img, 10, 10))
+		assert.Equal(t, green, at(img, 30, 10))
+		assert.Equal(t, blue, at(img, 50, 10))
+	})
+	t.Run("invalid regions ignored", func(t *testing.T) {
+		for _, path := range []string{
+			"filters:collage(2x2,4)/image.png",
+			"filters:collage(2x2,4,0,0,20)/image.png",
+			"filters:collage(2x2,4,50,50,20,20)/image.png",
+		} {
+			img := process(t, quadrants(false), path)
+			assert.Equal(t, image.Rect(0, 0, 40, 40), img.Bounds(), path)
+		}
+	})
+	t.Run("max size exceeded", func(t *testing.T) {
+		_, err := New(WithMaxWidth(50)).Process(ctx, imagor.NewBlobBytes(quadrants(false)),
+			imagorpath.Parse("filters:collage(3x1,0,0,0,20,20,20,0,20,20)/image.png"), nil)
+		assert.Equal(t, imagor.ErrMaxSizeExceeded, err)
+	})
+}
+
+func TestPadding(t *testing.T) {
+	ctx := context.Background()
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{G: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	process := func(t *testing.T, path string) (image.Image, *imagor.Meta) {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, _, err := image.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		return img, out.Meta
+	}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	t.Run("transparent", func(t *testing.T) {
+		img, meta := process(t, "filters:padding(1,2,3,4)/image.png")
+		assert.Equal(t, 44, meta.Width)
+		assert.Equal(t, 36, meta.Height)
+		assert.Equal(t, image.Rect(0, 0, 44, 36), img.Bounds())
+		assert.Equal(t, uint8(0), at(img, 0, 10).A, "left")
+		assert.Equal(t, uint8(0), at(img, 10, 1).A, "top")
+		assert.Equal(t, uint8(0), at(img, 41, 10).A, "right")
+		assert.Equal(t, uint8(0), at(img, 10, 32).A, "bottom")
+		assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, at(img, 1, 2))
+		assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, at(img, 40, 31))
+	})
+	t.Run("color", func(t *testing.T) {
+		img, meta := process(t, "filters:padding(5,0,5,0,ff0000)/image.png")
+		assert.Equal(t, 50, meta.Width)
+		assert.Equal(t, 30, meta.Height)
+		assert.Equal(t, color.NRGBA{R: 0xff, A: 0xff}, at(img, 2, 15))
+		assert.Equal(t, color.NRGBA{R: 0xff, A: 0xff}, at(img, 47, 15))
+		assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, at(img, 5, 15))
+	})
+	t.Run("after resize regardless of fit-in", func(t *testing.T) {
+		for _, path := range []string{
+			"20x15/filters:padding(2,2,2,2,white)/image.png",
+			"fit-in/20x20/filters:padding(2,2,2,2,white)/image.png",
+		} {
+			_, meta := process(t, path)
+			assert.Equal(t, 24, meta.Width, path)
+			assert.Equal(t, 19, meta.Height, path)
+		}
+	})
+	t.Run("transparent padding of jpeg", func(t *testing.T) {
+		blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "demo1.jpg"))
+		out, err := New().Process(ctx, blob, imagorpath.Parse("fit-in/100x100/filters:padding(10,10,10,10)/demo1.jpg"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "png", out.Meta.Format)
+		assert.Equal(t, 120, out.Meta.Width)
+	})
+	t.Run("rejected", func(t *testing.T) {
+		for _, path := range []string{
+			"filters:padding(-1,0,0,0)/image.png",
+			"filters:padding(1,2,3)/image.png",
+			"filters:padding(1,a,3,4)/image.png",
+		} {
+			_, err := New().Process(ctx, imagor.NewBlobBytes(buf.Bytes()), imagorpath.Parse(path), nil)
+			assert.Equal(t, http.StatusBadRequest, imagor.WrapError(err).(imagor.Error).Code, path)
+		}
+		_, err := New(WithMaxWidth(100)).Process(ctx, imagor.NewBlobBytes(buf.Bytes()),
+			imagorpath.Parse("filters:padding(50,0,50,0)/image.png"), nil)
+		assert.Equal(t, imagor.ErrMaxSizeExceeded, err)
+	})
+}
+
+func TestMinSizeMaxSize(t *testing.T) {
+	ctx := context.Background()
+	blob := imagor.NewBlobFilePath(filepath.Join(testDataDir, "gopher-front.png"))
+	for _, path := range []string{
+		"filters:min_size(99999,99999)/gopher-front.png",
+		"filters:min_size(1,10000)/gopher-front.png",
+	} {
+		_, err := New().Process(ctx, blob, imagorpath.Parse(path), nil)
+		assert.Equal(t, imagor.ErrMaxSizeExceeded, err, path)
+	}
+	out, err := New(WithMaxWidth(600), WithMaxHeight(600)).Process(ctx, blob,
+		imagorpath.Parse("fit-in/500x500/filters:min_size(600,600)/gopher-front.png"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 600, out.Meta.Width)
+}
+
+func TestCMYKInvert(t *testing.T) {
+	ctx := context.Background()
+	// solid red stored inverted with Adobe APP14 marker, as produced by print tools
+	adobe, err := ioutil.ReadFile(filepath.Join(testDataDir, "cmyk-adobe.jpg"))
+	require.NoError(t, err)
+	// same JPEG without Adobe APP14 marker
+	i := bytes.Index(adobe, []byte("\xFF\xEE"))
+	require.Greater(t, i, 0)
+	noAdobe := append(append([]byte{}, adobe[:i]...), adobe[i+2+(int(adobe[i+2])<<8|int(adobe[i+3])):]...)
+
+	cmyk, hasAdobe := jpegCMYK(adobe)
+	assert.True(t, cmyk)
+	assert.True(t, hasAdobe)
+	cmyk, hasAdobe = jpegCMYK(noAdobe)
+	assert.True(t, cmyk)
+	assert.False(t, hasAdobe)
+	demo, err := ioutil.ReadFile(filepath.Join(testDataDir, "demo1.jpg"))
+	require.NoError(t, err)
+	cmyk, _ = jpegCMYK(demo)
+	assert.False(t, cmyk)
+	cmyk, _ = jpegCMYK(nil)
+	assert.False(t, cmyk)
+
+	isRed := func(c color.NRGBA) bool {
+		return c.R > 180 && c.G < 100 && c.B < 100
+	}
+	isDark := func(c color.NRGBA) bool {
+		return c.R < 100 && c.G < 100 && c.B < 100
+	}
+	tests := []struct {
+		name   string
+		mode   string
+		buf    []byte
+		expect func(c color.NRGBA) bool
+	}{
+		{"auto adobe", CMYKInvertAuto, adobe, isRed},
+		{"auto no adobe", CMYKInvertAuto, noAdobe, isDark},
+		{"always adobe", CMYKInvertAlways, adobe, isRed},
+		{"always no adobe", CMYKInvertAlways, noAdobe, isRed},
+		{"never adobe", CMYKInvertNever, adobe, isDark},
+		{"never no adobe", CMYKInvertNever, noAdobe, isDark},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{
+				"filters:format(png)/image.jpg",
+				"fit-in/8x8/filters:format(png)/image.jpg",
+				"0x0:8x8/filters:format(png)/image.jpg",
+			} {
+				out, err := New(WithCMYKInvert(tt.mode)).Process(ctx, imagor.NewBlobBytes(tt.buf), imagorpath.Parse(path), nil)
+				require.NoError(t, err)
+				res, err := out.ReadAll()
+				require.NoError(t, err)
+				img, err := png.Decode(bytes.NewReader(res))
+				require.NoError(t, err)
+				c := color.NRGBAModel.Convert(img.At(2, 2)).(color.NRGBA)
+				assert.True(t, tt.expect(c), fmt.Sprintf("%s %v", path, c))
+			}
+		})
+	}
+	t.Run("converted to srgb", func(t *testing.T) {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(adobe), imagorpath.Parse("0x0:8x8/image.jpg"), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, err := jpeg.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		_, ok := img.(*image.YCbCr)
+		assert.True(t, ok, "not CMYK")
+	})
+	t.Run("no passthrough", func(t *testing.T) {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(adobe), imagorpath.Parse("image.jpg"), nil)
+		require.NoError(t, err)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		assert.NotEqual(t, adobe, res)
+		img, err := jpeg.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		_, ok := img.(*image.YCbCr)
+		assert.True(t, ok, "not CMYK")
+	})
+	assert.Equal(t, CMYKInvertAuto, New(WithCMYKInvert("foo")).CMYKInvert)
+}
+
+func TestTextBox(t *testing.T) {
+	ctx := context.Background()
+	encode := func(t *testing.T, c color.NRGBA) []byte {
+		src := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+		draw.Draw(src, src.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, src))
+		return buf.Bytes()
+	}
+	process := func(t *testing.T, buf []byte, path string) image.Image {
+		out, err := New().Process(ctx, imagor.NewBlobBytes(buf), imagorpath.Parse(path), nil)
+		require.NoError(t, err)
+		assert.Equal(t, 300, out.Meta.Width)
+		assert.Equal(t, 200, out.Meta.Height)
+		res, err := out.ReadAll()
+		require.NoError(t, err)
+		img, _, err := image.Decode(bytes.NewReader(res))
+		require.NoError(t, err)
+		return img
+	}
+	// bounds of pixels differ from the background
+	bounds := func(img image.Image, bg color.NRGBA) image.Rectangle {
+		var r image.Rectangle
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA) != bg {
+					r = r.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		return r
+	}
+	black := color.NRGBA{A: 0xff}
+	buf := encode(t, black)
+	text := "Some%20long%20text%20wrapped%20in%20lines"
+
+	line := bounds(process(t, buf, "filters:text_box("+text+",20,30,0,ffffff,16)/image.png"), black)
+	require.False(t, line.Empty())
+	assert.Greater(t, line.Dx(), 100, "single line wider than max width")
+
+	box := bounds(process(t, buf, "filters:text_box("+text+",20,30,100,ffffff,16)/image.png"), black)
+	require.False(t, box.Empty())
+	assert.True(t, box.Min.X >= 20 && box.Min.X < 30, fmt.Sprintf("left %d", box.Min.X))
+	assert.True(t, box.Min.Y >= 30 && box.Min.Y < 40, fmt.Sprintf("top %d", box.Min.Y))
+	assert.True(t, box.Max.X <= 120, fmt.Sprintf("wrapped at max width %d", box.Max.X))
+	assert.Greater(t, box.Dy(), line.Dy()*2, "wrapped into multiple lines")
+
+	moved := bounds(process(t, buf, "filters:text_box("+text+",120,80,100,ffffff,16)/image.png"), black)
+	assert.Equal(t, box.Add(image.Pt(100, 50)), moved, "placement")
+
+	clipped := bounds(process(t, buf, "filters:text_box("+text+",250,180,0,ffffff,16)/image.png"), black)
+	assert.True(t, clipped.Max.X <= 300 && clipped.Max.Y <= 200, "overflow clipped")
+
+	t.Run("markup escaped", func(t *testing.T) {
+		for _, s := range []string{
+			"Tom%20%26%20Jerry",
+			"a%3Cb",
+			"%3Cspan%20size%3D%22999999%22%3Ebig%3C%2Fspan%3E",
+		} {
+			r := bounds(process(t, buf, "filters:text_box("+s+",20,30,0,ffffff,16)/image.png"), black)
+			assert.False(t, r.Empty(), s)
+			assert.True(t, r.Dy() < 40, fmt.Sprintf("%s rendered as plain text of size %d", s, r.Dy()))
+		}
+	})
+
+	t.Run("alpha", func(t *testing.T) {
+		transparent := color.NRGBA{}
+		img := process(t, encode(t, transparent), "filters:text_box("+text+",20,30,100,ff0000,16)/image.png")
+		r := bounds(img, transparent)
+		require.False(t, r.Empty())
+		assert.True(t, r.Min.X >= 20 && r.Max.X <= 120, "wrapped within box")
+		var opaque bool
+		for y := r.Min.Y; y < r.Max.Y && !opaque; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA); c == (color.NRGBA{R: 0xff, A: 0xff}) {
+					opaque = true
+					break
+				}
+			}
+		}
+		assert.True(t, opaque, "text opaque on transparent image")
+		assert.Equal(t, uint8(0), color.NRGBAModel.Convert(img.At(5, 5)).(color.NRGBA).A)
+	})
 }
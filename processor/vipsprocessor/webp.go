@@ -0,0 +1,45 @@
+package vipsprocessor
+
+import "github.com/davidbyttow/govips/v2/vips"
+
+// webpAlphaLevels number of alpha levels for alpha quality, as of libwebp
+// quality [0, 70] to levels [2, 16] and quality (70, 100] to levels (16, 256]
+func webpAlphaLevels(quality int) int {
+	if quality <= 70 {
+		return 2 + quality/5
+	}
+	return 16 + (quality-70)*8
+}
+
+// quantizeAlpha reduces alpha channel to fewer levels independent of color quality,
+// such that alpha plane compresses smaller on WebP lossless alpha encoding
+func quantizeAlpha(img *vips.ImageRef, quality int) error {
+	if quality < 0 || quality >= 100 || !img.HasAlpha() {
+		return nil
+	}
+	if i := img.Interpretation(); i == vips.InterpretationRGB16 || i == vips.InterpretationGrey16 {
+		return nil
+	}
+	var (
+		n    = img.Bands()
+		step = 255 / float64(webpAlphaLevels(quality)-1)
+		a    = make([]float64, n)
+		b    = make([]float64, n)
+	)
+	for i := range a {
+		a[i] = 1
+	}
+	// round alpha to the nearest level
+	a[n-1], b[n-1] = 1/step, 0.5
+	if err := img.Linear(a, b); err != nil {
+		return err
+	}
+	if err := img.Cast(vips.BandFormatUchar); err != nil {
+		return err
+	}
+	a[n-1], b[n-1] = step, 0.5
+	if err := img.Linear(a, b); err != nil {
+		return err
+	}
+	return img.Cast(vips.BandFormatUchar)
+}
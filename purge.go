@@ -0,0 +1,73 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Purge deletes stored results of request paths from result savers implementing Deleter,
+// e.g. when source image updated. Paths are signed or unsafe as of request URL e.g. unsafe/fit-in/200x200/image.jpg
+func (app *Imagor) Purge(ctx context.Context, paths ...string) (err error) {
+	for _, path := range paths {
+		p := imagorpath.Parse(strings.TrimSpace(path))
+		if e := app.delete(ctx, app.ResultSavers, strings.TrimPrefix(p.Path, "meta/")); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// PurgeSource deletes stored source images from savers implementing Deleter
+func (app *Imagor) PurgeSource(ctx context.Context, images ...string) (err error) {
+	for _, image := range images {
+		if e := app.delete(ctx, app.Savers, image); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (app *Imagor) delete(ctx context.Context, savers []Saver, key string) (err error) {
+	for _, saver := range savers {
+		deleter, ok := saver.(Deleter)
+		if !ok {
+			continue
+		}
+		if e := deleter.Delete(ctx, key); e != nil && e != ErrPass {
+			app.Logger.Warn("purge", zap.String("key", key), zap.Error(e))
+			err = e
+		} else if app.Debug {
+			app.Logger.Debug("purge", zap.String("key", key))
+		}
+	}
+	return
+}
+
+// servePurge purges result of signed request path, and source image if source query param is true.
+// Requires signature of secret regardless of unsafe, refused if secret not configured
+// as signature of empty secret can be forged by anyone
+func (app *Imagor) servePurge(w http.ResponseWriter, r *http.Request, p imagorpath.Params) {
+	if app.Secret == "" || p.Unsafe || app.Signer.Sign(p.Path) != p.Hash {
+		w.WriteHeader(ErrSignatureMismatch.Code)
+		app.resJSON(w, r, ErrSignatureMismatch)
+		return
+	}
+	ctx := r.Context()
+	err := app.Purge(ctx, p.Path)
+	if source, _ := strconv.ParseBool(r.URL.Query().Get("source")); source && p.Image != "" {
+		if e := app.PurgeSource(ctx, p.Image); e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		e := WrapError(err).(Error)
+		w.WriteHeader(e.Code)
+		app.resJSON(w, r, e)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
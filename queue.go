@@ -0,0 +1,110 @@
+package imagor
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// processQueue limits the number of concurrent image processing,
+// admitting queued requests of higher priority first, then by arrival order
+type processQueue struct {
+	mu      sync.Mutex
+	size    int
+	cur     int
+	seq     int64
+	waiters queueWaiters
+}
+
+type queueWaiter struct {
+	priority int
+	seq      int64
+	index    int
+	ready    chan struct{}
+}
+
+type queueWaiters []*queueWaiter
+
+func (q queueWaiters) Len() int { return len(q) }
+
+func (q queueWaiters) Less(i, j int) bool {
+	if q[i].priority == q[j].priority {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].priority > q[j].priority
+}
+
+func (q queueWaiters) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queueWaiters) Push(x interface{}) {
+	w := x.(*queueWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *queueWaiters) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
+
+func newProcessQueue(size int) *processQueue {
+	return &processQueue{size: size}
+}
+
+// Acquire blocks until a slot is available or ctx is done
+func (q *processQueue) Acquire(ctx context.Context, priority int) error {
+	q.mu.Lock()
+	if q.cur < q.size && len(q.waiters) == 0 {
+		q.cur++
+		q.mu.Unlock()
+		return nil
+	}
+	q.seq++
+	w := &queueWaiter{priority: priority, seq: q.seq, ready: make(chan struct{})}
+	heap.Push(&q.waiters, w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&q.waiters, w.index)
+			q.mu.Unlock()
+		} else {
+			// slot handed over at the same time, pass it on
+			q.mu.Unlock()
+			q.Release()
+		}
+		return ctx.Err()
+	}
+}
+
+// Release frees up a slot, handing over to the next waiter if any
+func (q *processQueue) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) > 0 {
+		w := heap.Pop(&q.waiters).(*queueWaiter)
+		close(w.ready)
+		return
+	}
+	q.cur--
+}
+
+// Waiting number of requests queued
+func (q *processQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
@@ -0,0 +1,23 @@
+package imagor
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestProcessQueue(t *testing.T) {
+	q := newProcessQueue(1)
+	require.NoError(t, q.Acquire(context.Background(), 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Acquire(ctx, 10))
+	assert.Equal(t, 0, q.Waiting(), "should remove waiter on context done")
+
+	q.Release()
+	require.NoError(t, q.Acquire(context.Background(), 0))
+	q.Release()
+}
@@ -0,0 +1,43 @@
+package imagor
+
+import (
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// logSampler limits logs to n per second per key
+type logSampler struct {
+	mu     sync.Mutex
+	n      int
+	window time.Time
+	counts map[string]int
+	now    func() time.Time
+}
+
+func newLogSampler(n int) *logSampler {
+	return &logSampler{n: n, counts: map[string]int{}, now: time.Now}
+}
+
+// allow checks if key logged fewer than n times within the current second
+func (s *logSampler) allow(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.window) >= time.Second {
+		s.window = now
+		s.counts = map[string]int{}
+	}
+	s.counts[key]++
+	return s.counts[key] <= s.n
+}
+
+// warn logs error, sampled per message and error status code if ErrorLogSampling configured
+func (app *Imagor) warn(msg string, err error, fields ...zap.Field) {
+	if app.sampler != nil {
+		if e, ok := WrapError(err).(Error); ok && !app.sampler.allow(msg+":"+strconv.Itoa(e.Code)) {
+			return
+		}
+	}
+	app.Logger.Warn(msg, append(fields, zap.Error(err))...)
+}
@@ -0,0 +1,85 @@
+package imagor
+
+import (
+	"github.com/cshum/imagor/imagorpath"
+	"path"
+	"strings"
+)
+
+// ScopedSecret secret key that signs only the whitelisted transformations,
+// such that leaked URL or secret cannot be used for arbitrary transformations.
+// Transformation is the request path without hash and image e.g. fit-in/200x200/filters:format(webp)
+type ScopedSecret struct {
+	Secret     string
+	Transforms []string
+
+	signer imagorpath.Signer
+}
+
+// allows checks if params signed by the scoped secret with whitelisted transformation
+func (s ScopedSecret) allows(p imagorpath.Params) bool {
+	if s.Secret == "" {
+		return false
+	}
+	signer := s.signer
+	if signer == nil {
+		signer = imagorpath.NewDefaultSigner(s.Secret)
+	}
+	if signer.Sign(p.Path) != p.Hash {
+		return false
+	}
+	transform := transformOf(p)
+	for _, t := range s.Transforms {
+		if transformOf(imagorpath.Parse("unsafe/"+strings.Trim(t, "/")+"/image")) == transform {
+			return true
+		}
+	}
+	return false
+}
+
+// transformOf normalized transformation of params without image
+func transformOf(p imagorpath.Params) string {
+	p.Image = ""
+	return strings.Trim(strings.TrimPrefix(imagorpath.GenerateUnsafe(p), "unsafe/"), "/")
+}
+
+// allowUnsafe checks if image of unsafe params matches UnsafePrefixes on path segment boundary,
+// ignoring http or https scheme of source URL
+func (app *Imagor) allowUnsafe(p imagorpath.Params) bool {
+	if len(app.UnsafePrefixes) == 0 {
+		return true
+	}
+	// cleaned against traversal e.g. public/../private.jpg
+	image := strings.TrimPrefix(path.Clean("/"+trimScheme(p.Image)), "/")
+	for _, prefix := range app.UnsafePrefixes {
+		if hasPathPrefix(image, trimScheme(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix checks if s begins with prefix ending at a path segment boundary,
+// such that example.com does not match example.com.evil.com/x.jpg
+func hasPathPrefix(s, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return s == prefix || strings.HasPrefix(s, prefix+"/")
+}
+
+func trimScheme(s string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+}
+
+// verify checks params signature by signer or scoped secrets.
+// Signature of the default signer of empty secret can be forged hence not accepted
+func (app *Imagor) verify(p imagorpath.Params) bool {
+	if (app.Secret != "" || app.customSigner) && app.Signer.Sign(p.Path) == p.Hash {
+		return true
+	}
+	for _, s := range app.ScopedSecrets {
+		if s.allows(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var dotFileRegex = regexp.MustCompile("/\\.")
@@ -21,6 +22,7 @@ type FileStorage struct {
 	WritePermission os.FileMode
 	SaveErrIfExists bool
 	SafeChars       string
+	Expiration      time.Duration
 
 	safeChars map[byte]bool
 }
@@ -81,12 +83,18 @@ func (s *FileStorage) Load(_ *http.Request, image string) (*imagor.Blob, error)
 	if !ok {
 		return nil, imagor.ErrPass
 	}
-	if _, err := os.Stat(image); err != nil {
+	stats, err := os.Stat(image)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, imagor.ErrNotFound
 		}
 		return nil, err
 	}
+	if s.Expiration > 0 && time.Since(stats.ModTime()) > s.Expiration {
+		// self-clean expired file
+		_ = os.Remove(image)
+		return nil, imagor.ErrNotFound
+	}
 	return imagor.NewBlobFilePath(image), nil
 }
 
@@ -116,3 +124,14 @@ func (s *FileStorage) Save(_ context.Context, image string, blob *imagor.Blob) (
 	}
 	return
 }
+
+func (s *FileStorage) Delete(_ context.Context, image string) error {
+	image, ok := s.Path(image)
+	if !ok {
+		return imagor.ErrPass
+	}
+	if err := os.Remove(image); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func TestFileStore_Path(t *testing.T) {
@@ -142,6 +144,36 @@ func TestFileStore_Load_Store(t *testing.T) {
 		assert.Equal(t, "bar", string(buf))
 	})
 
+	t.Run("delete", func(t *testing.T) {
+		s := New(dir)
+		require.NoError(t, s.Save(ctx, "/foo/delete/asdf", imagor.NewBlobBytes([]byte("bar"))))
+		require.NoError(t, s.Delete(ctx, "/foo/delete/asdf"))
+		_, err := s.Load(&http.Request{}, "/foo/delete/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		assert.NoError(t, s.Delete(ctx, "/foo/delete/asdf"), "deleting missing file")
+		assert.Equal(t, imagor.ErrPass, s.Delete(ctx, "/abc/.git"))
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		s := New(dir, WithExpiration(time.Hour), WithSaveErrIfExists(true))
+		require.NoError(t, s.Save(ctx, "/foo/expire/asdf", imagor.NewBlobBytes([]byte("bar"))))
+		b, err := s.Load(&http.Request{}, "/foo/expire/asdf")
+		require.NoError(t, err)
+		buf, err := b.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf))
+
+		path, _ := s.Path("/foo/expire/asdf")
+		past := time.Now().Add(-time.Hour * 2)
+		require.NoError(t, os.Chtimes(path, past, past))
+		_, err = s.Load(&http.Request{}, "/foo/expire/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "expired file removed")
+		// saved again once expired
+		require.NoError(t, s.Save(ctx, "/foo/expire/asdf", imagor.NewBlobBytes([]byte("boo"))))
+	})
+
 	t.Run("save err if exists", func(t *testing.T) {
 		s := New(dir, WithSaveErrIfExists(true))
 		require.NoError(t, s.Save(ctx, "/foo/bar/asdf", imagor.NewBlobBytes([]byte("bar"))))
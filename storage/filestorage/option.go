@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Option func(h *FileStorage)
@@ -62,3 +63,12 @@ func WithSafeChars(chars string) Option {
 		}
 	}
 }
+
+// WithExpiration file expires after duration since last saved, not loaded and removed on load
+func WithExpiration(exp time.Duration) Option {
+	return func(h *FileStorage) {
+		if exp > 0 {
+			h.Expiration = exp
+		}
+	}
+}
@@ -3,6 +3,7 @@ package s3storage
 import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"strings"
+	"time"
 )
 
 type Option func(h *S3Storage)
@@ -54,3 +55,12 @@ func WithSafeChars(chars string) Option {
 		}
 	}
 }
+
+// WithExpiration object expires after duration since last saved, not loaded and with Expires metadata
+func WithExpiration(exp time.Duration) Option {
+	return func(h *S3Storage) {
+		if exp > 0 {
+			h.Expiration = exp
+		}
+	}
+}
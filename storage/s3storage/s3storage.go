@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type S3Storage struct {
@@ -26,6 +27,7 @@ type S3Storage struct {
 	PathPrefix string
 	ACL        string
 	SafeChars  string
+	Expiration time.Duration
 
 	safeChars map[byte]bool
 }
@@ -101,6 +103,10 @@ func (s *S3Storage) Load(r *http.Request, image string) (*imagor.Blob, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	defer out.Body.Close()
+	if s.Expiration > 0 && out.LastModified != nil && time.Since(*out.LastModified) > s.Expiration {
+		return nil, imagor.ErrNotFound
+	}
 	buf, err := io.ReadAll(out.Body)
 	if err != nil {
 		return nil, err
@@ -124,6 +130,21 @@ func (s *S3Storage) Save(ctx context.Context, image string, blob *imagor.Blob) e
 		ContentType: aws.String(mime.TypeByExtension(filepath.Ext(image))),
 		Key:         aws.String(image),
 	}
+	if s.Expiration > 0 {
+		input.Expires = aws.Time(time.Now().Add(s.Expiration))
+	}
 	_, err = s.Uploader.UploadWithContext(ctx, input)
 	return err
 }
+
+func (s *S3Storage) Delete(ctx context.Context, image string) error {
+	image, ok := s.Path(image)
+	if !ok {
+		return imagor.ErrPass
+	}
+	_, err := s.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(image),
+	})
+	return err
+}
@@ -0,0 +1,49 @@
+package imagor
+
+import (
+	"context"
+)
+
+// Span attribute keys
+const (
+	AttrKey          = "imagor.key"
+	AttrCacheHit     = "imagor.cache_hit"
+	AttrFormat       = "imagor.format"
+	AttrProcessor    = "imagor.processor"
+	AttrDeduplicated = "imagor.deduplicated"
+)
+
+// Span a traced operation of the request lifecycle
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts span as child of the span of context, if any,
+// returning context of the started span e.g. adapter of OpenTelemetry tracer
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+func (noopSpan) RecordError(error) {}
+
+func (noopSpan) End() {}
+
+// endSpan records error if any, except canceled and pass, and ends span
+func endSpan(span Span, err error) {
+	if err != nil && err != context.Canceled && err != ErrPass {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,42 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// WarmResult result of warming a request path
+type WarmResult struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+// Warm processes and stores request paths ahead of user requests,
+// such that results are served from result storage.
+// Paths are signed or unsafe as of request URL e.g. unsafe/fit-in/200x200/image.jpg
+func (app *Imagor) Warm(ctx context.Context, paths ...string) (results []WarmResult) {
+	for _, path := range paths {
+		path = "/" + strings.TrimPrefix(strings.TrimSpace(path), "/")
+		res := WarmResult{Path: path}
+		if err := ctx.Err(); err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err == nil {
+			_, err = app.Do(r, imagorpath.Parse(r.URL.EscapedPath()))
+		}
+		if err != nil {
+			res.Error = WrapError(err).Error()
+			app.Logger.Warn("warm", zap.String("path", path), zap.Error(err))
+		} else if app.Debug {
+			app.Logger.Debug("warm", zap.String("path", path))
+		}
+		results = append(results, res)
+	}
+	return
+}